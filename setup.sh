@@ -5,12 +5,12 @@ ROOT_DIR="$(cd "$(dirname "$0")" && pwd)"
 OUT_NAME=netxp
 
 echo "Building netxp..."
-cd "$ROOT_DIR"
-# fetch dependencies (liner)
+# the module lives in src; build it in module mode whatever the environment says
+cd "$ROOT_DIR/src"
 echo "fetching dependencies..."
-go get github.com/peterh/liner
-# build (show only first 50 lines)
-go build -o "$OUT_NAME" main.go 2>&1 | head -50
+GO111MODULE=on go mod download
+GO111MODULE=on go build -o "$ROOT_DIR/$OUT_NAME" .
+cd "$ROOT_DIR"
 
 INSTALL_DIRS=("$HOME/.local/bin" "/usr/local/bin" "$HOME/bin")
 installed="false"
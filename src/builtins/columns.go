@@ -0,0 +1,205 @@
+package builtins
+
+import (
+	"fmt"
+	"strings"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+// assignment reads "column = expression" as given to insert and update
+func assignment(name string, args []string) (string, *expr.Expr, *ExecutionError) {
+	usage := []string{fmt.Sprintf("usage: %s <column> = <expression>, e.g. %s kb = size / 1024", name, name)}
+	text := strings.Join(args, " ")
+	i := strings.Index(text, "=")
+	if i < 0 || strings.HasPrefix(text[i:], "==") {
+		return "", nil, NewError(name, 2, "missing '='", usage)
+	}
+	col := strings.Trim(strings.TrimSpace(text[:i]), "`")
+	if col == "" {
+		return "", nil, NewError(name, 2, "missing column name", usage)
+	}
+	e, err := expr.Parse(text[i+1:])
+	if err != nil {
+		return "", nil, NewError(name, 2, err.Error(), usage)
+	}
+	return col, e, nil
+}
+
+// records returns the rows of the input as records and the columns of a
+// table, or an error naming the first row that is not a record
+func records(name string, input values.Value) ([]*values.Record, []string, *ExecutionError) {
+	items, columns := rows(input)
+	recs := make([]*values.Record, len(items))
+	for i, item := range items {
+		r, ok := item.(*values.Record)
+		if !ok {
+			return nil, nil, NewError(name, 1, fmt.Sprintf("row %d is a %s, not a record", i, item.Kind()), []string{"pipe a table or a record into " + name})
+		}
+		recs[i] = r
+	}
+	if columns == nil && len(recs) == 1 {
+		columns = recs[0].Keys()
+	}
+	return recs, columns, nil
+}
+
+// reshaped returns the new rows in the shape of the input: a record for a
+// record, otherwise a table with the given columns
+func reshaped(input values.Value, recs []*values.Record, columns []string) values.Value {
+	if _, ok := input.(*values.Record); ok && len(recs) == 1 {
+		return recs[0]
+	}
+	return &values.Table{Columns: columns, Rows: recs}
+}
+
+// compute sets col on a copy of every row to the value of e
+func compute(name string, recs []*values.Record, col string, e *expr.Expr) ([]*values.Record, *ExecutionError) {
+	out := make([]*values.Record, len(recs))
+	for i, r := range recs {
+		v, err := e.Eval(r)
+		if err != nil {
+			ee := NewError(name, 1, fmt.Sprintf("row %d: %s", i, err), nil)
+			ee.Context = values.NewRecord("row", i, "value", r)
+			return nil, ee
+		}
+		out[i] = r.Copy()
+		out[i].Set(col, v)
+	}
+	return out, nil
+}
+
+// CmdInsert adds a column computed from the other fields of each row
+func CmdInsert(name string, args []string, input values.Value) (values.Value, error) {
+	col, e, ee := assignment(name, args)
+	if ee != nil {
+		return ee, nil
+	}
+	recs, columns, ee := records(name, input)
+	if ee != nil {
+		return ee, nil
+	}
+	for _, c := range columns {
+		if c == col {
+			return StructuredError(name, 1, fmt.Sprintf("column '%s' already exists", col), []string{"change it with: update " + col + " = ..."}), nil
+		}
+	}
+	out, ee := compute(name, recs, col, e)
+	if ee != nil {
+		return ee, nil
+	}
+	return reshaped(input, out, append(columns[:len(columns):len(columns)], col)), nil
+}
+
+// CmdUpdate replaces a column with a value computed from each row
+func CmdUpdate(name string, args []string, input values.Value) (values.Value, error) {
+	col, e, ee := assignment(name, args)
+	if ee != nil {
+		return ee, nil
+	}
+	recs, columns, ee := records(name, input)
+	if ee != nil {
+		return ee, nil
+	}
+	if _, ee := pickColumns(name, columns, []string{col}); ee != nil {
+		ee.Hints = append(ee.Hints, "add it with: insert "+col+" = ...")
+		return ee, nil
+	}
+	out, ee := compute(name, recs, col, e)
+	if ee != nil {
+		return ee, nil
+	}
+	return reshaped(input, out, columns), nil
+}
+
+// CmdRename renames columns: rename old new [old2 new2 ...]
+func CmdRename(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return StructuredError(name, 2, "expected pairs of old and new names", []string{"usage: rename <old> <new> [<old> <new> ...]"}), nil
+	}
+	recs, columns, ee := records(name, input)
+	if ee != nil {
+		return ee, nil
+	}
+	renames := make(map[string]string)
+	var olds []string
+	for i := 0; i < len(args); i += 2 {
+		renames[args[i]] = args[i+1]
+		olds = append(olds, args[i])
+	}
+	if _, ee := pickColumns(name, columns, olds); ee != nil {
+		return ee, nil
+	}
+	rename := func(keys []string) []string {
+		out := make([]string, len(keys))
+		for i, k := range keys {
+			if n, ok := renames[k]; ok {
+				k = n
+			}
+			out[i] = k
+		}
+		return out
+	}
+	newColumns := rename(columns)
+	seen := make(map[string]bool)
+	for _, c := range newColumns {
+		if seen[c] {
+			return StructuredError(name, 1, fmt.Sprintf("column '%s' would appear twice", c), []string{"reject one of them first"}), nil
+		}
+		seen[c] = true
+	}
+	out := make([]*values.Record, len(recs))
+	for i, r := range recs {
+		n := values.NewRecord()
+		for _, k := range r.Keys() {
+			v, _ := r.Get(k)
+			if nk, ok := renames[k]; ok {
+				k = nk
+			}
+			n.Set(k, v)
+		}
+		out[i] = n
+	}
+	return reshaped(input, out, newColumns), nil
+}
+
+// CmdReject removes columns
+func CmdReject(name string, args []string, input values.Value) (values.Value, error) {
+	var cols []string
+	for _, arg := range args {
+		for _, c := range strings.Split(arg, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				cols = append(cols, c)
+			}
+		}
+	}
+	if len(cols) == 0 {
+		return StructuredError(name, 2, "missing column", []string{"usage: reject <col> [col ...]"}), nil
+	}
+	recs, columns, ee := records(name, input)
+	if ee != nil {
+		return ee, nil
+	}
+	if _, ee := pickColumns(name, columns, cols); ee != nil {
+		return ee, nil
+	}
+	drop := make(map[string]bool)
+	for _, c := range cols {
+		drop[c] = true
+	}
+	var kept []string
+	for _, c := range columns {
+		if !drop[c] {
+			kept = append(kept, c)
+		}
+	}
+	out := make([]*values.Record, len(recs))
+	for i, r := range recs {
+		out[i] = r.Copy()
+		for _, c := range cols {
+			out[i].Delete(c)
+		}
+	}
+	return reshaped(input, out, kept), nil
+}
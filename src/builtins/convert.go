@@ -0,0 +1,159 @@
+package builtins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"netxp/values"
+)
+
+// documentText returns the text of a document piped into a from-*
+// builtin. Lines that arrive as a list are joined again.
+func documentText(input values.Value) (string, bool) {
+	switch x := input.(type) {
+	case values.String:
+		return string(x), true
+	case values.Binary:
+		return string(x), true
+	case values.List:
+		lines := make([]string, len(x))
+		for i, item := range x {
+			s, ok := item.(values.String)
+			if !ok {
+				return "", false
+			}
+			lines[i] = string(s)
+		}
+		return strings.Join(lines, "\n"), true
+	}
+	return "", false
+}
+
+// fromFormat parses piped text with a decoder. Input that is already
+// structured, such as a file cat decoded, passes through.
+func fromFormat(name, format string, input values.Value, decode func([]byte) (values.Value, error)) values.Value {
+	if values.IsNothing(input) {
+		return StructuredError(name, 1, "no input", []string{fmt.Sprintf("pipe %s text into %s, e.g. cat file.%s | %s", format, name, format, name)})
+	}
+	text, ok := documentText(input)
+	if !ok {
+		return input
+	}
+	v, err := decode([]byte(text))
+	if err != nil {
+		msg := err.Error()
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			line := strings.Count(text[:clamp(int(se.Offset), len(text))], "\n") + 1
+			msg = fmt.Sprintf("json: line %d: %s", line, se.Error())
+		}
+		return StructuredError(name, 1, msg, []string{"check that the input is valid " + strings.ToUpper(format)})
+	}
+	return v
+}
+
+// CmdFromJSON parses JSON text into structured values
+func CmdFromJSON(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) > 0 {
+		return StructuredError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{"usage: from-json"}), nil
+	}
+	return fromFormat(name, "json", input, values.ParseJSON), nil
+}
+
+// CmdToJSON writes the input as JSON, on one line or indented with -p
+func CmdToJSON(name string, args []string, input values.Value) (values.Value, error) {
+	indent := ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-p", "--pretty":
+			indent = "  "
+		case "-i", "--indent":
+			n := -1
+			if i+1 < len(args) {
+				n, _ = strconv.Atoi(args[i+1])
+				i++
+			}
+			if n < 0 {
+				return StructuredError(name, 2, "--indent needs a number of spaces", []string{"usage: to-json [-p] [--indent N]"}), nil
+			}
+			indent = strings.Repeat(" ", n)
+		default:
+			return StructuredError(name, 2, fmt.Sprintf("unknown option '%s'", args[i]), []string{"usage: to-json [-p] [--indent N]"}), nil
+		}
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(jsonable(input)); err != nil {
+		return StructuredError(name, 1, err.Error(), nil), nil
+	}
+	return values.String(strings.TrimSuffix(b.String(), "\n")), nil
+}
+
+// jsonable makes nothing encode as null
+func jsonable(v values.Value) interface{} {
+	if values.IsNothing(v) {
+		return nil
+	}
+	return v
+}
+
+// CmdFromYAML parses YAML text; several documents give a list
+func CmdFromYAML(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) > 0 {
+		return StructuredError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{"usage: from-yaml"}), nil
+	}
+	return fromFormat(name, "yaml", input, values.ParseYAML), nil
+}
+
+// CmdToYAML writes the input as a YAML document
+func CmdToYAML(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) > 0 {
+		return StructuredError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{"usage: to-yaml"}), nil
+	}
+	return values.String(strings.TrimSuffix(string(values.EncodeYAML(input)), "\n")), nil
+}
+
+// CmdFromTOML parses a TOML document into a record
+func CmdFromTOML(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) > 0 {
+		return StructuredError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{"usage: from-toml"}), nil
+	}
+	return fromFormat(name, "toml", input, values.ParseTOML), nil
+}
+
+// CmdToTOML writes a record as a TOML document
+func CmdToTOML(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) > 0 {
+		return StructuredError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{"usage: to-toml"}), nil
+	}
+	out, err := values.EncodeTOML(input)
+	if err != nil {
+		return StructuredError(name, 1, err.Error(), []string{"toml documents are records; use to-json or to-yaml for tables and lists"}), nil
+	}
+	return values.String(strings.TrimSuffix(string(out), "\n")), nil
+}
+
+// CmdFromXML parses an XML document. Attributes become @name fields and
+// mixed text a #text field.
+func CmdFromXML(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) > 0 {
+		return StructuredError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{"usage: from-xml"}), nil
+	}
+	return fromFormat(name, "xml", input, func(data []byte) (values.Value, error) {
+		return values.ParseXML(data, inferValue)
+	}), nil
+}
+
+// CmdToXML writes the input as an XML document
+func CmdToXML(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) > 0 {
+		return StructuredError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{"usage: to-xml"}), nil
+	}
+	return values.String(strings.TrimSuffix(string(values.EncodeXML(input)), "\n")), nil
+}
@@ -0,0 +1,342 @@
+package builtins
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"netxp/values"
+)
+
+// csvOptions are the flags shared by the csv and tsv builtins
+type csvOptions struct {
+	delim   rune
+	header  int // 1 the first row is a header, -1 it is data, 0 detect
+	columns []string
+	infer   bool
+	comment rune
+	trim    bool
+}
+
+const (
+	fromCSVUsage = "usage: from-csv [-d <delimiter>] [--header|--no-header] [--columns a,b] [--no-infer] [--comment <char>] [--trim]"
+	toCSVUsage   = "usage: to-csv [-d <delimiter>] [--no-header] [--columns a,b]"
+)
+
+// parseDelimiter reads a delimiter given as a single character, \t or tab
+func parseDelimiter(s string) (rune, bool) {
+	switch strings.ToLower(s) {
+	case `\t`, "tab":
+		return '\t', true
+	case "space":
+		return ' ', true
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) || r == '"' || r == '\r' || r == '\n' {
+		return 0, false
+	}
+	return r, true
+}
+
+func parseCSVArgs(name string, args []string, delim rune, usage string) (*csvOptions, *ExecutionError) {
+	opts := &csvOptions{delim: delim, infer: true}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, *ExecutionError) {
+			if i+1 >= len(args) {
+				return "", NewError(name, 2, arg+" needs a value", []string{usage})
+			}
+			i++
+			return args[i], nil
+		}
+		switch arg {
+		case "-d", "--delimiter", "--comment":
+			v, e := value()
+			if e != nil {
+				return nil, e
+			}
+			r, ok := parseDelimiter(v)
+			if !ok {
+				return nil, NewError(name, 2, fmt.Sprintf("invalid %s '%s': use a single character", strings.TrimLeft(arg, "-"), v), []string{usage})
+			}
+			if arg == "--comment" {
+				opts.comment = r
+			} else {
+				opts.delim = r
+			}
+		case "--header":
+			opts.header = 1
+		case "--no-header", "-n":
+			opts.header = -1
+		case "-c", "--columns":
+			v, e := value()
+			if e != nil {
+				return nil, e
+			}
+			for _, c := range strings.Split(v, ",") {
+				if c = strings.TrimSpace(c); c != "" {
+					opts.columns = append(opts.columns, c)
+				}
+			}
+		case "--no-infer":
+			opts.infer = false
+		case "--trim":
+			opts.trim = true
+		default:
+			return nil, NewError(name, 2, fmt.Sprintf("unknown option '%s'", arg), []string{usage})
+		}
+	}
+	return opts, nil
+}
+
+// CmdFromCSV turns comma separated text into a table, row by row
+func CmdFromCSV(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	return fromDelimited(ctx, name, args, ',', fromCSVUsage, in, out)
+}
+
+// CmdFromTSV turns tab separated text into a table, row by row
+func CmdFromTSV(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	return fromDelimited(ctx, name, args, '\t', strings.Replace(fromCSVUsage, "csv", "tsv", 1), in, out)
+}
+
+// CmdToCSV writes records as comma separated lines
+func CmdToCSV(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	return toDelimited(ctx, name, args, ',', toCSVUsage, in, out)
+}
+
+// CmdToTSV writes records as tab separated lines
+func CmdToTSV(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	return toDelimited(ctx, name, args, '\t', strings.Replace(toCSVUsage, "csv", "tsv", 1), in, out)
+}
+
+// textReader streams the text of incoming records as lines, so a reader
+// can parse input that is still arriving
+func textReader(in <-chan values.Value) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		for rec := range in {
+			text := values.Text(rec)
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			if _, err := io.WriteString(w, text); err != nil {
+				// the reader stopped; leave the rest unread
+				return
+			}
+		}
+		w.Close()
+	}()
+	return r
+}
+
+func fromDelimited(ctx context.Context, name string, args []string, delim rune, usage string, in <-chan values.Value, out chan<- values.Value) error {
+	opts, e := parseCSVArgs(name, args, delim, usage)
+	if e != nil {
+		return e
+	}
+	src := textReader(in)
+	defer src.Close()
+	r := csv.NewReader(src)
+	r.Comma = opts.delim
+	r.Comment = opts.comment
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = opts.trim
+	// tab separated files rarely follow the quoting rules of csv
+	r.LazyQuotes = opts.delim == '\t'
+
+	read := func() ([]string, error) {
+		row, err := r.Read()
+		if err != nil && err != io.EOF {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				return nil, NewError(name, 1, fmt.Sprintf("line %d, column %d: %s", pe.Line, pe.Column, pe.Err), []string{"quote fields that contain the delimiter, quotes or newlines", usage})
+			}
+			return nil, NewError(name, 1, err.Error(), nil)
+		}
+		return row, err
+	}
+
+	first, err := read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var pending [][]string
+	header := opts.columns
+	if header == nil && (opts.header == 1 || opts.header == 0 && looksLikeHeader(first)) {
+		header = first
+	} else {
+		pending = append(pending, first)
+	}
+	header = uniqueNames(header)
+
+	emit := func(row []string) bool {
+		rec := values.NewRecord()
+		for i, cell := range row {
+			col := fmt.Sprintf("column%d", i)
+			if i < len(header) {
+				col = header[i]
+			}
+			if opts.trim {
+				cell = strings.TrimSpace(cell)
+			}
+			if opts.infer {
+				rec.Set(col, inferValue(cell))
+			} else {
+				rec.Set(col, values.String(cell))
+			}
+		}
+		return Send(ctx, out, rec)
+	}
+	for _, row := range pending {
+		if !emit(row) {
+			return ctx.Err()
+		}
+	}
+	for {
+		row, err := read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !emit(row) {
+			return ctx.Err()
+		}
+	}
+}
+
+// looksLikeHeader guesses whether the first row names the columns: its
+// cells are distinct, non-empty and neither numbers nor bools
+func looksLikeHeader(first []string) bool {
+	seen := make(map[string]bool)
+	for _, cell := range first {
+		cell = strings.TrimSpace(cell)
+		if cell == "" || seen[cell] {
+			return false
+		}
+		if _, isText := inferValue(cell).(values.String); !isText {
+			return false
+		}
+		seen[cell] = true
+	}
+	return true
+}
+
+// uniqueNames makes column names usable: empty names become columnN and
+// repeated names get a numeric suffix
+func uniqueNames(names []string) []string {
+	if names == nil {
+		return nil
+	}
+	out := make([]string, len(names))
+	seen := make(map[string]int)
+	for i, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			n = fmt.Sprintf("column%d", i)
+		}
+		if c := seen[n]; c > 0 {
+			seen[n]++
+			n = fmt.Sprintf("%s_%d", n, c)
+		} else {
+			seen[n] = 1
+		}
+		out[i] = n
+	}
+	return out
+}
+
+var numberText = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// inferValue types a field of text: numbers, bools and empty cells become
+// numbers, bools and null. Numbers with leading zeros, such as postal
+// codes, stay text so nothing is lost.
+func inferValue(s string) values.Value {
+	switch {
+	case s == "":
+		return values.Nothing{}
+	case numberText.MatchString(s):
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return values.Number(n)
+		}
+	case strings.EqualFold(s, "true"):
+		return values.Bool(true)
+	case strings.EqualFold(s, "false"):
+		return values.Bool(false)
+	}
+	return values.String(s)
+}
+
+func toDelimited(ctx context.Context, name string, args []string, delim rune, usage string, in <-chan values.Value, out chan<- values.Value) error {
+	opts, e := parseCSVArgs(name, args, delim, usage)
+	if e != nil {
+		return e
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = opts.delim
+	line := func(fields []string) bool {
+		buf.Reset()
+		w.Write(fields)
+		w.Flush()
+		return Send(ctx, out, values.String(strings.TrimSuffix(buf.String(), "\n")))
+	}
+
+	header := opts.columns
+	known := make(map[string]bool)
+	row := 0
+	for rec := range in {
+		r, ok := rec.(*values.Record)
+		if !ok {
+			r = values.NewRecord("value", rec)
+		}
+		if header == nil {
+			header = r.Keys()
+		}
+		if row == 0 {
+			for _, c := range header {
+				known[c] = true
+			}
+			if opts.header != -1 && !line(header) {
+				return ctx.Err()
+			}
+		}
+		if opts.columns == nil {
+			for _, k := range r.Keys() {
+				if !known[k] {
+					ee := NewError(name, 1, fmt.Sprintf("row %d has a column '%s' that the first row lacks", row, k), []string{"choose the columns with: " + name + " --columns " + strings.Join(append(header[:len(header):len(header)], k), ",")})
+					ee.Context = values.NewRecord("row", row, "value", r)
+					return ee
+				}
+			}
+		}
+		fields := make([]string, len(header))
+		for i, c := range header {
+			fields[i] = cellText(field(r, c))
+		}
+		if !line(fields) {
+			return ctx.Err()
+		}
+		row++
+	}
+	return nil
+}
+
+// cellText writes a value as a csv field; nested values become JSON
+func cellText(v values.Value) string {
+	if values.IsNothing(v) {
+		return ""
+	}
+	return values.Text(v)
+}
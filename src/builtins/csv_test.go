@@ -0,0 +1,81 @@
+package builtins
+
+import (
+	"strings"
+	"testing"
+
+	"netxp/values"
+)
+
+func TestFromCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		text string
+		want string
+	}{
+		{"from-csv", nil, "name,port\nweb,80\ndb,5432\n", `[{"name":"web","port":80},{"name":"db","port":5432}]`},
+		{"from-csv", nil, "name,note\n\"a, b\",\"say \"\"hi\"\"\"\n", `[{"name":"a, b","note":"say \"hi\""}]`},
+		{"from-csv", []string{"--no-infer"}, "zip,n\n00501,1\n", `[{"zip":"00501","n":"1"}]`},
+		{"from-csv", []string{"-d", ";"}, "a;b\n1;x\n", `[{"a":1,"b":"x"}]`},
+		{"from-csv", []string{"--no-header", "--columns", "x,y"}, "1,2\n3,4\n", `[{"x":1,"y":2},{"x":3,"y":4}]`},
+		{"from-csv", []string{"--comment", "#", "--trim"}, "# hosts\nname , port\n web , 80\n", `[{"name":"web","port":80}]`},
+		{"from-tsv", nil, "a\tb\n1\ttwo words\n", `[{"a":1,"b":"two words"}]`},
+	}
+	for _, tt := range tests {
+		got := jsonOf(execute(t, tt.name, tt.args, values.String(tt.text)))
+		if got != tt.want {
+			t.Errorf("%s %v = %s, want %s", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+// linesOf joins the lines written by to-csv and to-tsv
+func linesOf(v values.Value) string {
+	var lines []string
+	for _, line := range values.Items(v) {
+		lines = append(lines, values.Text(line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestToCSV(t *testing.T) {
+	rows := values.Decode([]byte(`[{"name":"a, b","port":80},{"name":"db","port":5432}]`))
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"to-csv", nil, "name,port\n\"a, b\",80\ndb,5432"},
+		{"to-csv", []string{"--no-header", "--columns", "port"}, "80\n5432"},
+		{"to-csv", []string{"-d", ";", "-c", "name,port"}, "name;port\na, b;80\ndb;5432"},
+		{"to-tsv", []string{"-c", "port,name"}, "port\tname\n80\ta, b\n5432\tdb"},
+	}
+	for _, tt := range tests {
+		got := linesOf(execute(t, tt.name, tt.args, rows))
+		if got != tt.want {
+			t.Errorf("%s %v = %q, want %q", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+// TestToCSVNewColumn checks that a column the first row lacks fails
+// rather than shifting the fields of the lines already written
+func TestToCSVNewColumn(t *testing.T) {
+	rows := values.Decode([]byte(`[{"a":1},{"a":2,"b":3}]`))
+	if _, err := Execute("to-csv", nil, rows, nil); err == nil || !strings.Contains(err.Error(), "column 'b'") {
+		t.Errorf("to-csv: got %v", err)
+	}
+	if _, err := Execute("to-csv", []string{"-c", "a,b"}, rows, nil); err != nil {
+		t.Errorf("to-csv -c a,b: %v", err)
+	}
+}
+
+// TestCSVRoundTrip checks that to-csv output reads back as the same table
+func TestCSVRoundTrip(t *testing.T) {
+	rows := values.Decode([]byte(`[{"name":"x \"y\", z","n":1.5},{"name":"line\nbreak","n":-2}]`))
+	text := values.String(linesOf(execute(t, "to-csv", nil, rows)))
+	if got, want := jsonOf(execute(t, "from-csv", nil, text)), jsonOf(rows); got != want {
+		t.Errorf("round trip = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+// samples is the number of example values describe shows per column
+const samples = 3
+
+// CmdDescribe reports the shape of the input. A table, or a list of
+// records, gives a row per column with its types, share of nulls,
+// distinct values, range and samples; a list of other values is one
+// column called value. Anything else gives its type tree: records with
+// the type of every field, and lists as a list of the type of their
+// items.
+func CmdDescribe(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) > 0 {
+		return StructuredError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{"usage: ... | describe"}), nil
+	}
+	input = values.Unwrap(input)
+	switch x := input.(type) {
+	case *values.Table:
+		return describeColumns(x.Rows, x.Columns), nil
+	case values.List:
+		if rows, ok := recordsOf(x); ok {
+			return describeColumns(rows, values.NewTable(rows).Columns), nil
+		}
+		rows := make([]*values.Record, len(x))
+		for i, item := range x {
+			rows[i] = values.NewRecord("value", item)
+		}
+		return describeColumns(rows, []string{"value"}), nil
+	}
+	return typeTree([]values.Value{input}), nil
+}
+
+// describeColumns profiles the columns of rows; a field a row lacks
+// counts as null
+func describeColumns(rows []*values.Record, columns []string) *values.Table {
+	out := make([]*values.Record, 0, len(columns))
+	for _, col := range columns {
+		var vals []values.Value
+		nulls := 0
+		for _, r := range rows {
+			v, ok := r.Get(col)
+			if !ok || values.IsNothing(v) {
+				nulls++
+				continue
+			}
+			vals = append(vals, v)
+		}
+		seen := make(map[string]bool)
+		var sample []values.Value
+		for _, v := range vals {
+			k := values.Identity(v)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			if len(sample) < samples {
+				sample = append(sample, v)
+			}
+		}
+		lo, hi := valueRange(vals)
+		ratio := 0.0
+		if len(rows) > 0 {
+			ratio = math.Round(float64(nulls)/float64(len(rows))*1000) / 1000
+		}
+		typ := strings.Join(kinds(vals), "|")
+		if typ == "" {
+			typ = "null"
+		}
+		out = append(out, values.NewRecord(
+			"column", col,
+			"type", typ,
+			"null_ratio", ratio,
+			"distinct", len(seen),
+			"min", lo,
+			"max", hi,
+			"samples", values.List(sample),
+		))
+	}
+	return values.NewTable(out)
+}
+
+// kinds returns the kinds of values in order of first appearance
+func kinds(vals []values.Value) []string {
+	var names []string
+	seen := make(map[values.Kind]bool)
+	for _, v := range vals {
+		if !seen[v.Kind()] {
+			seen[v.Kind()] = true
+			names = append(names, v.Kind().String())
+		}
+	}
+	return names
+}
+
+// valueRange returns the smallest and largest of values that all order
+// against each other, and nulls otherwise
+func valueRange(vals []values.Value) (values.Value, values.Value) {
+	var lo, hi values.Value = values.Nothing{}, values.Nothing{}
+	for _, v := range vals {
+		switch v.(type) {
+		case values.Number, values.String, values.Filesize, values.Duration, values.Datetime:
+		default:
+			return values.Nothing{}, values.Nothing{}
+		}
+		if values.IsNothing(lo) {
+			lo, hi = v, v
+			continue
+		}
+		c, err := expr.Compare(v, lo)
+		if err != nil {
+			return values.Nothing{}, values.Nothing{}
+		}
+		if c < 0 {
+			lo = v
+		}
+		if c, _ = expr.Compare(v, hi); c > 0 {
+			hi = v
+		}
+	}
+	return lo, hi
+}
+
+// typeTree describes the values found at one place of a document:
+// records as a record of the types of their fields, lists as a list
+// holding the type of all their items, and other values as the name of
+// their kind. Differing kinds are joined with '|'.
+func typeTree(vals []values.Value) values.Value {
+	var present []values.Value
+	nulls := false
+	for _, v := range vals {
+		if values.IsNothing(v) {
+			nulls = true
+			continue
+		}
+		present = append(present, v)
+	}
+	if len(present) == 0 {
+		return values.String("null")
+	}
+	allRecords, allLists := true, true
+	for _, v := range present {
+		switch v.(type) {
+		case *values.Record:
+			allLists = false
+		case values.List, *values.Table:
+			allRecords = false
+		default:
+			allRecords, allLists = false, false
+		}
+	}
+	switch {
+	case allRecords:
+		var keys []string
+		seen := make(map[string]bool)
+		for _, v := range present {
+			for _, k := range v.(*values.Record).Keys() {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+		}
+		tree := values.NewRecord()
+		for _, k := range keys {
+			fields := make([]values.Value, len(present))
+			for i, v := range present {
+				if f, ok := v.(*values.Record).Get(k); ok {
+					fields[i] = f
+				} else {
+					fields[i] = values.Nothing{}
+				}
+			}
+			tree.Set(k, typeTree(fields))
+		}
+		return tree
+	case allLists:
+		var items []values.Value
+		for _, v := range present {
+			items = append(items, values.Items(v)...)
+		}
+		if len(items) == 0 {
+			return values.List{}
+		}
+		return values.List{typeTree(items)}
+	}
+	names := kinds(present)
+	if nulls {
+		names = append(names, "null")
+	}
+	return values.String(strings.Join(names, "|"))
+}
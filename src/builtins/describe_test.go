@@ -0,0 +1,40 @@
+package builtins
+
+import (
+	"testing"
+
+	"netxp/values"
+)
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`[{"name":"a","port":80,"tags":["x"]},{"name":"b","port":443},{"name":"c","port":22}]`, `[` +
+			`{"column":"name","type":"string","null_ratio":0,"distinct":3,"min":"a","max":"c","samples":["a","b","c"]},` +
+			`{"column":"port","type":"number","null_ratio":0,"distinct":3,"min":22,"max":443,"samples":[80,443,22]},` +
+			`{"column":"tags","type":"list","null_ratio":0.667,"distinct":1,"min":null,"max":null,"samples":[["x"]]}]`},
+		{`[1,2,2,null,"x"]`, `[{"column":"value","type":"number|string","null_ratio":0.2,"distinct":3,"min":null,"max":null,"samples":[1,2,"x"]}]`},
+		{`{"a":1,"b":{"c":"x","d":[true]}}`, `{"a":"number","b":{"c":"string","d":["bool"]}}`},
+		{`"text"`, `"string"`},
+	}
+	for _, tt := range tests {
+		if got := jsonOf(execute(t, "describe", nil, values.Decode([]byte(tt.input)))); got != tt.want {
+			t.Errorf("describe %s\n got %s\nwant %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestDescribeUnits checks that a filesize column has a range
+func TestDescribeUnits(t *testing.T) {
+	rows := values.NewTable([]*values.Record{
+		values.NewRecord("size", values.Filesize(2000)),
+		values.NewRecord("size", values.Filesize(10)),
+	})
+	got := jsonOf(execute(t, "describe", nil, rows))
+	want := `[{"column":"size","type":"filesize","null_ratio":0,"distinct":2,"min":10,"max":2000,"samples":[2000,10]}]`
+	if got != want {
+		t.Errorf("describe\n got %s\nwant %s", got, want)
+	}
+}
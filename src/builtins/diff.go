@@ -0,0 +1,275 @@
+package builtins
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+const diffUsage = "usage: diff-data <old> <new> [-k col[,col]] [--ignore a,b], or <pipeline> | diff-data <old> [-k col]"
+
+// plainKey is a field name a path can hold without brackets
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// CmdDiffData compares two values, read from files or the second piped
+// in, and returns a row per difference. Tables are matched row by row on
+// the key columns, or by position without them, and records are compared
+// field by field down to the changed leaves.
+func CmdDiffData(name string, args []string, input values.Value) (values.Value, error) {
+	var files, keys, ignore []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-k", "--key", "--ignore":
+			if i+1 >= len(args) || args[i+1] == "" {
+				return StructuredError(name, 2, fmt.Sprintf("%s needs column names", arg), []string{diffUsage}), nil
+			}
+			i++
+			if arg == "--ignore" {
+				ignore = append(ignore, strings.Split(args[i], ",")...)
+			} else {
+				keys = append(keys, strings.Split(args[i], ",")...)
+			}
+		default:
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return StructuredError(name, 2, fmt.Sprintf("unknown option '%s'", arg), []string{diffUsage}), nil
+			}
+			files = append(files, arg)
+		}
+	}
+	piped := !values.IsNothing(input)
+	switch {
+	case len(files) == 2 && piped:
+		return StructuredError(name, 2, "two files and piped input to compare", []string{"name one file to compare it with the input, e.g. ls | diff-data before.json -k name"}), nil
+	case len(files) == 1 && !piped:
+		return StructuredError(name, 2, "nothing to compare with "+files[0], []string{diffUsage}), nil
+	case len(files) == 0 || len(files) > 2:
+		return StructuredError(name, 2, "diff-data compares two values", []string{diffUsage}), nil
+	}
+	hints := []string{"diff-data reads JSON, NDJSON, CSV and TSV files"}
+	old, e := loadFile(name, files[0], hints)
+	if e != nil {
+		return e, nil
+	}
+	cur := input
+	if len(files) == 2 {
+		if cur, e = loadFile(name, files[1], hints); e != nil {
+			return e, nil
+		}
+	}
+
+	d := &differ{ignore: ignore}
+	oldRows, oldOK := recordsOf(old)
+	newRows, newOK := recordsOf(cur)
+	if !oldOK || !newOK {
+		if len(keys) > 0 {
+			return StructuredError(name, 2, fmt.Sprintf("-k needs two tables, not %s and %s", old.Kind(), cur.Kind()), []string{"without -k other values are compared field by field"}), nil
+		}
+		d.value("", old, cur)
+		return values.NewTable(d.rows), nil
+	}
+	d.keyed = true
+	if len(keys) == 0 {
+		n := len(oldRows)
+		if len(newRows) > n {
+			n = len(newRows)
+		}
+		for i := 0; i < n; i++ {
+			var o, nw values.Value = values.Nothing{}, values.Nothing{}
+			if i < len(oldRows) {
+				o = oldRows[i]
+			}
+			if i < len(newRows) {
+				nw = newRows[i]
+			}
+			d.key = values.Number(i)
+			d.value("", o, nw)
+		}
+		return values.NewTable(d.rows), nil
+	}
+
+	// rows with the same key are paired in order
+	index := make(map[string][]int)
+	for i, r := range newRows {
+		k, err := rowKey(r, keys)
+		if err != nil {
+			return StructuredError(name, 1, fmt.Sprintf("row %d of the new table %s", i, err), []string{"available: " + strings.Join(values.NewTable(newRows).Columns, ", ")}), nil
+		}
+		id := values.Identity(k)
+		index[id] = append(index[id], i)
+	}
+	matched := make([]bool, len(newRows))
+	for i, r := range oldRows {
+		k, err := rowKey(r, keys)
+		if err != nil {
+			return StructuredError(name, 1, fmt.Sprintf("row %d of the old table %s", i, err), []string{"available: " + strings.Join(values.NewTable(oldRows).Columns, ", ")}), nil
+		}
+		d.key = k
+		id := values.Identity(k)
+		if len(index[id]) == 0 {
+			d.add("removed", "", r, values.Nothing{})
+			continue
+		}
+		j := index[id][0]
+		index[id] = index[id][1:]
+		matched[j] = true
+		d.value("", r, newRows[j])
+	}
+	for j, r := range newRows {
+		if !matched[j] {
+			d.key, _ = rowKey(r, keys)
+			d.add("added", "", values.Nothing{}, r)
+		}
+	}
+	return values.NewTable(d.rows), nil
+}
+
+// recordsOf returns the rows of a table or of a list of records
+func recordsOf(v values.Value) ([]*values.Record, bool) {
+	switch x := v.(type) {
+	case *values.Table:
+		return x.Rows, true
+	case values.List:
+		rows := make([]*values.Record, 0, len(x))
+		for _, item := range x {
+			r, ok := item.(*values.Record)
+			if !ok {
+				return nil, false
+			}
+			rows = append(rows, r)
+		}
+		return rows, true
+	}
+	return nil, false
+}
+
+// rowKey returns the value of the key column of a row, or a record of
+// the key columns when there are several
+func rowKey(r *values.Record, keys []string) (values.Value, error) {
+	k := values.NewRecord()
+	for _, col := range keys {
+		v, ok := r.Get(col)
+		if !ok {
+			return nil, fmt.Errorf("has no column '%s'", col)
+		}
+		if len(keys) == 1 {
+			return v, nil
+		}
+		k.Set(col, v)
+	}
+	return k, nil
+}
+
+// differ collects the differences as rows
+type differ struct {
+	keyed  bool         // the rows have a key column
+	key    values.Value // the key of the rows being compared
+	ignore []string     // paths not compared
+	rows   []*values.Record
+}
+
+func (d *differ) add(change, path string, from, to values.Value) {
+	r := values.NewRecord()
+	if d.keyed {
+		r.Set("key", d.key)
+	}
+	var p values.Value = values.Nothing{}
+	if path != "" {
+		p = values.String(path)
+	}
+	r.Set("change", values.String(change))
+	r.Set("path", p)
+	r.Set("old", from)
+	r.Set("new", to)
+	d.rows = append(d.rows, r)
+}
+
+// ignored reports whether a path is, or is inside, an ignored path
+func (d *differ) ignored(path string) bool {
+	for _, ig := range d.ignore {
+		if path == ig || strings.HasPrefix(path, ig+".") || strings.HasPrefix(path, ig+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// value compares two values at a path. A field or element only one side
+// has is added or removed; leaves that differ are changed.
+func (d *differ) value(path string, from, to values.Value) {
+	if path != "" && d.ignored(path) {
+		return
+	}
+	switch {
+	case values.IsNothing(from) && values.IsNothing(to):
+		return
+	case values.IsNothing(from) && path == "":
+		d.add("added", path, values.Nothing{}, to)
+		return
+	case values.IsNothing(to) && path == "":
+		d.add("removed", path, from, values.Nothing{})
+		return
+	}
+	o, isRecord := from.(*values.Record)
+	n, bothRecords := to.(*values.Record)
+	if isRecord && bothRecords {
+		for _, k := range o.Keys() {
+			ov, _ := o.Get(k)
+			nv, ok := n.Get(k)
+			if !ok {
+				if !d.ignored(join(path, k)) {
+					d.add("removed", join(path, k), ov, values.Nothing{})
+				}
+				continue
+			}
+			d.value(join(path, k), ov, nv)
+		}
+		for _, k := range n.Keys() {
+			if _, ok := o.Get(k); !ok && !d.ignored(join(path, k)) {
+				nv, _ := n.Get(k)
+				d.add("added", join(path, k), values.Nothing{}, nv)
+			}
+		}
+		return
+	}
+	if isList(from) && isList(to) {
+		oi, ni := values.Items(from), values.Items(to)
+		for i := 0; i < len(oi) || i < len(ni); i++ {
+			at := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(ni):
+				d.add("removed", at, oi[i], values.Nothing{})
+			case i >= len(oi):
+				d.add("added", at, values.Nothing{}, ni[i])
+			default:
+				d.value(at, oi[i], ni[i])
+			}
+		}
+		return
+	}
+	if !expr.Equal(from, to) {
+		d.add("changed", path, from, to)
+	}
+}
+
+func isList(v values.Value) bool {
+	switch v.(type) {
+	case values.List, *values.Table:
+		return true
+	}
+	return false
+}
+
+// join appends a field to a path in the syntax get reads
+func join(path, key string) string {
+	if !plainKey.MatchString(key) {
+		return path + `["` + key + `"]`
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,73 @@
+package builtins
+
+import (
+	"strings"
+	"testing"
+
+	"netxp/values"
+)
+
+func TestDiffData(t *testing.T) {
+	inDir(t, map[string]string{
+		"old.json": `[{"name":"a","port":80,"tags":["x"]},{"name":"b","port":443,"cfg":{"tls":true,"ver":1}},{"name":"c","port":22}]`,
+		"new.json": `[{"name":"b","port":8443,"cfg":{"tls":true,"ver":2}},{"name":"a","port":80,"tags":["x","y"]},{"name":"d","port":53}]`,
+		"old.csv":  "name,port\na,80\nb,443\n",
+		"r1.json":  `{"a":1,"b":{"c":[1,2]}}`,
+		"r2.json":  `{"a":2,"b":{"c":[1]},"d":true}`,
+	})
+	tests := []struct {
+		args  []string
+		input values.Value
+		want  string
+	}{
+		{[]string{"old.json", "new.json", "-k", "name"}, nil, `[` +
+			`{"key":"a","change":"added","path":"tags[1]","old":null,"new":"y"},` +
+			`{"key":"b","change":"changed","path":"port","old":443,"new":8443},` +
+			`{"key":"b","change":"changed","path":"cfg.ver","old":1,"new":2},` +
+			`{"key":"c","change":"removed","path":null,"old":{"name":"c","port":22},"new":null},` +
+			`{"key":"d","change":"added","path":null,"old":null,"new":{"name":"d","port":53}}]`},
+		{[]string{"old.json", "new.json", "-k", "name", "--ignore", "port,tags"}, nil, `[` +
+			`{"key":"b","change":"changed","path":"cfg.ver","old":1,"new":2},` +
+			`{"key":"c","change":"removed","path":null,"old":{"name":"c","port":22},"new":null},` +
+			`{"key":"d","change":"added","path":null,"old":null,"new":{"name":"d","port":53}}]`},
+		{[]string{"old.csv", "-k", "name"}, values.Decode([]byte(`[{"name":"a","port":80},{"name":"b","port":443}]`)), `[]`},
+		{[]string{"old.csv"}, values.Decode([]byte(`[{"name":"a","port":81}]`)), `[` +
+			`{"key":0,"change":"changed","path":"port","old":80,"new":81},` +
+			`{"key":1,"change":"removed","path":null,"old":{"name":"b","port":443},"new":null}]`},
+		{[]string{"r1.json", "r2.json"}, nil, `[` +
+			`{"change":"changed","path":"a","old":1,"new":2},` +
+			`{"change":"removed","path":"b.c[1]","old":2,"new":null},` +
+			`{"change":"added","path":"d","old":null,"new":true}]`},
+		{[]string{"old.json", "old.json"}, nil, `[]`},
+	}
+	for _, tt := range tests {
+		if got := jsonOf(execute(t, "diff-data", tt.args, tt.input)); got != tt.want {
+			t.Errorf("diff-data %v\n got %s\nwant %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestDiffDataErrors(t *testing.T) {
+	inDir(t, map[string]string{"a.json": `[{"name":"a"}]`, "r.json": `{"a":1}`})
+	tests := []struct {
+		args  []string
+		input values.Value
+		want  string
+	}{
+		{[]string{"a.json"}, nil, "nothing to compare with a.json"},
+		{[]string{"a.json", "a.json"}, values.String("x"), "two files and piped input to compare"},
+		{[]string{"a.json", "a.json", "-k", "nosuch"}, nil, "row 0 of the new table has no column 'nosuch'"},
+		{[]string{"a.json", "r.json", "-k", "name"}, nil, "-k needs two tables, not table and record"},
+		{[]string{"a.json", "missing.json"}, nil, "missing.json"},
+	}
+	for _, tt := range tests {
+		e, ok := execute(t, "diff-data", tt.args, tt.input).(*ExecutionError)
+		if !ok {
+			t.Errorf("diff-data %v: no error", tt.args)
+			continue
+		}
+		if !strings.Contains(e.Message, tt.want) {
+			t.Errorf("diff-data %v: %s, want %s", tt.args, e.Message, tt.want)
+		}
+	}
+}
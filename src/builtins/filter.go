@@ -0,0 +1,42 @@
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+const whereUsage = `usage: where <condition>, e.g. where size > 1mb && name =~ "\.log$"`
+
+// CmdWhere passes on the records for which a condition holds. A row that
+// the condition cannot be evaluated on stops the stage with an error that
+// carries the row.
+func CmdWhere(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	if len(args) == 0 {
+		return NewError(name, 2, "missing condition", []string{whereUsage})
+	}
+	cond, err := expr.Parse(strings.Join(args, " "))
+	if err != nil {
+		return NewError(name, 2, err.Error(), []string{whereUsage})
+	}
+	row := 0
+	for rec := range in {
+		ok, err := cond.Test(rec)
+		if err != nil {
+			e := NewError(name, 1, fmt.Sprintf("row %d: %s", row, err), []string{"convert fields with number() or string()"})
+			e.Context = values.NewRecord("row", row, "value", rec)
+			return e
+		}
+		row++
+		if !ok {
+			continue
+		}
+		if !Send(ctx, out, rec) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
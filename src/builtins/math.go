@@ -0,0 +1,179 @@
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"netxp/values"
+)
+
+const mathUsage = "usage: math <sum|avg|min|max|median|stddev> [column]"
+
+// aggregates reduce a non-empty list of numbers to one
+var aggregates = map[string]func([]float64) float64{
+	"sum": func(xs []float64) float64 {
+		total := 0.0
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	},
+	"avg": mean,
+	"min": func(xs []float64) float64 {
+		m := xs[0]
+		for _, x := range xs[1:] {
+			m = math.Min(m, x)
+		}
+		return m
+	},
+	"max": func(xs []float64) float64 {
+		m := xs[0]
+		for _, x := range xs[1:] {
+			m = math.Max(m, x)
+		}
+		return m
+	},
+	"median": func(xs []float64) float64 {
+		s := append([]float64{}, xs...)
+		sort.Float64s(s)
+		if len(s)%2 == 1 {
+			return s[len(s)/2]
+		}
+		return (s[len(s)/2-1] + s[len(s)/2]) / 2
+	},
+	// population standard deviation
+	"stddev": func(xs []float64) float64 {
+		m := mean(xs)
+		sq := 0.0
+		for _, x := range xs {
+			sq += (x - m) * (x - m)
+		}
+		return math.Sqrt(sq / float64(len(xs)))
+	},
+}
+
+func mean(xs []float64) float64 {
+	total := 0.0
+	for _, x := range xs {
+		total += x
+	}
+	return total / float64(len(xs))
+}
+
+// CmdMath aggregates a list of numbers or a column of a table. A table
+// without a column gives a record with the result for every numeric
+// column. Null values are skipped.
+func CmdMath(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return StructuredError(name, 2, "missing operation", []string{mathUsage}), nil
+	}
+	op, ok := aggregates[args[0]]
+	if !ok {
+		return StructuredError(name, 2, fmt.Sprintf("unknown operation '%s'", args[0]), []string{mathUsage}), nil
+	}
+	items, columns := rows(input)
+	if len(args) == 2 {
+		if e := checkColumns(name, input, args[1:]); e != nil {
+			return e, nil
+		}
+		xs, unit, e := numbers(name, items, args[1])
+		if e != nil {
+			return e, nil
+		}
+		return aggregate(args[0], op, xs, unit), nil
+	}
+	if columns == nil {
+		if _, isRecord := input.(*values.Record); !isRecord {
+			xs, unit, e := numbers(name, items, "")
+			if e != nil {
+				return e, nil
+			}
+			return aggregate(args[0], op, xs, unit), nil
+		}
+		columns = input.(*values.Record).Keys()
+	}
+	out := values.NewRecord()
+	for _, col := range columns {
+		if xs, unit, e := numbers(name, items, col); e == nil {
+			out.Set(col, aggregate(args[0], op, xs, unit))
+		}
+	}
+	if out.Len() == 0 {
+		return StructuredError(name, 1, "no numeric columns", []string{mathUsage}), nil
+	}
+	return out, nil
+}
+
+// aggregate applies op; a result over filesizes or durations is one too
+func aggregate(name string, op func([]float64) float64, xs []float64, unit values.Kind) values.Value {
+	if len(xs) == 0 {
+		if name == "sum" {
+			return values.Number(0)
+		}
+		return values.Nothing{}
+	}
+	switch x := op(xs); unit {
+	case values.KindFilesize:
+		return values.Filesize(math.Round(x))
+	case values.KindDuration:
+		return values.Duration(x)
+	default:
+		return values.Number(x)
+	}
+}
+
+// numbers collects the numbers of a column, or the items themselves when
+// col is empty. Text that holds a number counts as one; anything else
+// other than null is an error that names the row. Filesizes count in
+// bytes and durations in nanoseconds, and when every value is one of
+// them their kind is returned as the unit of the result.
+func numbers(name string, items []values.Value, col string) ([]float64, values.Kind, *ExecutionError) {
+	var xs []float64
+	unit := values.KindNothing
+	for i, item := range items {
+		v := item
+		if col != "" {
+			v = field(item, col)
+		}
+		if values.IsNothing(v) {
+			continue
+		}
+		x, ok := numeric(v)
+		if !ok {
+			what := "item"
+			if col != "" {
+				what = "'" + col + "'"
+			}
+			e := NewError(name, 1, fmt.Sprintf("row %d: %s is a %s, not a number", i, what, v.Kind()), []string{mathUsage})
+			e.Context = values.NewRecord("row", i, "value", item)
+			return nil, 0, e
+		}
+		switch k := v.Kind(); {
+		case unit == values.KindNothing && (k == values.KindFilesize || k == values.KindDuration):
+			unit = k
+		case unit != k:
+			unit = values.KindNumber
+		}
+		xs = append(xs, x)
+	}
+	return xs, unit, nil
+}
+
+// numeric reads a number, or text that holds one such as a line of a file
+func numeric(v values.Value) (float64, bool) {
+	switch x := v.(type) {
+	case values.Number:
+		return float64(x), true
+	case values.Filesize:
+		return float64(x), true
+	case values.Duration:
+		return float64(x), true
+	case values.String:
+		n, err := strconv.ParseFloat(strings.TrimSpace(string(x)), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,77 @@
+package builtins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"netxp/values"
+)
+
+const (
+	fromNDJSONUsage = "usage: from-ndjson [--skip-invalid]"
+	toNDJSONUsage   = "usage: to-ndjson"
+)
+
+// CmdFromNDJSON parses one JSON document per line and passes each on as
+// a row as soon as its line arrives. Records that were decoded already,
+// such as the output of a module, pass through.
+func CmdFromNDJSON(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	skip := false
+	for _, arg := range args {
+		switch arg {
+		case "-s", "--skip-invalid":
+			skip = true
+		default:
+			return NewError(name, 2, fmt.Sprintf("unknown option '%s'", arg), []string{fromNDJSONUsage})
+		}
+	}
+	line := 0
+	for rec := range in {
+		line++
+		text, isText := rec.(values.String)
+		if !isText {
+			if !Send(ctx, out, rec) {
+				return ctx.Err()
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(string(text))
+		if trimmed == "" {
+			continue
+		}
+		v, err := values.ParseJSON([]byte(trimmed))
+		if err != nil {
+			if skip {
+				continue
+			}
+			e := NewError(name, 1, fmt.Sprintf("line %d: %s", line, err), []string{"each line must hold one JSON document", "skip lines that are not JSON with: from-ndjson --skip-invalid"})
+			e.Context = values.NewRecord("line", line, "text", trimmed)
+			return e
+		}
+		for _, item := range values.Items(values.Unwrap(v)) {
+			if !Send(ctx, out, item) {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// CmdToNDJSON writes each row as one line of compact JSON
+func CmdToNDJSON(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	if len(args) > 0 {
+		return NewError(name, 2, fmt.Sprintf("unexpected argument '%s'", args[0]), []string{toNDJSONUsage})
+	}
+	for rec := range in {
+		b, err := json.Marshal(jsonable(rec))
+		if err != nil {
+			return NewError(name, 1, err.Error(), nil)
+		}
+		if !Send(ctx, out, values.String(b)) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
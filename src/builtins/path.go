@@ -0,0 +1,353 @@
+package builtins
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"netxp/values"
+)
+
+const getUsage = "usage: get <path> [path ...], e.g. get data.0.name, get items[*].addr.ip, get a.b?.c, get rows[1:3]"
+
+type stepKind int
+
+const (
+	stepKey   stepKind = iota // .name or ["name"]
+	stepIndex                 // .0 or [0]; negative counts from the end
+	stepAll                   // .* or [*]
+	stepSlice                 // [from:to]
+)
+
+// step is one segment of a path
+type step struct {
+	kind     stepKind
+	key      string
+	index    int
+	from, to *int
+	optional bool   // a missing field or element is null instead of an error
+	text     string // the segment as written
+}
+
+// parsePath splits a path such as items[*].addr.ip? into steps
+func parsePath(path string) ([]step, error) {
+	var steps []step
+	s := strings.TrimPrefix(path, ".")
+	for s != "" {
+		var st step
+		if s[0] == '[' {
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ']' in '%s'", path)
+			}
+			inner := strings.TrimSpace(s[1:end])
+			st.text = s[:end+1]
+			s = s[end+1:]
+			switch {
+			case inner == "*":
+				st.kind = stepAll
+			case len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0]:
+				st.kind, st.key = stepKey, inner[1:len(inner)-1]
+			case strings.Contains(inner, ":"):
+				st.kind = stepSlice
+				bounds := strings.SplitN(inner, ":", 2)
+				for i, b := range bounds {
+					if b = strings.TrimSpace(b); b == "" {
+						continue
+					}
+					n, err := strconv.Atoi(b)
+					if err != nil {
+						return nil, fmt.Errorf("invalid slice '%s' in '%s'", st.text, path)
+					}
+					if i == 0 {
+						st.from = &n
+					} else {
+						st.to = &n
+					}
+				}
+			default:
+				if n, err := strconv.Atoi(inner); err == nil {
+					st.kind, st.index, st.key = stepIndex, n, inner
+				} else {
+					st.kind, st.key = stepKey, inner
+				}
+			}
+		} else {
+			end := strings.IndexAny(s, ".[?")
+			if end < 0 {
+				end = len(s)
+			}
+			seg := s[:end]
+			st.text = seg
+			s = s[end:]
+			switch n, err := strconv.Atoi(seg); {
+			case seg == "":
+				return nil, fmt.Errorf("empty segment in '%s'", path)
+			case seg == "*":
+				st.kind = stepAll
+			case err == nil:
+				st.kind, st.index, st.key = stepIndex, n, seg
+			default:
+				st.kind, st.key = stepKey, seg
+			}
+		}
+		if strings.HasPrefix(s, "?") {
+			st.optional = true
+			st.text += "?"
+			s = s[1:]
+		}
+		switch {
+		case s == "" || s[0] == '[':
+		case s[0] == '.':
+			s = s[1:]
+			if s == "" {
+				return nil, fmt.Errorf("path '%s' ends with '.'", path)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected '%c' in '%s'", s[0], path)
+		}
+		steps = append(steps, st)
+	}
+	return steps, nil
+}
+
+// pathError is a path that does not lead anywhere
+type pathError struct {
+	at      string // the path up to the failing step
+	msg     string
+	closest string
+	keys    []string
+}
+
+// follow applies the steps to a value. A wildcard or slice makes the
+// result a list, and later steps apply to each of its elements. After an
+// optional step a null value stays null to the end of the path.
+func follow(v values.Value, steps []step) (values.Value, *pathError) {
+	current := []values.Value{v}
+	multi, optional := false, false
+	at := ""
+	for _, st := range steps {
+		var next []values.Value
+		for _, cur := range current {
+			if optional && values.IsNothing(cur) {
+				next = append(next, cur)
+				continue
+			}
+			out, fan, err := apply(cur, st, at)
+			if err != nil {
+				return nil, err
+			}
+			if fan {
+				multi = true
+			}
+			next = append(next, out...)
+		}
+		current = next
+		optional = optional || st.optional
+		if at == "" || strings.HasPrefix(st.text, "[") {
+			at += st.text
+		} else {
+			at += "." + st.text
+		}
+	}
+	if !multi {
+		return current[0], nil
+	}
+	return values.NewList(current), nil
+}
+
+// apply takes one step from a value. It reports whether the step fanned
+// out into several values.
+func apply(v values.Value, st step, at string) ([]values.Value, bool, *pathError) {
+	where := at
+	if where == "" {
+		where = "the input"
+	}
+	missing := func(msg string, keys []string) ([]values.Value, bool, *pathError) {
+		if st.optional {
+			return []values.Value{values.Nothing{}}, false, nil
+		}
+		return nil, false, &pathError{at: where, msg: msg, closest: closest(st.key, keys), keys: keys}
+	}
+	if values.IsNothing(v) {
+		if st.optional {
+			return []values.Value{v}, false, nil
+		}
+		return nil, false, &pathError{at: where, msg: fmt.Sprintf("%s is null, cannot take '%s' (use '%s?' to allow it)", where, st.text, st.text)}
+	}
+
+	switch x := v.(type) {
+	case *values.Record:
+		switch st.kind {
+		case stepAll:
+			out := make([]values.Value, 0, x.Len())
+			for _, k := range x.Keys() {
+				field, _ := x.Get(k)
+				out = append(out, field)
+			}
+			return out, true, nil
+		case stepKey, stepIndex:
+			if field, ok := x.Get(st.key); ok {
+				return []values.Value{field}, false, nil
+			}
+			return missing(fmt.Sprintf("no field '%s' in %s", st.key, where), x.Keys())
+		}
+	case values.List, *values.Table:
+		items := values.Items(x)
+		switch st.kind {
+		case stepAll:
+			return items, true, nil
+		case stepIndex:
+			i := st.index
+			if i < 0 {
+				i += len(items)
+			}
+			if i < 0 || i >= len(items) {
+				return missing(fmt.Sprintf("index %d is out of range in %s, which has %d items", st.index, where, len(items)), nil)
+			}
+			return []values.Value{items[i]}, false, nil
+		case stepSlice:
+			from, to := 0, len(items)
+			if st.from != nil {
+				from = clamp(*st.from, len(items))
+			}
+			if st.to != nil {
+				to = clamp(*st.to, len(items))
+			}
+			if to < from {
+				to = from
+			}
+			return items[from:to], true, nil
+		case stepKey:
+			// a name on a table or list of records selects that column,
+			// which is empty when there are no rows
+			if len(items) == 0 {
+				return nil, true, nil
+			}
+			t, ok := x.(*values.Table)
+			if !ok {
+				return missing(fmt.Sprintf("%s is a list, cannot take '%s'", where, st.key), nil)
+			}
+			if !hasColumn(t, st.key) {
+				return missing(fmt.Sprintf("no column '%s' in %s", st.key, where), t.Columns)
+			}
+			out := make([]values.Value, len(t.Rows))
+			for i, r := range t.Rows {
+				out[i] = field(r, st.key)
+			}
+			return out, true, nil
+		}
+	}
+	return missing(fmt.Sprintf("%s is a %s, cannot take '%s'", where, v.Kind(), st.text), nil)
+}
+
+// clamp turns a slice bound, which counts from the end when negative,
+// into an offset within n items
+func clamp(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+func hasColumn(t *values.Table, col string) bool {
+	for _, c := range t.Columns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the key nearest to name by edit distance, if any is
+// near enough to be a likely typo
+func closest(name string, keys []string) string {
+	best, bestDist := "", -1
+	for _, k := range keys {
+		d := editDistance(strings.ToLower(name), strings.ToLower(k))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	if bestDist < 0 || bestDist > len(name)/2+1 {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// CmdGet follows a path into structured input. Several paths give a
+// record with one field per path.
+func CmdGet(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) == 0 {
+		return StructuredError(name, 2, "missing path", []string{getUsage}), nil
+	}
+	if values.IsNothing(input) {
+		return StructuredError(name, 1, "no input", []string{"pipe structured data into get, e.g. cat config.json | get data.0"}), nil
+	}
+	out := values.NewRecord()
+	for _, path := range args {
+		steps, err := parsePath(path)
+		if err != nil {
+			return StructuredError(name, 2, err.Error(), []string{getUsage}), nil
+		}
+		v, perr := follow(input, steps)
+		if perr != nil {
+			var hints []string
+			if perr.closest != "" {
+				hints = append(hints, fmt.Sprintf("did you mean '%s'?", perr.closest))
+			}
+			if len(perr.keys) > 0 {
+				keys := append([]string{}, perr.keys...)
+				sort.Strings(keys)
+				if len(keys) > 12 {
+					keys = append(keys[:12], "…")
+				}
+				hints = append(hints, "available: "+strings.Join(keys, ", "))
+			}
+			e := NewError(name, 1, perr.msg, hints)
+			e.Context = values.NewRecord("path", path, "at", perr.at, "closest", perr.closest)
+			return e, nil
+		}
+		if len(args) == 1 {
+			return v, nil
+		}
+		out.Set(path, v)
+	}
+	return out, nil
+}
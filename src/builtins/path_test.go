@@ -0,0 +1,59 @@
+package builtins
+
+import (
+	"strings"
+	"testing"
+
+	"netxp/values"
+)
+
+func TestGet(t *testing.T) {
+	doc := values.Decode([]byte(`{"data":[{"name":"a","addr":{"ip":"10.0.0.1"}},{"name":"b","addr":null},{"name":"c","addr":{"ip":"10.0.0.3"}}],"count":3}`))
+	tests := []struct {
+		args []string
+		want string // JSON, or part of the error message after "error: "
+	}{
+		{[]string{"count"}, `3`},
+		{[]string{"data.0.name"}, `"a"`},
+		{[]string{"data[1].name"}, `"b"`},
+		{[]string{"data.-1.name"}, `"c"`},
+		{[]string{"data.name"}, `["a","b","c"]`},
+		{[]string{"data[*].name"}, `["a","b","c"]`},
+		{[]string{"data[1:].name"}, `["b","c"]`},
+		{[]string{"data[:1].name"}, `["a"]`},
+		{[]string{"data[*].addr?.ip"}, `["10.0.0.1",null,"10.0.0.3"]`},
+		{[]string{"count", "data.0.name"}, `{"count":3,"data.0.name":"a"}`},
+		{[]string{"data.5"}, `error: index 5 is out of range`},
+		{[]string{"data[*].addr.ip"}, `error: is null`},
+		{[]string{"cuont"}, `error: no field 'cuont'`},
+		{[]string{"count.x"}, `error: is a number`},
+	}
+	for _, tt := range tests {
+		v := execute(t, "get", tt.args, doc)
+		got := jsonOf(v)
+		if e, ok := v.(*ExecutionError); ok {
+			got = "error: " + e.Message
+		}
+		if strings.HasPrefix(tt.want, "error: ") && strings.HasPrefix(got, "error: ") {
+			if !strings.Contains(got, strings.TrimPrefix(tt.want, "error: ")) {
+				t.Errorf("get %v: %s, want %s", tt.args, got, tt.want)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("get %v = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+// TestGetSuggests checks that a missing key names the closest one
+func TestGetSuggests(t *testing.T) {
+	doc := values.Decode([]byte(`{"hostname":"a","port":80}`))
+	e, ok := execute(t, "get", []string{"hostnme"}, doc).(*ExecutionError)
+	if !ok {
+		t.Fatal("get hostnme: no error")
+	}
+	if got := jsonOf(e); !strings.Contains(got, `"closest":"hostname"`) {
+		t.Errorf("get hostnme: context %s", got)
+	}
+}
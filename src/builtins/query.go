@@ -0,0 +1,108 @@
+package builtins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"netxp/sql"
+	"netxp/values"
+)
+
+const queryUsage = `usage: query "SELECT col, count(*) FROM input [JOIN 'file.csv' ON ...] WHERE ... GROUP BY ... ORDER BY ... LIMIT n"`
+
+// CmdQuery runs a SQL query over the piped table, which is called input,
+// and any JSON or CSV files the query names
+func CmdQuery(name string, args []string, input values.Value) (values.Value, error) {
+	src := strings.TrimSpace(strings.Join(args, " "))
+	if src == "" {
+		return StructuredError(name, 2, "missing query", []string{queryUsage}), nil
+	}
+	open := func(table string) (values.Value, error) {
+		if strings.EqualFold(table, "input") {
+			if values.IsNothing(input) {
+				return nil, NewError(name, 1, "the query reads input but nothing is piped in", []string{"pipe a table into query, e.g. ls | query \"SELECT name FROM input\""})
+			}
+			return input, nil
+		}
+		v, e := loadFile(name, table, []string{"the piped table is called input; other tables are JSON or CSV files named by path, e.g. FROM 'hosts.csv'"})
+		if e != nil {
+			return nil, e
+		}
+		return v, nil
+	}
+	t, err := sql.Run(src, open)
+	if err != nil {
+		var ee *ExecutionError
+		var se *sql.SyntaxError
+		var ce *sql.ColumnError
+		switch {
+		case errors.As(err, &ee):
+			return ee, nil
+		case errors.As(err, &se):
+			return StructuredError(name, 2, err.Error(), []string{queryUsage, "quote the whole query so the shell keeps * and > as they are"}), nil
+		case errors.As(err, &ce):
+			return StructuredError(name, 1, err.Error(), []string{"available: " + strings.Join(ce.Columns, ", "), "quote names with spaces, or that are SQL keywords, in double quotes inside the query"}), nil
+		}
+		return StructuredError(name, 1, err.Error(), nil), nil
+	}
+	return t, nil
+}
+
+// loadFile reads a data file into a value by its extension: CSV and TSV
+// into a table with typed cells and JSON or NDJSON into its structure.
+// hints go with the error of a file that cannot be read.
+func loadFile(name, path string, hints []string) (values.Value, *ExecutionError) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return nil, NewError(name, 1, fmt.Sprintf("cannot read '%s': %s", path, err), hints)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readDelimited(name, data, ',')
+	case ".tsv":
+		return readDelimited(name, data, '\t')
+	}
+	v := values.Decode(data)
+	switch v.(type) {
+	case values.String, values.Binary:
+		return nil, NewError(name, 1, fmt.Sprintf("%s is not JSON", path), []string{"files are read as CSV or TSV by their extension and as JSON otherwise"})
+	}
+	return v, nil
+}
+
+// readDelimited parses delimited text with a header row the way from-csv
+// does
+func readDelimited(name string, data []byte, delim rune) (values.Value, *ExecutionError) {
+	in := make(chan values.Value, 1)
+	out := make(chan values.Value)
+	in <- values.String(data)
+	close(in)
+	done := make(chan error, 1)
+	go func() {
+		done <- fromDelimited(context.Background(), name, nil, delim, "", in, out)
+		close(out)
+	}()
+	var rows []*values.Record
+	for v := range out {
+		if r, ok := v.(*values.Record); ok {
+			rows = append(rows, r)
+		}
+	}
+	if err := <-done; err != nil {
+		var ee *ExecutionError
+		if errors.As(err, &ee) {
+			return nil, ee
+		}
+		return nil, NewError(name, 1, err.Error(), nil)
+	}
+	return values.NewTable(rows), nil
+}
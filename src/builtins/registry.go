@@ -1,22 +1,19 @@
 package builtins
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
+
+	"netxp/values"
 )
 
 // ExecutionError represents a structured error from a builtin command
-type ExecutionError struct {
-	Command string      `json:"command"`
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Hints   []string    `json:"hints,omitempty"`
-	Context interface{} `json:"context,omitempty"`
-}
+type ExecutionError = values.Error
 
 // BuiltinFunc is the signature for a builtin command handler
-// It receives: command name, args, input (from pipe), and returns output bytes and error
-type BuiltinFunc func(name string, args []string, input []byte) ([]byte, error)
+// It receives: command name, args, input (from pipe), and returns its result value and error
+type BuiltinFunc func(name string, args []string, input values.Value) (values.Value, error)
 
 // Registry holds all registered builtin commands
 var Registry = make(map[string]BuiltinFunc)
@@ -26,8 +23,62 @@ func Register(name string, fn BuiltinFunc) {
 	Registry[name] = fn
 }
 
-// Execute runs a builtin command by name
-func Execute(name string, args []string, input []byte) ([]byte, error) {
+// noInput are the builtins that ignore their input. The shell does not
+// wait for it, so in `yes | pwd` the producer is stopped once pwd is done.
+var noInput = map[string]bool{
+	"pwd": true, "ls": true, "cd": true, "env": true, "whoami": true, "date": true,
+	"mkdir": true, "rm": true, "cp": true, "mv": true, "find": true,
+}
+
+// TakesInput reports whether a builtin reads its input
+func TakesInput(name string) bool {
+	return !noInput[name]
+}
+
+// DisplayFunc is a builtin that renders its input for the writer its
+// output ends up on, such as tab, which colors and fits a terminal
+type DisplayFunc func(name string, args []string, input values.Value, out io.Writer) (values.Value, error)
+
+// Displays holds the builtins that render for their output writer
+var Displays = make(map[string]DisplayFunc)
+
+// RegisterDisplay adds a builtin that renders for its output writer
+func RegisterDisplay(name string, fn DisplayFunc) {
+	Displays[name] = fn
+}
+
+// Execute runs a builtin command by name. An error value given as input
+// is passed on unchanged, so a failure upstream reaches the end of the
+// pipeline. out is the writer the output is shown on, or nil when it
+// goes on to another stage.
+func Execute(name string, args []string, input values.Value, out io.Writer) (values.Value, error) {
+	if e, ok := input.(*ExecutionError); ok {
+		return e, nil
+	}
+	if input == nil {
+		input = values.Nothing{}
+	}
+	if IsStream(name) {
+		in := make(chan values.Value, 1)
+		out := make(chan values.Value, 64)
+		in <- input
+		close(in)
+		var items []values.Value
+		done := make(chan struct{})
+		go func() {
+			for v := range out {
+				items = append(items, v)
+			}
+			close(done)
+		}()
+		err := RunStream(context.Background(), name, args, Ports{In: in, Out: out})
+		close(out)
+		<-done
+		return values.CollectRows(items), err
+	}
+	if fn, ok := Displays[name]; ok {
+		return fn(name, args, input, out)
+	}
 	fn, exists := Registry[name]
 	if !exists {
 		return nil, fmt.Errorf("command not found: %s", name)
@@ -38,7 +89,8 @@ func Execute(name string, args []string, input []byte) ([]byte, error) {
 // IsBuiltin checks if a command is registered
 func IsBuiltin(name string) bool {
 	_, exists := Registry[name]
-	return exists
+	_, display := Displays[name]
+	return exists || display || IsStream(name)
 }
 
 // List returns all registered builtins
@@ -47,26 +99,32 @@ func List() []string {
 	for name := range Registry {
 		names = append(names, name)
 	}
+	for name := range Streams {
+		names = append(names, name)
+	}
+	for name := range Displays {
+		names = append(names, name)
+	}
 	return names
 }
 
-// StructuredError creates a JSON error response
-func StructuredError(cmd string, code int, msg string, hints []string) []byte {
-	err := ExecutionError{
+// NewError creates a structured error for builtins that fail through
+// their Go error result
+func NewError(cmd string, code int, msg string, hints []string) *ExecutionError {
+	return &ExecutionError{
 		Command: cmd,
 		Code:    code,
 		Message: msg,
 		Hints:   hints,
 	}
-	b, _ := json.Marshal(err)
-	return append(b, '\n')
 }
 
-// StructuredOutput wraps data as JSON with success flag
-func StructuredOutput(data interface{}) []byte {
-	b, _ := json.Marshal(map[string]interface{}{
-		"success": true,
-		"data":    data,
-	})
-	return append(b, '\n')
+// StructuredError creates an error result
+func StructuredError(cmd string, code int, msg string, hints []string) values.Value {
+	return NewError(cmd, code, msg, hints)
+}
+
+// StructuredOutput converts the result of a builtin into a value
+func StructuredOutput(data interface{}) values.Value {
+	return values.FromGo(data)
 }
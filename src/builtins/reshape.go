@@ -0,0 +1,377 @@
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+// countArg reads the optional row count of first, last and skip
+func countArg(name string, args []string, def int) (int, *ExecutionError) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || len(args) > 1 {
+		return 0, NewError(name, 2, fmt.Sprintf("invalid count '%s'", strings.Join(args, " ")), []string{fmt.Sprintf("usage: %s [N]", name)})
+	}
+	return n, nil
+}
+
+// CmdFirst passes on the first N rows, one by default, and stops reading
+func CmdFirst(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	n, e := countArg(name, args, 1)
+	if e != nil {
+		return e
+	}
+	return CmdHead(ctx, name, []string{strconv.Itoa(n)}, in, out)
+}
+
+// CmdLast passes on the last N rows, one by default
+func CmdLast(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	n, e := countArg(name, args, 1)
+	if e != nil {
+		return e
+	}
+	return CmdTail(ctx, name, []string{strconv.Itoa(n)}, in, out)
+}
+
+// CmdSkip drops the first N rows, one by default, and passes on the rest
+func CmdSkip(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	n, e := countArg(name, args, 1)
+	if e != nil {
+		return e
+	}
+	for rec := range in {
+		if n > 0 {
+			n--
+			continue
+		}
+		if !Send(ctx, out, rec) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// rows returns the items of the input, one per line for text. A table
+// keeps its columns so the result can be rebuilt in the same order.
+func rows(input values.Value) ([]values.Value, []string) {
+	switch x := input.(type) {
+	case *values.Table:
+		return values.Items(x), x.Columns
+	case values.String:
+		lines := values.Lines(x)
+		items := make([]values.Value, len(lines))
+		for i, line := range lines {
+			items[i] = values.String(line)
+		}
+		return items, nil
+	}
+	return values.Items(input), nil
+}
+
+// rebuild makes a table (or list) of reordered rows, keeping the column
+// order of the input table
+func rebuild(items []values.Value, columns []string) values.Value {
+	if columns == nil {
+		return values.NewList(items)
+	}
+	recs := make([]*values.Record, 0, len(items))
+	for _, item := range items {
+		r, ok := item.(*values.Record)
+		if !ok {
+			return values.NewList(items)
+		}
+		recs = append(recs, r)
+	}
+	return &values.Table{Columns: columns, Rows: recs}
+}
+
+// checkColumns makes sure every named column exists in a table. A table
+// without rows has no known columns and takes any.
+func checkColumns(name string, input values.Value, cols []string) *ExecutionError {
+	var available []string
+	switch x := input.(type) {
+	case *values.Table:
+		if len(x.Rows) == 0 {
+			return nil
+		}
+		available = x.Columns
+	case values.List:
+		if len(x) == 0 {
+			return nil
+		}
+		return NewError(name, 1, "expected a table, got list", []string{"pipe structured output such as ls into " + name})
+	case *values.Record:
+		available = x.Keys()
+	default:
+		return NewError(name, 1, fmt.Sprintf("expected a table, got %s", input.Kind()), []string{"pipe structured output such as ls into " + name})
+	}
+	_, e := pickColumns(name, available, cols)
+	return e
+}
+
+// field returns a column of a row; rows that are not records have none
+func field(row values.Value, col string) values.Value {
+	if r, ok := row.(*values.Record); ok {
+		if v, ok := r.Get(col); ok {
+			return v
+		}
+	}
+	return values.Nothing{}
+}
+
+// order compares two values for sorting: numbers, strings, filesizes,
+// durations and datetimes in their natural order, then by kind, with
+// nothing last
+func order(a, b values.Value, fold bool) int {
+	na, nb := values.IsNothing(a), values.IsNothing(b)
+	switch {
+	case na && nb:
+		return 0
+	case na:
+		return 1
+	case nb:
+		return -1
+	}
+	if a.Kind() != b.Kind() {
+		return int(a.Kind()) - int(b.Kind())
+	}
+	switch x := a.(type) {
+	case values.Number:
+		y := b.(values.Number)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case values.Bool:
+		y := b.(values.Bool)
+		switch {
+		case x == y:
+			return 0
+		case !bool(x):
+			return -1
+		}
+		return 1
+	case values.Filesize, values.Duration, values.Datetime:
+		c, _ := expr.Compare(a, b)
+		return c
+	}
+	sa, sb := values.Text(a), values.Text(b)
+	if fold {
+		sa, sb = strings.ToLower(sa), strings.ToLower(sb)
+	}
+	return strings.Compare(sa, sb)
+}
+
+// sortKey is one column of sort-by with its direction
+type sortKey struct {
+	col  string
+	desc bool
+}
+
+const sortUsage = "usage: sort-by [col[:desc] ...] [--desc] [-i]"
+
+// CmdSortBy sorts rows by one or more columns. A key written col:desc
+// sorts that column in reverse; --desc reverses every key. Without
+// columns the rows themselves are compared. The sort is stable.
+func CmdSortBy(name string, args []string, input values.Value) (values.Value, error) {
+	var keys []sortKey
+	desc, fold := false, false
+	for _, arg := range args {
+		switch arg {
+		case "-r", "--desc", "--reverse":
+			desc = true
+		case "-i", "--ignore-case":
+			fold = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return StructuredError(name, 2, fmt.Sprintf("unknown option '%s'", arg), []string{sortUsage}), nil
+			}
+			for _, part := range strings.Split(arg, ",") {
+				if part == "" {
+					continue
+				}
+				k := sortKey{col: part}
+				if i := strings.LastIndex(part, ":"); i > 0 {
+					switch part[i+1:] {
+					case "desc":
+						k = sortKey{col: part[:i], desc: true}
+					case "asc":
+						k = sortKey{col: part[:i]}
+					}
+				}
+				keys = append(keys, k)
+			}
+		}
+	}
+	items, columns := rows(input)
+	if len(keys) > 0 && len(items) > 0 {
+		cols := make([]string, len(keys))
+		for i, k := range keys {
+			cols[i] = k.col
+		}
+		if e := checkColumns(name, input, cols); e != nil {
+			return e, nil
+		}
+	}
+	sorted := append([]values.Value{}, items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if len(keys) == 0 {
+			c := order(sorted[i], sorted[j], fold)
+			return (c < 0 && !desc) || (c > 0 && desc)
+		}
+		for _, k := range keys {
+			c := order(field(sorted[i], k.col), field(sorted[j], k.col), fold)
+			if c == 0 {
+				continue
+			}
+			return (c < 0) != (k.desc != desc)
+		}
+		return false
+	})
+	return rebuild(sorted, columns), nil
+}
+
+// CmdReverse reverses the order of rows
+func CmdReverse(name string, args []string, input values.Value) (values.Value, error) {
+	items, columns := rows(input)
+	reversed := make([]values.Value, len(items))
+	for i, item := range items {
+		reversed[len(items)-1-i] = item
+	}
+	return rebuild(reversed, columns), nil
+}
+
+// CmdGroupBy groups rows by the value of a column. Each group is a row
+// with the value, the number of rows and the rows themselves; with more
+// columns the rows of a group are grouped again by the next one.
+func CmdGroupBy(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) == 0 {
+		return StructuredError(name, 2, "missing column", []string{"usage: group-by <col> [col ...]"}), nil
+	}
+	if e := checkColumns(name, input, args); e != nil {
+		return e, nil
+	}
+	items, columns := rows(input)
+	return groupRows(items, columns, args), nil
+}
+
+func groupRows(items []values.Value, columns, cols []string) values.Value {
+	col := cols[0]
+	var keys []string
+	groups := make(map[string][]values.Value)
+	first := make(map[string]values.Value)
+	for _, item := range items {
+		v := field(item, col)
+		k := values.Identity(v)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+			first[k] = v
+		}
+		groups[k] = append(groups[k], item)
+	}
+	out := make([]*values.Record, 0, len(keys))
+	for _, k := range keys {
+		var members values.Value
+		if len(cols) > 1 {
+			members = groupRows(groups[k], columns, cols[1:])
+		} else {
+			members = rebuild(groups[k], columns)
+		}
+		out = append(out, values.NewRecord(col, first[k], "count", len(groups[k]), "items", members))
+	}
+	return values.NewTable(out)
+}
+
+// CmdUniq removes repeated rows. With -c each distinct row gets a count.
+func CmdUniq(name string, args []string, input values.Value) (values.Value, error) {
+	counts, rest, e := uniqArgs(name, args)
+	if e != nil {
+		return e, nil
+	}
+	if len(rest) > 0 {
+		return StructuredError(name, 2, "uniq takes no columns", []string{"use: uniq-by <col> [col ...]"}), nil
+	}
+	return distinct(input, nil, counts), nil
+}
+
+// CmdUniqBy keeps the first row for each distinct combination of columns.
+// With -c each kept row gets the number of rows it stands for.
+func CmdUniqBy(name string, args []string, input values.Value) (values.Value, error) {
+	counts, cols, e := uniqArgs(name, args)
+	if e != nil {
+		return e, nil
+	}
+	if len(cols) == 0 {
+		return StructuredError(name, 2, "missing column", []string{"usage: uniq-by [-c] <col> [col ...]"}), nil
+	}
+	if e := checkColumns(name, input, cols); e != nil {
+		return e, nil
+	}
+	return distinct(input, cols, counts), nil
+}
+
+func uniqArgs(name string, args []string) (bool, []string, *ExecutionError) {
+	counts := false
+	var rest []string
+	for _, arg := range args {
+		switch {
+		case arg == "-c" || arg == "--count":
+			counts = true
+		case strings.HasPrefix(arg, "-"):
+			return false, nil, NewError(name, 2, fmt.Sprintf("unknown option '%s'", arg), []string{"usage: " + name + " [-c] ..."})
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return counts, rest, nil
+}
+
+// distinct keeps the first row of each distinct key, made of the given
+// columns or of the whole row
+func distinct(input values.Value, cols []string, counts bool) values.Value {
+	items, columns := rows(input)
+	var kept []values.Value
+	index := make(map[string]int)
+	var n []int
+	for _, item := range items {
+		var k strings.Builder
+		if cols == nil {
+			k.WriteString(values.Identity(item))
+		}
+		for _, c := range cols {
+			k.WriteString(values.Identity(field(item, c)) + "\x01")
+		}
+		if i, ok := index[k.String()]; ok {
+			n[i]++
+			continue
+		}
+		index[k.String()] = len(kept)
+		kept = append(kept, item)
+		n = append(n, 1)
+	}
+	if !counts {
+		return rebuild(kept, columns)
+	}
+	out := make([]*values.Record, len(kept))
+	for i, item := range kept {
+		if r, ok := item.(*values.Record); ok {
+			c := r.Copy()
+			c.Set("count", values.Number(n[i]))
+			out[i] = c
+		} else {
+			out[i] = values.NewRecord("value", item, "count", n[i])
+		}
+	}
+	return values.NewTable(out)
+}
@@ -0,0 +1,320 @@
+package builtins
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"netxp/values"
+)
+
+// StreamFunc is the signature for a builtin that consumes and produces
+// records incrementally. Records are the items of lists and tables and,
+// for plain text input, one string per line. Returning before in is
+// drained ends the stage early and the shell cancels the stages feeding it.
+type StreamFunc func(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error
+
+// Streams holds all registered streaming builtins
+var Streams = make(map[string]StreamFunc)
+
+// RegisterStream adds a streaming builtin command to the registry
+func RegisterStream(name string, fn StreamFunc) {
+	Streams[name] = fn
+}
+
+// IsStream checks if a command is a registered streaming builtin
+func IsStream(name string) bool {
+	_, exists := Streams[name]
+	return exists
+}
+
+// Ports are the two ends of a running streaming builtin. Each side is
+// either a byte stream, which is decoded or encoded, or a record channel.
+// A nil Reader and In means the stage has no input.
+type Ports struct {
+	Reader io.Reader
+	In     <-chan values.Value
+	Writer io.Writer
+	Out    chan<- values.Value
+}
+
+// RunStream runs a streaming builtin. Byte ports are decoded into records
+// and the records it produces are encoded onto the writer. An error value
+// arriving as input ends the input and is returned as the error of the
+// stage, so a failure upstream reaches the end of the pipeline.
+func RunStream(ctx context.Context, name string, args []string, p Ports) error {
+	fn, exists := Streams[name]
+	if !exists {
+		return fmt.Errorf("command not found: %s", name)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan values.Value, 64)
+	// read holds the outcome of reading the input once it is all sent
+	read := make(chan error, 1)
+	go func() {
+		var err error
+		switch {
+		case p.In != nil:
+			if e := SplitRecords(ctx, p.In, ch); e != nil {
+				err = e
+			}
+		case p.Reader != nil:
+			err = DecodeRecords(ctx, p.Reader, ch)
+		}
+		read <- err
+		close(ch)
+	}()
+	in := (<-chan values.Value)(ch)
+	if p.Out != nil {
+		return inputError(fn(ctx, name, args, in, p.Out), read)
+	}
+
+	out := make(chan values.Value, 64)
+	encoded := make(chan error, 1)
+	wrote := false
+	go func() {
+		var err error
+		wrote, err = EncodeRecords(out, p.Writer, textStreams[name])
+		if err != nil {
+			// the reader went away: stop the producer
+			cancel()
+		}
+		encoded <- err
+	}()
+
+	err := fn(ctx, name, args, in, out)
+	close(out)
+	if encErr := <-encoded; err == nil {
+		err = encErr
+	}
+	err = inputError(err, read)
+	if err == nil && !wrote && !textStreams[name] {
+		// no records are an empty table
+		_, err = io.WriteString(p.Writer, "[]\n")
+	}
+	return err
+}
+
+// inputError combines the error of a streaming builtin with the outcome
+// of reading its input: an upstream error value comes first, and a read
+// error counts when the builtin saw all of its input. A builtin that
+// stopped early does not wait for the rest of its input.
+func inputError(err error, read <-chan error) error {
+	var readErr error
+	select {
+	case readErr = <-read:
+	default:
+		return err
+	}
+	var e *ExecutionError
+	switch {
+	case errors.As(readErr, &e):
+		return e
+	case err == nil && !errors.Is(readErr, context.Canceled):
+		return readErr
+	}
+	return err
+}
+
+// DecodeRecords reads r and sends one record per value. JSON input is
+// decoded value by value: arrays are split into their elements and the
+// StructuredOutput envelope is unwrapped. Other input is sent line by line.
+func DecodeRecords(ctx context.Context, r io.Reader, out chan<- values.Value) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return ignoreEOF(err)
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+	if b, _ := br.Peek(1); b[0] == '[' || b[0] == '{' {
+		// remember what the decoder consumes so the input can be
+		// replayed as text if it turns out not to be JSON
+		var seen bytes.Buffer
+		dec := json.NewDecoder(io.TeeReader(br, &seen))
+		for first := true; ; first = false {
+			v, err := values.DecodeJSON(dec)
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				if first {
+					return decodeLines(ctx, io.MultiReader(&seen, br), out)
+				}
+				return err
+			}
+			for _, rec := range values.Items(values.Unwrap(v)) {
+				if !Send(ctx, out, rec) {
+					return ctx.Err()
+				}
+			}
+			if first {
+				rest := io.MultiReader(dec.Buffered(), br)
+				if oneLine(seen.Bytes()[:dec.InputOffset()], dec.Buffered()) {
+					// newline-delimited JSON: go on line by line so a line
+					// that is not JSON does not end the stream
+					return decodeNDJSON(ctx, rest, out)
+				}
+				seen = bytes.Buffer{}
+				dec = json.NewDecoder(rest)
+			}
+		}
+	}
+	return decodeLines(ctx, br, out)
+}
+
+// oneLine reports whether the first JSON document fit on one line and is
+// followed by a newline, as in NDJSON
+func oneLine(doc []byte, after io.Reader) bool {
+	if bytes.ContainsRune(bytes.TrimSpace(doc), '\n') {
+		return false
+	}
+	var next [2]byte
+	n, _ := after.Read(next[:])
+	return n > 0 && (next[0] == '\n' || next[0] == '\r' && n > 1 && next[1] == '\n')
+}
+
+// decodeNDJSON sends the records of each JSON line; other lines are sent
+// as text
+func decodeNDJSON(ctx context.Context, r io.Reader, out chan<- values.Value) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if text := strings.TrimSpace(line); text != "" {
+			recs := []values.Value{values.String(strings.TrimRight(line, "\r\n"))}
+			if v, perr := values.ParseJSON([]byte(text)); perr == nil {
+				recs = values.Items(values.Unwrap(v))
+			}
+			for _, rec := range recs {
+				if !Send(ctx, out, rec) {
+					return ctx.Err()
+				}
+			}
+		}
+		if err != nil {
+			return ignoreEOF(err)
+		}
+	}
+}
+
+// decodeLines sends each line of r as a string record
+func decodeLines(ctx context.Context, r io.Reader, out chan<- values.Value) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			if !Send(ctx, out, values.String(strings.TrimRight(line, "\r\n"))) {
+				return ctx.Err()
+			}
+		}
+		if err != nil {
+			return ignoreEOF(err)
+		}
+	}
+}
+
+// SplitRecords passes on the records held by values sent over a channel:
+// the items of lists and tables and the lines of multi-line text. It stops
+// at an error value and returns it.
+func SplitRecords(ctx context.Context, in <-chan values.Value, out chan<- values.Value) *ExecutionError {
+	for v := range in {
+		var recs []values.Value
+		switch x := v.(type) {
+		case *ExecutionError:
+			for range in {
+			}
+			return x
+		case values.String:
+			if !strings.Contains(string(x), "\n") {
+				recs = []values.Value{x}
+				break
+			}
+			for _, line := range values.Lines(x) {
+				recs = append(recs, values.String(line))
+			}
+		default:
+			recs = values.Items(v)
+		}
+		for _, rec := range recs {
+			if !Send(ctx, out, rec) {
+				// keep draining so the producer is never stuck
+				for range in {
+				}
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// textStreams write lines of text rather than records
+var textStreams = map[string]bool{"grep": true, "lines": true, "to-csv": true, "to-tsv": true, "to-ndjson": true}
+
+// EncodeRecords writes every record from in to w and reports whether
+// there were any. Records are written as one JSON array, a record per
+// line, so they read back as the table a builtin would have written;
+// text, or any output when text is set, is written line by line. It
+// stops at the first write error; the caller must then stop the producer.
+func EncodeRecords(in <-chan values.Value, w io.Writer, text bool) (bool, error) {
+	bw := bufio.NewWriter(w)
+	array, wrote := false, false
+	for rec := range in {
+		wrote = true
+		switch _, isString := rec.(values.String); {
+		case array:
+			bw.WriteString(",\n")
+		case !text && !isString:
+			array = true
+			bw.WriteString("[")
+		default:
+			text = true
+		}
+		if array {
+			b, err := json.Marshal(rec)
+			if err != nil {
+				b, _ = json.Marshal(values.Text(rec))
+			}
+			bw.Write(b)
+		} else {
+			bw.Write(values.Encode(rec))
+		}
+		// flush whenever the producer has nothing queued so output shows up live
+		if len(in) == 0 {
+			if err := bw.Flush(); err != nil {
+				return wrote, err
+			}
+		}
+	}
+	if array {
+		bw.WriteString("]\n")
+	}
+	return wrote, bw.Flush()
+}
+
+// Send delivers a record unless the stage has been cancelled
+func Send(ctx context.Context, out chan<- values.Value, rec values.Value) bool {
+	select {
+	case out <- rec:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
@@ -1,7 +1,7 @@
 package builtins
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"netxp/values"
 )
 
 // InitDefaultBuiltins registers all default system builtins
@@ -16,7 +18,7 @@ func InitDefaultBuiltins() {
 	Register("pwd", CmdPwd)
 	Register("ls", CmdLs)
 	Register("echo", CmdEcho)
-	Register("tab", CmdTab)
+	RegisterDisplay("tab", CmdTab)
 	Register("select", CmdSelect)
 	Register("cat", CmdCat)
 	Register("cd", CmdCd)
@@ -28,14 +30,49 @@ func InitDefaultBuiltins() {
 	Register("cp", CmdCp)
 	Register("mv", CmdMv)
 	Register("find", CmdFind)
-	Register("grep", CmdGrep)
+	RegisterStream("grep", CmdGrep)
+	RegisterStream("where", CmdWhere)
 	Register("wc", CmdWc)
-	Register("head", CmdHead)
-	Register("tail", CmdTail)
+	RegisterStream("head", CmdHead)
+	RegisterStream("tail", CmdTail)
+	RegisterStream("first", CmdFirst)
+	RegisterStream("last", CmdLast)
+	RegisterStream("skip", CmdSkip)
+	Register("sort-by", CmdSortBy)
+	Register("group-by", CmdGroupBy)
+	Register("uniq", CmdUniq)
+	Register("uniq-by", CmdUniqBy)
+	Register("reverse", CmdReverse)
+	Register("math", CmdMath)
+	Register("insert", CmdInsert)
+	Register("update", CmdUpdate)
+	Register("rename", CmdRename)
+	Register("reject", CmdReject)
+	Register("get", CmdGet)
+	RegisterStream("from-csv", CmdFromCSV)
+	RegisterStream("to-csv", CmdToCSV)
+	RegisterStream("from-tsv", CmdFromTSV)
+	RegisterStream("to-tsv", CmdToTSV)
+	RegisterStream("lines", CmdLines)
+	RegisterStream("split", CmdSplit)
+	RegisterStream("parse", CmdParse)
+	RegisterStream("from-ndjson", CmdFromNDJSON)
+	RegisterStream("to-ndjson", CmdToNDJSON)
+	Register("from-json", CmdFromJSON)
+	Register("to-json", CmdToJSON)
+	Register("from-yaml", CmdFromYAML)
+	Register("to-yaml", CmdToYAML)
+	Register("from-toml", CmdFromTOML)
+	Register("to-toml", CmdToTOML)
+	Register("from-xml", CmdFromXML)
+	Register("to-xml", CmdToXML)
+	Register("query", CmdQuery)
+	Register("diff-data", CmdDiffData)
+	Register("describe", CmdDescribe)
 }
 
 // CmdPwd returns current working directory
-func CmdPwd(name string, args []string, input []byte) ([]byte, error) {
+func CmdPwd(name string, args []string, input values.Value) (values.Value, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return StructuredError(name, 1, err.Error(), []string{"ensure you have read permissions on current directory"}), nil
@@ -43,104 +80,128 @@ func CmdPwd(name string, args []string, input []byte) ([]byte, error) {
 	return StructuredOutput(map[string]string{"pwd": cwd}), nil
 }
 
-// CmdLs lists directory contents
-func CmdLs(name string, args []string, input []byte) ([]byte, error) {
-	path := "."
-	if len(args) > 0 {
-		path = args[0]
+// CmdLs lists directory contents. A single directory lists its entries
+// by name; with several paths, files are listed as given and the entries
+// of directories under their path.
+func CmdLs(name string, args []string, input values.Value) (values.Value, error) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	out := []*values.Record{}
+	var failed []string
+	for _, path := range args {
+		info, err := os.Stat(path)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		if !info.IsDir() {
+			out = append(out, fileRow(path, info))
+			continue
+		}
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		for _, f := range files {
+			entry := f.Name()
+			if len(args) > 1 {
+				entry = filepath.Join(path, entry)
+			}
+			out = append(out, fileRow(entry, f))
+		}
 	}
-	files, err := ioutil.ReadDir(path)
-	if err != nil {
-		return StructuredError(name, 1, err.Error(), []string{"path not found or not accessible"}), nil
-	}
-	out := []map[string]interface{}{}
-	for _, f := range files {
-		out = append(out, map[string]interface{}{
-			"name":    f.Name(),
-			"size":    f.Size(),
-			"isdir":   f.IsDir(),
-			"mode":    f.Mode().String(),
-			"modtime": f.ModTime().Format(time.RFC3339),
-		})
-	}
-	return StructuredOutput(out), nil
+	if len(failed) > 0 {
+		return StructuredError(name, 1, strings.Join(failed, "; "), []string{"path not found or not accessible"}), nil
+	}
+	return values.NewTable(out), nil
 }
 
-// CmdEcho echoes or pretty-prints JSON input
-func CmdEcho(name string, args []string, input []byte) ([]byte, error) {
-	if len(input) == 0 && len(args) > 0 {
-		return []byte(fmt.Sprintln(strings.Join(args, " "))), nil
-	}
-	if len(input) > 0 {
-		var v interface{}
-		if err := json.Unmarshal(input, &v); err == nil {
-			pretty, _ := json.MarshalIndent(v, "", "  ")
-			return append(pretty, '\n'), nil
-		}
-		return input, nil
-	}
-	return []byte("\n"), nil
+// fileRow is the row ls shows for a file
+func fileRow(name string, f os.FileInfo) *values.Record {
+	return values.NewRecord(
+		"name", name,
+		"size", values.Filesize(f.Size()),
+		"isdir", f.IsDir(),
+		"mode", f.Mode().String(),
+		"modtime", values.Datetime(f.ModTime().Truncate(time.Second)),
+	)
 }
 
-// CmdTab formats JSON as table (simplified)
-func CmdTab(name string, args []string, input []byte) ([]byte, error) {
-	if len(input) == 0 {
-		return StructuredError(name, 1, "no input", []string{"pipe data to tab command"}), nil
+// CmdEcho returns its arguments as text or passes its input through
+func CmdEcho(name string, args []string, input values.Value) (values.Value, error) {
+	if values.IsNothing(input) {
+		return values.String(strings.Join(args, " ")), nil
 	}
-	var v interface{}
-	if err := json.Unmarshal(input, &v); err != nil {
-		return StructuredError(name, 1, err.Error(), []string{"input must be valid JSON"}), nil
-	}
-	pretty, _ := json.MarshalIndent(v, "", "  ")
-	return append(pretty, '\n'), nil
+	return input, nil
 }
 
 // CmdSelect filters JSON fields
-func CmdSelect(name string, args []string, input []byte) ([]byte, error) {
+func CmdSelect(name string, args []string, input values.Value) (values.Value, error) {
 	if len(args) < 1 {
 		return StructuredError(name, 1, "missing fields argument", []string{"usage: select field1,field2,field3"}), nil
 	}
-	if len(input) == 0 {
+	if values.IsNothing(input) {
 		return StructuredError(name, 1, "no input", []string{"pipe data to select"}), nil
 	}
-	fields := strings.Split(args[0], ",")
-	fs := make(map[string]bool)
-	for _, f := range fields {
-		fs[strings.TrimSpace(f)] = true
-	}
-	var arr []map[string]interface{}
-	if err := json.Unmarshal(input, &arr); err != nil {
-		return StructuredError(name, 1, err.Error(), []string{"input must be JSON array"}), nil
-	}
-	out := []map[string]interface{}{}
-	for _, item := range arr {
-		row := make(map[string]interface{})
-		for k, v := range item {
-			if fs[k] {
-				row[k] = v
+	var fields []string
+	for _, f := range strings.Split(args[0], ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	pick := func(r *values.Record) *values.Record {
+		row := values.NewRecord()
+		for _, f := range fields {
+			if v, ok := r.Get(f); ok {
+				row.Set(f, v)
 			}
 		}
-		if len(row) > 0 {
-			out = append(out, row)
+		return row
+	}
+	switch v := input.(type) {
+	case *values.Record:
+		return pick(v), nil
+	case *values.Table:
+		out := []*values.Record{}
+		for _, r := range v.Rows {
+			if row := pick(r); row.Len() > 0 {
+				out = append(out, row)
+			}
+		}
+		return values.NewTable(out), nil
+	case values.List:
+		// an empty list is a table without rows
+		if len(v) == 0 {
+			return values.NewTable(nil), nil
 		}
 	}
-	return StructuredOutput(out), nil
+	return StructuredError(name, 1, "cannot select fields from "+input.Kind().String(), []string{"input must be a table or record"}), nil
 }
 
-// CmdCat reads file contents
-func CmdCat(name string, args []string, input []byte) ([]byte, error) {
+// CmdCat reads file contents; several files are read as one, so JSON
+// documents become a list and text is joined
+func CmdCat(name string, args []string, input values.Value) (values.Value, error) {
 	if len(args) < 1 {
-		return StructuredError(name, 1, "missing file argument", []string{"usage: cat <file>"}), nil
+		if !values.IsNothing(input) {
+			return input, nil
+		}
+		return StructuredError(name, 1, "missing file argument", []string{"usage: cat <file> [file...], or cat < file"}), nil
 	}
-	content, err := ioutil.ReadFile(args[0])
-	if err != nil {
-		return StructuredError(name, 1, err.Error(), []string{"file not found or not readable"}), nil
+	var content []byte
+	for _, path := range args {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return StructuredError(name, 1, err.Error(), []string{"file not found or not readable"}), nil
+		}
+		content = append(content, data...)
 	}
-	return content, nil
+	return values.Decode(content), nil
 }
 
 // CmdCd changes directory
-func CmdCd(name string, args []string, input []byte) ([]byte, error) {
+func CmdCd(name string, args []string, input values.Value) (values.Value, error) {
 	if len(args) < 1 {
 		return StructuredError(name, 1, "missing path argument", []string{"usage: cd <path>"}), nil
 	}
@@ -152,7 +213,7 @@ func CmdCd(name string, args []string, input []byte) ([]byte, error) {
 }
 
 // CmdEnv lists environment variables
-func CmdEnv(name string, args []string, input []byte) ([]byte, error) {
+func CmdEnv(name string, args []string, input values.Value) (values.Value, error) {
 	env := os.Environ()
 	envMap := make(map[string]string)
 	for _, e := range env {
@@ -165,7 +226,7 @@ func CmdEnv(name string, args []string, input []byte) ([]byte, error) {
 }
 
 // CmdWhoami returns current user
-func CmdWhoami(name string, args []string, input []byte) ([]byte, error) {
+func CmdWhoami(name string, args []string, input values.Value) (values.Value, error) {
 	user := os.Getenv("USER")
 	if user == "" {
 		user = "unknown"
@@ -174,34 +235,59 @@ func CmdWhoami(name string, args []string, input []byte) ([]byte, error) {
 }
 
 // CmdDate returns current time
-func CmdDate(name string, args []string, input []byte) ([]byte, error) {
+func CmdDate(name string, args []string, input values.Value) (values.Value, error) {
 	return StructuredOutput(map[string]string{"date": time.Now().Format(time.RFC3339)}), nil
 }
 
-// CmdMkdir creates directory
-func CmdMkdir(name string, args []string, input []byte) ([]byte, error) {
+// CmdMkdir creates directories, a row per path
+func CmdMkdir(name string, args []string, input values.Value) (values.Value, error) {
 	if len(args) < 1 {
-		return StructuredError(name, 1, "missing path", []string{"usage: mkdir <path>"}), nil
-	}
-	if err := os.MkdirAll(args[0], 0755); err != nil {
-		return StructuredError(name, 1, err.Error(), []string{"failed to create directory"}), nil
+		return StructuredError(name, 1, "missing path", []string{"usage: mkdir <path> [path...]"}), nil
 	}
-	return StructuredOutput(map[string]string{"created": args[0]}), nil
+	return eachPath(name, "created", args, func(path string) error {
+		return os.MkdirAll(path, 0755)
+	}), nil
 }
 
-// CmdRm removes file
-func CmdRm(name string, args []string, input []byte) ([]byte, error) {
+// CmdRm removes files and directories, a row per path
+func CmdRm(name string, args []string, input values.Value) (values.Value, error) {
 	if len(args) < 1 {
-		return StructuredError(name, 1, "missing path", []string{"usage: rm <path>"}), nil
+		return StructuredError(name, 1, "missing path", []string{"usage: rm <path> [path...]"}), nil
+	}
+	return eachPath(name, "removed", args, func(path string) error {
+		// RemoveAll is happy with a path that is not there; rm is not
+		if _, err := os.Lstat(path); err != nil {
+			return err
+		}
+		return os.RemoveAll(path)
+	}), nil
+}
+
+// eachPath applies fn to every path and returns a row per path, or an
+// error naming the paths that failed and those that were done
+func eachPath(name, done string, paths []string, fn func(path string) error) values.Value {
+	out := []*values.Record{}
+	var failed, did []string
+	for _, path := range paths {
+		if err := fn(path); err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		did = append(did, path)
+		out = append(out, values.NewRecord(done, path))
 	}
-	if err := os.RemoveAll(args[0]); err != nil {
-		return StructuredError(name, 1, err.Error(), []string{"failed to remove"}), nil
+	if len(failed) > 0 {
+		hint := done + " nothing"
+		if len(did) > 0 {
+			hint = done + " " + strings.Join(did, ", ")
+		}
+		return StructuredError(name, 1, strings.Join(failed, "; "), []string{hint})
 	}
-	return StructuredOutput(map[string]string{"removed": args[0]}), nil
+	return values.NewTable(out)
 }
 
 // CmdCp copies file
-func CmdCp(name string, args []string, input []byte) ([]byte, error) {
+func CmdCp(name string, args []string, input values.Value) (values.Value, error) {
 	if len(args) < 2 {
 		return StructuredError(name, 1, "missing src/dst", []string{"usage: cp <src> <dst>"}), nil
 	}
@@ -213,7 +299,7 @@ func CmdCp(name string, args []string, input []byte) ([]byte, error) {
 }
 
 // CmdMv moves/renames file
-func CmdMv(name string, args []string, input []byte) ([]byte, error) {
+func CmdMv(name string, args []string, input values.Value) (values.Value, error) {
 	if len(args) < 2 {
 		return StructuredError(name, 1, "missing src/dst", []string{"usage: mv <src> <dst>"}), nil
 	}
@@ -224,7 +310,7 @@ func CmdMv(name string, args []string, input []byte) ([]byte, error) {
 }
 
 // CmdFind searches for files
-func CmdFind(name string, args []string, input []byte) ([]byte, error) {
+func CmdFind(name string, args []string, input values.Value) (values.Value, error) {
 	if len(args) < 1 {
 		return StructuredError(name, 1, "missing pattern", []string{"usage: find <pattern>"}), nil
 	}
@@ -241,57 +327,76 @@ func CmdFind(name string, args []string, input []byte) ([]byte, error) {
 	return StructuredOutput(matches), nil
 }
 
-// CmdGrep searches in text
-func CmdGrep(name string, args []string, input []byte) ([]byte, error) {
+// CmdGrep passes through the records that contain a pattern
+func CmdGrep(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
 	if len(args) < 1 {
-		return StructuredError(name, 1, "missing pattern", []string{"usage: grep <pattern>"}), nil
+		return NewError(name, 1, "missing pattern", []string{"usage: grep <pattern>"})
 	}
-	lines := strings.Split(string(input), "\n")
-	var matches []string
-	for _, line := range lines {
-		if strings.Contains(line, args[0]) {
-			matches = append(matches, line)
+	for rec := range in {
+		if !strings.Contains(recordText(rec), args[0]) {
+			continue
+		}
+		if !Send(ctx, out, rec) {
+			return ctx.Err()
 		}
 	}
-	return StructuredOutput(matches), nil
+	return nil
 }
 
 // CmdWc counts words/lines
-func CmdWc(name string, args []string, input []byte) ([]byte, error) {
-	text := string(input)
-	lines := len(strings.Split(text, "\n"))
-	words := len(strings.Fields(text))
-	chars := len(text)
-	return StructuredOutput(map[string]int{
-		"lines": lines,
-		"words": words,
-		"chars": chars,
-	}), nil
+func CmdWc(name string, args []string, input values.Value) (values.Value, error) {
+	lines := values.Lines(input)
+	words, chars := 0, 0
+	for _, line := range lines {
+		words += len(strings.Fields(line))
+		chars += len(line) + 1
+	}
+	return values.NewRecord("lines", len(lines), "words", words, "chars", chars), nil
 }
 
-// CmdHead returns first lines
-func CmdHead(name string, args []string, input []byte) ([]byte, error) {
+// CmdHead passes through the first records and then stops reading
+func CmdHead(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
 	count := 10
 	if len(args) > 0 {
 		fmt.Sscanf(args[0], "%d", &count)
 	}
-	lines := strings.Split(string(input), "\n")
-	if len(lines) > count {
-		lines = lines[:count]
+	for i := 0; i < count; i++ {
+		rec, ok := <-in
+		if !ok {
+			return nil
+		}
+		if !Send(ctx, out, rec) {
+			return ctx.Err()
+		}
 	}
-	return StructuredOutput(lines), nil
+	return nil
 }
 
-// CmdTail returns last lines
-func CmdTail(name string, args []string, input []byte) ([]byte, error) {
+// CmdTail keeps the last records and emits them once the input ends
+func CmdTail(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
 	count := 10
 	if len(args) > 0 {
 		fmt.Sscanf(args[0], "%d", &count)
 	}
-	lines := strings.Split(string(input), "\n")
-	start := len(lines) - count
-	if start < 0 {
-		start = 0
+	if count <= 0 {
+		return nil
 	}
-	return StructuredOutput(lines[start:]), nil
+	ring := make([]values.Value, 0, count)
+	for rec := range in {
+		if len(ring) == count {
+			ring = ring[1:]
+		}
+		ring = append(ring, rec)
+	}
+	for _, rec := range ring {
+		if !Send(ctx, out, rec) {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// recordText returns the text a record is matched against
+func recordText(rec values.Value) string {
+	return values.Text(rec)
 }
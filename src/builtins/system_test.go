@@ -0,0 +1,172 @@
+package builtins
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"netxp/values"
+)
+
+func init() {
+	InitDefaultBuiltins()
+}
+
+// jsonOf renders a value as compact JSON for comparisons
+func jsonOf(v values.Value) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// inDir runs the rest of the test in a fresh directory holding files,
+// which maps relative paths to their contents
+func inDir(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+// execute runs a builtin, failing the test on a Go error
+func execute(t *testing.T, name string, args []string, input values.Value) values.Value {
+	t.Helper()
+	v, err := Execute(name, args, input, nil)
+	if err != nil {
+		t.Fatalf("%s %v: %v", name, args, err)
+	}
+	return v
+}
+
+// names returns the sorted names of the files in the current directory
+func names(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Name())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestRmEveryPath(t *testing.T) {
+	inDir(t, map[string]string{"a.log": "", "b.log": "", "c.log": "", "keep.txt": "", "d/x": ""})
+	got := execute(t, "rm", []string{"a.log", "b.log", "c.log", "d"}, values.Nothing{})
+	want := `[{"removed":"a.log"},{"removed":"b.log"},{"removed":"c.log"},{"removed":"d"}]`
+	if jsonOf(got) != want {
+		t.Errorf("rm = %s, want %s", jsonOf(got), want)
+	}
+	if left := names(t); len(left) != 1 || left[0] != "keep.txt" {
+		t.Errorf("left %v, want only keep.txt", left)
+	}
+}
+
+func TestRmMissing(t *testing.T) {
+	inDir(t, map[string]string{"a.log": "", "b.log": ""})
+	got := execute(t, "rm", []string{"a.log", "*.txt", "b.log"}, values.Nothing{})
+	e, ok := got.(*ExecutionError)
+	if !ok {
+		t.Fatalf("rm of a missing path = %s, want an error", jsonOf(got))
+	}
+	if e.Code != 1 || len(e.Hints) != 1 || e.Hints[0] != "removed a.log, b.log" {
+		t.Errorf("error %s, want code 1 naming the removed paths", jsonOf(e))
+	}
+	if left := names(t); len(left) != 0 {
+		t.Errorf("left %v, want the paths that exist removed", left)
+	}
+}
+
+func TestMkdirEveryPath(t *testing.T) {
+	inDir(t, nil)
+	got := execute(t, "mkdir", []string{"a", "b/c"}, values.Nothing{})
+	if want := `[{"created":"a"},{"created":"b/c"}]`; jsonOf(got) != want {
+		t.Errorf("mkdir = %s, want %s", jsonOf(got), want)
+	}
+	for _, dir := range []string{"a", "b/c"} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("%s was not created", dir)
+		}
+	}
+}
+
+func TestLsPaths(t *testing.T) {
+	inDir(t, map[string]string{"a.log": "12", "b.log": "", "d/x.json": "{}"})
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, `["a.log","b.log","d"]`},
+		{[]string{"d"}, `["x.json"]`},
+		{[]string{"a.log", "b.log"}, `["a.log","b.log"]`},
+		{[]string{"d", "a.log"}, `["d/x.json","a.log"]`},
+	}
+	for _, tt := range tests {
+		got := execute(t, "ls", tt.args, values.Nothing{})
+		tbl, ok := got.(*values.Table)
+		if !ok {
+			t.Errorf("ls %v = %s, want a table", tt.args, jsonOf(got))
+			continue
+		}
+		var rows []string
+		for _, r := range tbl.Rows {
+			name, _ := r.Get("name")
+			rows = append(rows, values.Text(name))
+		}
+		if s, _ := json.Marshal(rows); string(s) != tt.want {
+			t.Errorf("ls %v = %s, want %s", tt.args, s, tt.want)
+		}
+	}
+	size, _ := execute(t, "ls", []string{"a.log"}, values.Nothing{}).(*values.Table).Rows[0].Get("size")
+	if size != values.Filesize(2) {
+		t.Errorf("size of a.log = %s, want a filesize of 2", jsonOf(size))
+	}
+	if _, ok := execute(t, "ls", []string{"a.log", "nosuch"}, values.Nothing{}).(*ExecutionError); !ok {
+		t.Error("ls of a missing path gives no error")
+	}
+}
+
+func TestCatFiles(t *testing.T) {
+	inDir(t, map[string]string{"a.md": "one\n", "b.md": "two\n", "1.json": `{"x":1}`, "2.json": `{"x":2}`})
+	tests := []struct {
+		args  []string
+		input values.Value
+		want  string
+	}{
+		{[]string{"a.md"}, values.Nothing{}, `"one"`},
+		{[]string{"a.md", "b.md"}, values.Nothing{}, `"one\ntwo"`},
+		{[]string{"1.json", "2.json"}, values.Nothing{}, `[{"x":1},{"x":2}]`},
+		{nil, values.String("piped"), `"piped"`},
+		{[]string{"a.md"}, values.String("piped"), `"one"`},
+	}
+	for _, tt := range tests {
+		if got := jsonOf(execute(t, "cat", tt.args, tt.input)); got != tt.want {
+			t.Errorf("cat %v = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+	if _, ok := execute(t, "cat", []string{"a.md", "nosuch"}, values.Nothing{}).(*ExecutionError); !ok {
+		t.Error("cat of a missing file gives no error")
+	}
+}
@@ -0,0 +1,451 @@
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"netxp/utils"
+	"netxp/values"
+)
+
+// tabOptions are the flags of tab
+type tabOptions struct {
+	columns   []string
+	wrap      bool
+	transpose bool
+	index     bool
+	color     bool
+	width     int // 0 means no limit
+}
+
+const tabUsage = "usage: tab [-c col1,col2] [-w] [-t] [--width N] [--no-index] [--no-color]"
+
+// parseTabArgs reads the flags of tab. Width and color default to what
+// the terminal on out supports; output that is not going to a terminal,
+// such as a file or another stage, is never truncated or colored.
+func parseTabArgs(name string, args []string, out io.Writer) (*tabOptions, *ExecutionError) {
+	width, isTerm := 0, false
+	if f, ok := out.(*os.File); ok {
+		width, isTerm = utils.TerminalWidth(f)
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 && isTerm {
+		width = cols
+	}
+	opts := &tabOptions{
+		index: true,
+		color: isTerm && os.Getenv("NO_COLOR") == "",
+		width: width,
+	}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-c", "--columns":
+			if i+1 >= len(args) {
+				return nil, NewError(name, 2, arg+" needs a list of columns", []string{tabUsage})
+			}
+			i++
+			for _, c := range strings.Split(args[i], ",") {
+				if c = strings.TrimSpace(c); c != "" {
+					opts.columns = append(opts.columns, c)
+				}
+			}
+		case "-w", "--wrap":
+			opts.wrap = true
+		case "-t", "--transpose":
+			opts.transpose = true
+		case "--no-index":
+			opts.index = false
+		case "--no-color":
+			opts.color = false
+		case "--width":
+			if i+1 >= len(args) {
+				return nil, NewError(name, 2, "--width needs a number", []string{tabUsage})
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return nil, NewError(name, 2, fmt.Sprintf("invalid width '%s'", args[i]), []string{tabUsage})
+			}
+			opts.width = n
+		default:
+			return nil, NewError(name, 2, fmt.Sprintf("unknown option '%s'", arg), []string{tabUsage})
+		}
+	}
+	return opts, nil
+}
+
+// CmdTab renders tables, lists and records as a bordered table for out
+func CmdTab(name string, args []string, input values.Value, out io.Writer) (values.Value, error) {
+	opts, e := parseTabArgs(name, args, out)
+	if e != nil {
+		return e, nil
+	}
+	if values.IsNothing(input) {
+		return StructuredError(name, 1, "no input", []string{"pipe data to tab command"}), nil
+	}
+	g, e := buildGrid(name, input, opts)
+	if e != nil {
+		return e, nil
+	}
+	if g == nil {
+		// text and other scalars are shown as they are
+		return input, nil
+	}
+	return values.String(g.render(opts)), nil
+}
+
+// cell is one rendered value of a table
+type cell struct {
+	text string
+	kind values.Kind
+}
+
+// grid is a table ready to be laid out
+type grid struct {
+	headers []string
+	rows    [][]cell
+	numeric []bool // right-aligned columns
+}
+
+// buildGrid lays a value out as rows and columns. It returns nil for
+// values that are not tabular.
+func buildGrid(name string, input values.Value, opts *tabOptions) (*grid, *ExecutionError) {
+	var headers []string
+	var rows [][]cell
+	switch v := input.(type) {
+	case *values.Table:
+		cols, e := pickColumns(name, v.Columns, opts.columns)
+		if e != nil {
+			return nil, e
+		}
+		if opts.transpose {
+			headers = []string{"column"}
+			for i := range v.Rows {
+				headers = append(headers, strconv.Itoa(i))
+			}
+			for _, c := range cols {
+				row := []cell{{text: c, kind: values.KindString}}
+				for _, r := range v.Rows {
+					row = append(row, fieldCell(r, c))
+				}
+				rows = append(rows, row)
+			}
+			opts.index = false
+			break
+		}
+		headers = cols
+		for _, r := range v.Rows {
+			row := make([]cell, len(cols))
+			for i, c := range cols {
+				row[i] = fieldCell(r, c)
+			}
+			rows = append(rows, row)
+		}
+	case *values.Record:
+		cols, e := pickColumns(name, v.Keys(), opts.columns)
+		if e != nil {
+			return nil, e
+		}
+		if opts.transpose {
+			headers = []string{"key", "value"}
+			for _, c := range cols {
+				rows = append(rows, []cell{{text: c, kind: values.KindString}, fieldCell(v, c)})
+			}
+			opts.index = false
+			break
+		}
+		headers = cols
+		row := make([]cell, len(cols))
+		for i, c := range cols {
+			row[i] = fieldCell(v, c)
+		}
+		rows = append(rows, row)
+	case values.List:
+		headers = []string{"value"}
+		for _, item := range v {
+			rows = append(rows, []cell{newCell(item)})
+		}
+	default:
+		return nil, nil
+	}
+
+	g := &grid{headers: headers, rows: rows, numeric: make([]bool, len(headers))}
+	for c := range headers {
+		numeric, any := true, false
+		for _, row := range rows {
+			switch row[c].kind {
+			case values.KindNumber, values.KindFilesize, values.KindDuration:
+				any = true
+			case values.KindNothing:
+			default:
+				numeric = false
+			}
+		}
+		g.numeric[c] = numeric && any
+	}
+	if opts.index {
+		g.headers = append([]string{"#"}, g.headers...)
+		g.numeric = append([]bool{true}, g.numeric...)
+		for i := range g.rows {
+			g.rows[i] = append([]cell{{text: strconv.Itoa(i), kind: -1}}, g.rows[i]...)
+		}
+	}
+	return g, nil
+}
+
+// pickColumns applies the column choice of -c to the available columns
+func pickColumns(name string, available, chosen []string) ([]string, *ExecutionError) {
+	if len(chosen) == 0 {
+		return available, nil
+	}
+	have := make(map[string]bool, len(available))
+	for _, c := range available {
+		have[c] = true
+	}
+	for _, c := range chosen {
+		if !have[c] {
+			return nil, NewError(name, 1, fmt.Sprintf("no column '%s'", c), []string{"available columns: " + strings.Join(available, ", ")})
+		}
+	}
+	return chosen, nil
+}
+
+func fieldCell(r *values.Record, key string) cell {
+	v, ok := r.Get(key)
+	if !ok {
+		return cell{kind: values.KindNothing}
+	}
+	return newCell(v)
+}
+
+func newCell(v values.Value) cell {
+	if v == nil {
+		v = values.Nothing{}
+	}
+	return cell{text: compact(v, 0), kind: v.Kind()}
+}
+
+// compact renders a value on one line; nested values are abbreviated
+// and filesizes, durations and datetimes are written for reading
+func compact(v values.Value, depth int) string {
+	switch x := v.(type) {
+	case values.Nothing:
+		return ""
+	case values.Binary:
+		return fmt.Sprintf("<binary %d bytes>", len(x))
+	case values.List:
+		if depth > 0 {
+			return fmt.Sprintf("[%d items]", len(x))
+		}
+		parts := make([]string, len(x))
+		for i, item := range x {
+			parts[i] = compact(item, depth+1)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case *values.Table:
+		return fmt.Sprintf("[table %d rows]", len(x.Rows))
+	case *values.Record:
+		if depth > 0 {
+			return "{…}"
+		}
+		parts := make([]string, 0, x.Len())
+		for _, k := range x.Keys() {
+			field, _ := x.Get(k)
+			parts = append(parts, k+": "+compact(field, depth+1))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case *values.Error:
+		return "error: " + x.Message
+	case values.Filesize:
+		return x.Human()
+	case values.Duration:
+		return x.Human()
+	case values.Datetime:
+		return x.Human()
+	}
+	return values.Text(v)
+}
+
+// render draws the grid with box borders, fitting it into opts.width
+func (g *grid) render(opts *tabOptions) string {
+	widths := make([]int, len(g.headers))
+	for c, h := range g.headers {
+		widths[c] = textWidth(h)
+		for _, row := range g.rows {
+			for _, line := range strings.Split(row[c].text, "\n") {
+				if w := textWidth(line); w > widths[c] {
+					widths[c] = w
+				}
+			}
+		}
+	}
+	if opts.width > 0 {
+		fit(widths, opts.width-(3*len(widths)+1))
+	}
+
+	var b strings.Builder
+	border := func(left, mid, right string) {
+		b.WriteString(left)
+		for c, w := range widths {
+			if c > 0 {
+				b.WriteString(mid)
+			}
+			b.WriteString(strings.Repeat("─", w+2))
+		}
+		b.WriteString(right + "\n")
+	}
+	line := func(cells [][]string, paint func(c int, s string) string) {
+		height := 1
+		for _, lines := range cells {
+			if len(lines) > height {
+				height = len(lines)
+			}
+		}
+		for l := 0; l < height; l++ {
+			b.WriteString("│")
+			for c, lines := range cells {
+				text := ""
+				if l < len(lines) {
+					text = lines[l]
+				}
+				pad := strings.Repeat(" ", widths[c]-textWidth(text))
+				if text != "" {
+					text = paint(c, text)
+				}
+				if g.numeric[c] {
+					b.WriteString(" " + pad + text + " │")
+				} else {
+					b.WriteString(" " + text + pad + " │")
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	border("╭", "┬", "╮")
+	headers := make([][]string, len(g.headers))
+	for c, h := range g.headers {
+		headers[c] = []string{truncate(h, widths[c])}
+	}
+	line(headers, func(c int, s string) string {
+		if opts.color {
+			return utils.Colorize(s, utils.CBold+utils.CGreen)
+		}
+		return s
+	})
+	border("├", "┼", "┤")
+	for _, row := range g.rows {
+		cells := make([][]string, len(row))
+		for c, cl := range row {
+			if opts.wrap {
+				cells[c] = wrapText(cl.text, widths[c])
+			} else {
+				cells[c] = []string{truncate(strings.ReplaceAll(cl.text, "\n", "↵"), widths[c])}
+			}
+		}
+		line(cells, func(c int, s string) string {
+			if !opts.color {
+				return s
+			}
+			if color := kindColor(row[c].kind); color != "" {
+				return utils.Colorize(s, color)
+			}
+			return s
+		})
+	}
+	border("╰", "┴", "╯")
+	return b.String()
+}
+
+// kindColor is the color of a cell by type; -1 marks the index column
+func kindColor(k values.Kind) string {
+	switch k {
+	case -1:
+		return utils.CDim
+	case values.KindNumber, values.KindFilesize, values.KindDuration:
+		return utils.CCyan
+	case values.KindDatetime:
+		return utils.CBlue
+	case values.KindBool:
+		return utils.CYellow
+	case values.KindList, values.KindRecord, values.KindTable:
+		return utils.CMagenta
+	case values.KindBinary, values.KindNothing:
+		return utils.CDim
+	case values.KindError:
+		return utils.CRed
+	}
+	return ""
+}
+
+// fit narrows the widest columns until the widths add up to at most
+// total. No column gets narrower than 3, or its natural width if less.
+func fit(widths []int, total int) {
+	sum := 0
+	for _, w := range widths {
+		sum += w
+	}
+	for sum > total {
+		widest := 0
+		for c, w := range widths {
+			if w > widths[widest] {
+				widest = c
+			}
+		}
+		if widths[widest] <= 3 {
+			return
+		}
+		widths[widest]--
+		sum--
+	}
+}
+
+func textWidth(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// truncate shortens s to width runes, marking the cut with an ellipsis
+func truncate(s string, width int) string {
+	if textWidth(s) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// wrapText breaks s into lines of at most width runes, at spaces where
+// possible
+func wrapText(s string, width int) []string {
+	var out []string
+	for _, para := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			for textWidth(word) > width {
+				if line != "" {
+					out = append(out, line)
+					line = ""
+				}
+				runes := []rune(word)
+				out = append(out, string(runes[:width]))
+				word = string(runes[width:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case textWidth(line)+1+textWidth(word) <= width:
+				line += " " + word
+			default:
+				out = append(out, line)
+				line = word
+			}
+		}
+		out = append(out, line)
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package builtins
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"netxp/values"
+)
+
+// tabOf renders input with tab as if for a file or another stage
+func tabOf(t *testing.T, args []string, input values.Value) string {
+	t.Helper()
+	v, err := CmdTab("tab", args, input, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := v.(*ExecutionError); ok {
+		return "error: " + e.Message
+	}
+	return values.Text(v)
+}
+
+func TestTab(t *testing.T) {
+	hosts := values.Decode([]byte(`[{"name":"web1","cpu":4,"tags":["a","b"]},{"name":"a much longer name","cpu":16}]`))
+	tests := []struct {
+		args  []string
+		input values.Value
+		want  string
+	}{
+		{nil, hosts, `╭───┬────────────────────┬─────┬────────╮
+│ # │ name               │ cpu │ tags   │
+├───┼────────────────────┼─────┼────────┤
+│ 0 │ web1               │   4 │ [a, b] │
+│ 1 │ a much longer name │  16 │        │
+╰───┴────────────────────┴─────┴────────╯`},
+		{[]string{"-c", "cpu,name", "--no-index"}, hosts, `╭─────┬────────────────────╮
+│ cpu │ name               │
+├─────┼────────────────────┤
+│   4 │ web1               │
+│  16 │ a much longer name │
+╰─────┴────────────────────╯`},
+		{[]string{"--width", "24"}, hosts, `╭───┬─────┬─────┬──────╮
+│ # │ na… │ cpu │ tags │
+├───┼─────┼─────┼──────┤
+│ 0 │ we… │   4 │ [a,… │
+│ 1 │ a … │  16 │      │
+╰───┴─────┴─────┴──────╯`},
+		{[]string{"--width", "24", "-w", "-c", "name"}, hosts, `╭───┬──────────────────╮
+│ # │ name             │
+├───┼──────────────────┤
+│ 0 │ web1             │
+│ 1 │ a much longer    │
+│   │ name             │
+╰───┴──────────────────╯`},
+		{[]string{"-t"}, hosts, `╭────────┬────────┬────────────────────╮
+│ column │ 0      │ 1                  │
+├────────┼────────┼────────────────────┤
+│ name   │ web1   │ a much longer name │
+│ cpu    │ 4      │ 16                 │
+│ tags   │ [a, b] │                    │
+╰────────┴────────┴────────────────────╯`},
+		{nil, values.Decode([]byte(`{"a":1,"b":"x"}`)), `╭───┬───┬───╮
+│ # │ a │ b │
+├───┼───┼───┤
+│ 0 │ 1 │ x │
+╰───┴───┴───╯`},
+		{nil, values.String("plain text"), `plain text`},
+		{[]string{"-c", "nosuch"}, hosts, `error: no column 'nosuch'`},
+		{[]string{"--width", "x"}, hosts, `error: invalid width 'x'`},
+	}
+	for _, tt := range tests {
+		got := strings.TrimSpace(tabOf(t, tt.args, tt.input))
+		if got != tt.want {
+			t.Errorf("tab %v:\n%s\nwant:\n%s", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"netxp/values"
+)
+
+const (
+	splitUsage = "usage: split column <separator> [col ...] [-c] [-r] | split row <separator> [-c] [-r]"
+	parseUsage = "usage: parse \"{col} {col}\" | parse --regex '(?P<col>...)' [--no-infer]"
+)
+
+// recordLines returns the lines of text a record holds. Records that are
+// not text are read as their JSON form.
+func recordLines(rec values.Value) []string {
+	if s, ok := rec.(values.String); ok && !strings.Contains(string(s), "\n") {
+		return []string{strings.TrimRight(string(s), "\r")}
+	}
+	return values.Lines(values.String(values.Text(rec)))
+}
+
+// CmdLines turns text into one row per line. With -s empty lines are
+// dropped.
+func CmdLines(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	skip := false
+	for _, arg := range args {
+		switch arg {
+		case "-s", "--skip-empty":
+			skip = true
+		default:
+			return NewError(name, 2, fmt.Sprintf("unknown option '%s'", arg), []string{"usage: lines [-s]"})
+		}
+	}
+	for rec := range in {
+		for _, line := range recordLines(rec) {
+			if skip && strings.TrimSpace(line) == "" {
+				continue
+			}
+			if !Send(ctx, out, values.String(line)) {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// splitter cuts a line at a literal or regex separator
+type splitter struct {
+	sep      string
+	re       *regexp.Regexp
+	collapse bool
+}
+
+func (s *splitter) split(line string, n int) []string {
+	var parts []string
+	switch {
+	case s.re != nil:
+		parts = s.re.Split(line, n)
+	case s.collapse && strings.TrimSpace(s.sep) == "":
+		// runs of blanks are one separator, as in aligned command output
+		parts = strings.Fields(line)
+		if n > 0 && len(parts) > n {
+			// the last column keeps the rest of the line as it was
+			rest := line
+			for i := 0; i < n-1; i++ {
+				rest = strings.TrimLeft(rest, " \t")
+				rest = rest[len(parts[i]):]
+			}
+			parts = append(parts[:n-1], strings.TrimLeft(rest, " \t"))
+		}
+		return parts
+	default:
+		parts = strings.SplitN(line, s.sep, n)
+	}
+	if !s.collapse {
+		return parts
+	}
+	kept := parts[:0]
+	for _, p := range parts {
+		if p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// CmdSplit cuts text at a separator. "split column" makes a row per line
+// with a column per field; with names, the last named column keeps the
+// rest of the line. "split row" makes a row per field.
+func CmdSplit(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	var rest []string
+	sp := &splitter{}
+	regex := false
+	for _, arg := range args {
+		switch arg {
+		case "-c", "--collapse-empty":
+			sp.collapse = true
+		case "-r", "--regex":
+			regex = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) < 2 || (rest[0] != "column" && rest[0] != "row") {
+		return NewError(name, 2, "expected 'column' or 'row' and a separator", []string{splitUsage})
+	}
+	mode, names := rest[0], uniqueNames(rest[2:])
+	sp.sep = rest[1]
+	if sp.sep == "" {
+		return NewError(name, 2, "empty separator", []string{splitUsage})
+	}
+	if regex {
+		re, err := regexp.Compile(sp.sep)
+		if err != nil {
+			return NewError(name, 2, fmt.Sprintf("invalid regex '%s': %s", sp.sep, err), []string{splitUsage})
+		}
+		sp.re = re
+	}
+	if mode == "row" && len(names) > 0 {
+		return NewError(name, 2, "split row takes no column names", []string{splitUsage})
+	}
+	n := -1
+	if len(names) > 0 {
+		n = len(names)
+	}
+	for rec := range in {
+		for _, line := range recordLines(rec) {
+			if mode == "row" {
+				for _, part := range sp.split(line, -1) {
+					if !Send(ctx, out, values.String(part)) {
+						return ctx.Err()
+					}
+				}
+				continue
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			row := values.NewRecord()
+			for i, part := range sp.split(line, n) {
+				col := fmt.Sprintf("column%d", i)
+				if i < len(names) {
+					col = names[i]
+				}
+				row.Set(col, inferValue(strings.TrimSpace(part)))
+			}
+			if !Send(ctx, out, row) {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	patternField  = regexp.MustCompile(`\{([^{}]*)\}`)
+	patternBlanks = regexp.MustCompile(`[ \t]+`)
+	columnName    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// patternRegex turns a pattern such as "{user} {pid} {cmd}" into a regular
+// expression. Fields match as little as they can, except the last which
+// takes the rest of the line; a run of blanks matches any run of blanks;
+// {_} matches without making a column.
+func patternRegex(pattern string) (string, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	literal := func(s string) {
+		for i, part := range patternBlanks.Split(s, -1) {
+			if i > 0 {
+				b.WriteString(`\s+`)
+			}
+			b.WriteString(regexp.QuoteMeta(part))
+		}
+	}
+	locs := patternField.FindAllStringSubmatchIndex(pattern, -1)
+	if len(locs) == 0 {
+		return "", fmt.Errorf("pattern '%s' has no {column}", pattern)
+	}
+	last := 0
+	for i, loc := range locs {
+		literal(pattern[last:loc[0]])
+		field := strings.TrimSpace(pattern[loc[2]:loc[3]])
+		capture := ".*?"
+		if i == len(locs)-1 && loc[1] == len(pattern) {
+			capture = ".*"
+		}
+		switch {
+		case field == "_":
+			b.WriteString("(?:" + capture + ")")
+		case columnName.MatchString(field):
+			b.WriteString("(?P<" + field + ">" + capture + ")")
+		default:
+			return "", fmt.Errorf("invalid column name '{%s}': use letters, digits and _", field)
+		}
+		last = loc[1]
+	}
+	literal(pattern[last:])
+	b.WriteString("$")
+	return b.String(), nil
+}
+
+// CmdParse extracts columns from each line of text with a pattern of
+// {column} fields or, with --regex, a regular expression whose named
+// groups become the columns. Lines that do not match are dropped.
+func CmdParse(ctx context.Context, name string, args []string, in <-chan values.Value, out chan<- values.Value) error {
+	regex, infer := false, true
+	var rest []string
+	for _, arg := range args {
+		switch arg {
+		case "-r", "--regex":
+			regex = true
+		case "--no-infer":
+			infer = false
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) != 1 {
+		return NewError(name, 2, "expected one pattern", []string{parseUsage, "quote the pattern so it stays one word"})
+	}
+	src := rest[0]
+	if !regex {
+		var err error
+		if src, err = patternRegex(rest[0]); err != nil {
+			return NewError(name, 2, err.Error(), []string{parseUsage})
+		}
+	}
+	re, err := regexp.Compile(src)
+	if err != nil {
+		return NewError(name, 2, fmt.Sprintf("invalid regex '%s': %s", rest[0], err), []string{parseUsage})
+	}
+	if re.NumSubexp() == 0 {
+		return NewError(name, 2, "the regex has no groups to make columns from", []string{"name the groups, e.g. (?P<ip>\\S+)"})
+	}
+	cols := make([]string, 0, re.NumSubexp())
+	for i, n := range re.SubexpNames()[1:] {
+		if n == "" {
+			n = fmt.Sprintf("capture%d", i)
+		}
+		cols = append(cols, n)
+	}
+	for rec := range in {
+		for _, line := range recordLines(rec) {
+			m := re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			row := values.NewRecord()
+			for i, col := range cols {
+				if infer {
+					row.Set(col, inferValue(strings.TrimSpace(m[i+1])))
+				} else {
+					row.Set(col, values.String(m[i+1]))
+				}
+			}
+			if !Send(ctx, out, row) {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
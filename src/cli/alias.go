@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"netxp/builtins"
+	"netxp/utils"
+	"netxp/values"
+)
+
+// Aliases are user-defined commands stored in the config:
+//
+//	alias name = <pipeline>   define (or redefine) name
+//	alias [name]              list all aliases, or show one
+//	unalias name              remove an alias
+//
+// The pipeline sees the arguments of the call as $1 .. $9 and $args. A
+// pipeline that uses none of them gets the arguments appended instead.
+
+var (
+	aliasMu   sync.RWMutex // guards Config.Aliases
+	aliasName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	argRef    = regexp.MustCompile(`\$(\{?[1-9]|\{?args\b)`)
+)
+
+// reserved names are handled by the shell itself and cannot be aliased
+var reserved = map[string]bool{"alias": true, "unalias": true, "let": true, "source": true}
+
+// aliasAssignment recognizes "alias name = <pipeline>" and returns the
+// name and the unexpanded pipeline text
+func aliasAssignment(stages []utils.Command) (string, string, bool, error) {
+	first := stages[0]
+	if len(first.Words) < 3 || first.Words[0].Raw != "alias" || first.Words[2].Raw != "=" {
+		return "", "", false, nil
+	}
+	name := first.Words[1].Raw
+	if !aliasName.MatchString(name) || reserved[name] {
+		return "", "", true, &utils.SyntaxError{Pos: first.Words[1].Pos, Msg: fmt.Sprintf("invalid alias name '%s'", name)}
+	}
+	rest := utils.Command{Words: first.Words[3:], Redirects: first.Redirects}
+	if len(rest.Words) == 0 {
+		return "", "", true, fmt.Errorf("usage: alias <name> = <pipeline>")
+	}
+	// a single quoted word holds a whole command line, e.g. 'a; b'
+	if len(stages) == 1 && len(rest.Words) == 1 && len(rest.Redirects) == 0 {
+		if w := rest.Words[0]; strings.HasPrefix(w.Raw, "'") || strings.HasPrefix(w.Raw, `"`) {
+			return name, w.Value, true, nil
+		}
+	}
+	texts := []string{rest.Raw()}
+	for _, c := range stages[1:] {
+		texts = append(texts, c.Raw())
+	}
+	return name, strings.Join(texts, " | "), true, nil
+}
+
+// defineAlias stores an alias and saves the config
+func (s *Shell) defineAlias(name, body string) error {
+	aliasMu.Lock()
+	s.cfg.Aliases[name] = body
+	aliasMu.Unlock()
+	return s.cfg.Save()
+}
+
+// lookupAlias returns the pipeline of an alias that is not already being
+// expanded. Inside its own expansion a name means the command it shadows,
+// so "alias ls = ls -l" works and aliases cannot recurse.
+func (s *Shell) lookupAlias(name string) (string, bool) {
+	for _, active := range s.expanding {
+		if active == name {
+			return "", false
+		}
+	}
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	body, ok := s.cfg.Aliases[name]
+	return body, ok
+}
+
+// callAlias runs the pipeline of an alias with the arguments of the call
+func (s *Shell) callAlias(name, body string, args []values.Value, sio *stageIO) error {
+	if s.depth >= maxDepth {
+		return fmt.Errorf("%s: maximum call depth exceeded", name)
+	}
+	if !argRef.MatchString(body) {
+		body += " $args"
+	}
+	child := *s
+	child.scope = s.scope.call(bindArgs(args))
+	child.depth++
+	child.expanding = append(s.expanding[:len(s.expanding):len(s.expanding)], name)
+	child.stdin = sio.stdin
+	return child.runList(body, sio.stdout)
+}
+
+// aliasCommand implements "alias [name]" and "unalias name"
+func (s *Shell) aliasCommand(ctx context.Context, cmdName string, args []string, sio *stageIO) error {
+	var out values.Value
+	switch {
+	case cmdName == "unalias" && len(args) == 1:
+		aliasMu.Lock()
+		body, ok := s.cfg.Aliases[args[0]]
+		delete(s.cfg.Aliases, args[0])
+		aliasMu.Unlock()
+		if !ok {
+			return s.fail(ctx, sio, builtins.NewError(cmdName, 1, fmt.Sprintf("no such alias: %s", args[0]), []string{"list aliases with: alias"}))
+		}
+		if err := s.cfg.Save(); err != nil {
+			return err
+		}
+		out = values.NewRecord("name", args[0], "command", body, "removed", true)
+	case cmdName == "unalias":
+		return s.fail(ctx, sio, builtins.NewError(cmdName, 2, "usage: unalias <name>", nil))
+	case len(args) == 0:
+		aliasMu.RLock()
+		names := make([]string, 0, len(s.cfg.Aliases))
+		for name := range s.cfg.Aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rows := make([]*values.Record, 0, len(names))
+		for _, name := range names {
+			rows = append(rows, values.NewRecord("name", name, "command", s.cfg.Aliases[name]))
+		}
+		aliasMu.RUnlock()
+		out = values.NewTable(rows)
+	case len(args) == 1:
+		aliasMu.RLock()
+		body, ok := s.cfg.Aliases[args[0]]
+		aliasMu.RUnlock()
+		if !ok {
+			return s.fail(ctx, sio, builtins.NewError(cmdName, 1, fmt.Sprintf("no such alias: %s", args[0]), []string{"define one with: alias <name> = <pipeline>"}))
+		}
+		out = values.NewRecord("name", args[0], "command", body)
+	default:
+		return s.fail(ctx, sio, builtins.NewError(cmdName, 2, "usage: alias [name] or alias <name> = <pipeline>", nil))
+	}
+	return sio.emit(ctx, out)
+}
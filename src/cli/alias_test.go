@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"testing"
+)
+
+// TestAliases checks alias arguments, shadowing, listing and removal,
+// and that aliases are kept in the config for the next shell
+func TestAliases(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`alias hello = echo hi $1; hello bob`, `hi bob`},
+		{`alias greet = echo hi; greet bob ann`, `hi bob ann`},
+		{`alias all = echo $args; all a b c`, `a b c`},
+		{`alias two = "echo a; echo b"; two`, "a\nb"},
+		{`alias ls = ls a.txt; ls | get name`, `["a.txt"]`},
+		{`alias files = ls | get name; files`, `["a.txt"]`},
+		{`unalias ls | get removed`, `true`},
+		{`files`, `["a.txt","b.txt"]`},
+		{`alias hello`, `{"name":"hello","command":"echo hi $1"}`},
+		{`alias | get name`, `["all","files","greet","hello","two"]`},
+	}
+	files := map[string]string{"a.txt": "a", "b.txt": "b"}
+	sh := newTestShell(t, files)
+	for _, tt := range tests {
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.line, got, tt.want)
+		}
+	}
+	if _, err := run(t, sh, `alias let = echo`); err == nil {
+		t.Errorf("alias let: succeeded")
+	}
+
+	next, err := NewBatchShell(FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runOK(t, next, `hello again`); got != "hi again" {
+		t.Errorf("hello in a new shell: got %s", got)
+	}
+}
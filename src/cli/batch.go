@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"netxp/builtins"
+	"netxp/config"
+	"netxp/utils"
+	"netxp/values"
+)
+
+// Output formats of a non-interactive shell
+const (
+	FormatJSON   = "json"   // one compact JSON document per line
+	FormatPretty = "pretty" // indented JSON and colored errors
+)
+
+// NewBatchShell creates a shell that runs commands without a terminal.
+// JSON written to stdout is re-emitted in the given format.
+func NewBatchShell(format string) (*Shell, error) {
+	if format != FormatJSON && format != FormatPretty {
+		return nil, fmt.Errorf("unknown output format '%s' (use %s or %s)", format, FormatJSON, FormatPretty)
+	}
+	cfg, _ := config.Load()
+	builtins.InitDefaultBuiltins()
+	return &Shell{cfg: cfg, scope: newScope(), funcs: newFuncTable(), format: format}, nil
+}
+
+// RunCommand runs a command line, as given to netxp -c, and returns the
+// exit status. A non-nil stdin, such as data piped to netxp, is the input
+// of the first stage of a pipeline when that stage reads its input.
+func (s *Shell) RunCommand(line string, stdin io.Reader) int {
+	s.pipedIn = stdin
+	return s.runBatch(func(out io.Writer) error {
+		return s.runSource(line, "", out)
+	})
+}
+
+// RunFile runs a .nxp script with arguments and returns the exit status
+func (s *Shell) RunFile(path string, args []string) int {
+	return s.runBatch(func(out io.Writer) error {
+		vals := make([]values.Value, len(args))
+		for i, a := range args {
+			vals[i] = wordValue(a)
+		}
+		return s.runScript(path, vals, nil, out)
+	})
+}
+
+// RunReader runs a whole script read from r, such as a piped stdin, and
+// returns the exit status
+func (s *Shell) RunReader(r io.Reader, name string) int {
+	return s.runBatch(func(out io.Writer) error {
+		src, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return s.runSource(string(src), name, out)
+	})
+}
+
+// runBatch runs fn with formatted stdout and maps its result to a status
+func (s *Shell) runBatch(fn func(out io.Writer) error) int {
+	out := &formatWriter{w: os.Stdout, format: s.format}
+	err := fn(out)
+	out.Flush()
+	if err == nil {
+		return 0
+	}
+	s.reportError(err)
+	return batchStatus(err)
+}
+
+// batchStatus is statusOf with syntax errors exiting 2, as in sh
+func batchStatus(err error) int {
+	var syn *utils.SyntaxError
+	if errors.As(err, &syn) {
+		return 2
+	}
+	return statusOf(err)
+}
+
+// batchError reports an error of a non-interactive shell on stderr
+func (s *Shell) batchError(err error) {
+	if s.format == FormatJSON {
+		os.Stderr.Write(utils.ToJSON(builtins.NewError("netxp", batchStatus(err), err.Error(), nil)))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", utils.ColorizeError("error"), err.Error())
+}
+
+// formatWriter re-emits complete JSON documents in its format, line by
+// line so that streaming output stays streaming. Other text passes
+// through unchanged.
+type formatWriter struct {
+	w       io.Writer
+	format  string
+	line    []byte // incomplete last line
+	pending []byte // lines of a JSON document that is not complete yet
+}
+
+// maxPending bounds how much of an unfinished JSON document is held back
+const maxPending = 1 << 20
+
+func (f *formatWriter) Write(p []byte) (int, error) {
+	f.line = append(f.line, p...)
+	for {
+		i := bytes.IndexByte(f.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := f.line[:i+1]
+		if err := f.writeLine(line); err != nil {
+			return len(p), err
+		}
+		f.line = f.line[i+1:]
+	}
+}
+
+func (f *formatWriter) writeLine(line []byte) error {
+	if len(f.pending) == 0 {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+			_, err := f.w.Write(line)
+			return err
+		}
+	}
+	f.pending = append(f.pending, line...)
+	var doc json.RawMessage
+	err := json.Unmarshal(f.pending, &doc)
+	if err == nil {
+		return f.emit()
+	}
+	// only a document cut short is worth waiting for; text such as
+	// "[INFO] ..." is not JSON at all
+	var syn *json.SyntaxError
+	if !errors.As(err, &syn) || syn.Error() != "unexpected end of JSON input" || len(f.pending) > maxPending {
+		return f.passPending()
+	}
+	return nil
+}
+
+// emit writes the complete JSON document held back in the output format,
+// keeping the order of its keys
+func (f *formatWriter) emit() error {
+	var out bytes.Buffer
+	if f.format == FormatPretty {
+		json.Indent(&out, bytes.TrimSpace(f.pending), "", "  ")
+	} else {
+		json.Compact(&out, f.pending)
+	}
+	out.WriteByte('\n')
+	f.pending = f.pending[:0]
+	_, err := f.w.Write(out.Bytes())
+	return err
+}
+
+func (f *formatWriter) passPending() error {
+	_, err := f.w.Write(f.pending)
+	f.pending = f.pending[:0]
+	return err
+}
+
+// Flush writes whatever is held back
+func (f *formatWriter) Flush() error {
+	if len(f.line) > 0 {
+		f.writeLine(f.line)
+		f.line = nil
+	}
+	if len(f.pending) > 0 {
+		return f.passPending()
+	}
+	return nil
+}
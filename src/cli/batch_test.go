@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestPipedInput checks that data piped to netxp -c only feeds a first
+// stage that reads its input
+func TestPipedInput(t *testing.T) {
+	tests := []struct {
+		line string
+		in   string
+		want string
+	}{
+		{`from-csv`, "a,b\n1,2\n", `[{"a":1,"b":2}]`},
+		{`lines | first 1`, "x\ny\n", `x`},
+		{`wc`, "a b\nc\n", `{"lines":2,"words":3,"chars":6}`},
+		{`cat`, `{"a":1}`, `{"a":1}`},
+		{`echo hi`, "xyz", `hi`},
+		{`cat a.txt`, "xyz", `from a`},
+		{`echo hi | wc`, "xyz", `{"lines":1,"words":1,"chars":3}`},
+	}
+	for _, tt := range tests {
+		sh := newTestShell(t, map[string]string{"a.txt": "from a\n"})
+		sh.pipedIn = strings.NewReader(tt.in)
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s with %q piped in: got %s, want %s", tt.line, tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestPipedInputNotWaited checks that commands that don't read the data
+// piped to netxp -c finish while its producer is still running
+func TestPipedInputNotWaited(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	sh := newTestShell(t, map[string]string{"a.txt": "from a\n"})
+	sh.pipedIn = r
+	for _, line := range []string{`echo hi`, `pwd | get pwd`, `cat a.txt`, `ls | get name`} {
+		runOK(t, sh, line)
+	}
+}
@@ -0,0 +1,431 @@
+package cli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"netxp/builtins"
+	"netxp/config"
+	"netxp/values"
+)
+
+// Datasets are pipeline results kept on disk in the workspace:
+//
+//	<pipeline> | save [-z] name   store the input under name
+//	load name | <pipeline>        feed a stored result into a pipeline
+//	datasets [name]               list datasets, or show one
+//	datasets rm name...           remove datasets
+//	datasets prune [--older-than 30d] [--keep N] [-n]
+//
+// Each dataset is its JSON encoding, gzipped when it is big, next to a
+// small file of metadata.
+
+const (
+	// compressAbove is the size from which saved data is gzipped
+	compressAbove = 1 << 20
+	saveUsage     = "usage: <pipeline> | save [-z] <name>"
+	pruneUsage    = "usage: datasets prune [--older-than <duration>] [--keep <n>] [-n]"
+)
+
+var datasetName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// datasetMeta describes a saved dataset
+type datasetMeta struct {
+	Name       string    `json:"name"`
+	Created    time.Time `json:"created"`
+	Source     string    `json:"source"`
+	Kind       string    `json:"kind"`
+	Rows       int       `json:"rows"`
+	Size       int64     `json:"size"` // of the JSON before compression
+	Compressed bool      `json:"compressed"`
+	// Types holds the columns of filesizes, durations and datetimes,
+	// which JSON keeps as plain numbers and text
+	Types map[string]string `json:"types,omitempty"`
+}
+
+// datasetsDir returns the directory of the datasets of the workspace
+func (s *Shell) datasetsDir() string {
+	ws := s.cfg.Workspace
+	if ws == "" {
+		ws = "default"
+	}
+	return filepath.Join(config.WorkspacesDir(), ws, "datasets")
+}
+
+// dataFile returns the path of the data of a dataset
+func (s *Shell) dataFile(m *datasetMeta) string {
+	name := m.Name + ".json"
+	if m.Compressed {
+		name += ".gz"
+	}
+	return filepath.Join(s.datasetsDir(), name)
+}
+
+func (s *Shell) metaFile(name string) string {
+	return filepath.Join(s.datasetsDir(), name+".meta.json")
+}
+
+// datasetCommand implements save, load and datasets. source is the text
+// of the stages before the command.
+func (s *Shell) datasetCommand(ctx context.Context, cmdName string, args []string, source string, sio *stageIO) error {
+	var out values.Value
+	var e *builtins.ExecutionError
+	switch cmdName {
+	case "save":
+		out, e = s.saveDataset(cmdName, args, source, sio)
+	case "load":
+		out, e = s.loadDataset(cmdName, args)
+	default:
+		out, e = s.datasetsCommand(cmdName, args)
+	}
+	if e != nil {
+		return s.fail(ctx, sio, e)
+	}
+	return sio.emit(ctx, out)
+}
+
+// saveDataset stores the input of the stage and returns its listing
+func (s *Shell) saveDataset(cmdName string, args []string, source string, sio *stageIO) (values.Value, *builtins.ExecutionError) {
+	compress := false
+	var name string
+	for _, arg := range args {
+		switch {
+		case arg == "-z" || arg == "--compress":
+			compress = true
+		case strings.HasPrefix(arg, "-"):
+			return nil, builtins.NewError(cmdName, 2, fmt.Sprintf("unknown option '%s'", arg), []string{saveUsage})
+		case name != "":
+			return nil, builtins.NewError(cmdName, 2, fmt.Sprintf("unexpected argument '%s'", arg), []string{saveUsage})
+		default:
+			name = arg
+		}
+	}
+	if name == "" {
+		return nil, builtins.NewError(cmdName, 2, "missing dataset name", []string{saveUsage})
+	}
+	if !datasetName.MatchString(name) {
+		return nil, builtins.NewError(cmdName, 2, fmt.Sprintf("invalid dataset name '%s'", name), []string{"names are letters, digits, '_' and '-'"})
+	}
+	if !sio.hasInput() {
+		return nil, builtins.NewError(cmdName, 2, "nothing to save", []string{"save goes at the end of a pipeline, e.g. run:scan | save scan"})
+	}
+	v, err := sio.input()
+	if err != nil {
+		return nil, builtins.NewError(cmdName, 1, err.Error(), nil)
+	}
+	if _, failed := v.(*builtins.ExecutionError); failed {
+		return nil, builtins.NewError(cmdName, 1, "the pipeline failed; nothing was saved", nil)
+	}
+
+	data := values.Encode(v)
+	m := &datasetMeta{
+		Name:       name,
+		Created:    time.Now().Truncate(time.Second),
+		Source:     source,
+		Kind:       v.Kind().String(),
+		Rows:       len(values.Items(v)),
+		Size:       int64(len(data)),
+		Compressed: compress || len(data) > compressAbove,
+		Types:      unitColumns(v),
+	}
+	if m.Compressed {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write(data)
+		zw.Close()
+		data = b.Bytes()
+	}
+	if err := os.MkdirAll(s.datasetsDir(), 0755); err != nil {
+		return nil, builtins.NewError(cmdName, 1, err.Error(), nil)
+	}
+	meta, _ := json.MarshalIndent(m, "", "  ")
+	if err := writeFile(s.dataFile(m), data); err != nil {
+		return nil, builtins.NewError(cmdName, 1, err.Error(), nil)
+	}
+	if err := writeFile(s.metaFile(name), meta); err != nil {
+		return nil, builtins.NewError(cmdName, 1, err.Error(), nil)
+	}
+	// a dataset saved again may change between plain and compressed
+	other := *m
+	other.Compressed = !m.Compressed
+	os.Remove(s.dataFile(&other))
+	return s.datasetRow(m), nil
+}
+
+// writeFile replaces a file by renaming a complete copy over it, so an
+// interrupted save leaves the old version in place
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readMeta returns the metadata of a dataset
+func (s *Shell) readMeta(cmdName, name string) (*datasetMeta, *builtins.ExecutionError) {
+	b, err := ioutil.ReadFile(s.metaFile(name))
+	if os.IsNotExist(err) {
+		return nil, builtins.NewError(cmdName, 1, fmt.Sprintf("no such dataset: %s", name), []string{"list datasets with: datasets"})
+	}
+	m := &datasetMeta{}
+	if err == nil {
+		err = json.Unmarshal(b, m)
+	}
+	if err != nil {
+		return nil, builtins.NewError(cmdName, 1, fmt.Sprintf("dataset %s: %s", name, err), nil)
+	}
+	return m, nil
+}
+
+// loadDataset returns the value of a dataset
+func (s *Shell) loadDataset(cmdName string, args []string) (values.Value, *builtins.ExecutionError) {
+	if len(args) != 1 {
+		return nil, builtins.NewError(cmdName, 2, "usage: load <name>", []string{"list datasets with: datasets"})
+	}
+	m, e := s.readMeta(cmdName, args[0])
+	if e != nil {
+		return nil, e
+	}
+	data, err := ioutil.ReadFile(s.dataFile(m))
+	if err == nil && m.Compressed {
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			data, err = ioutil.ReadAll(zr)
+		}
+	}
+	if err != nil {
+		return nil, builtins.NewError(cmdName, 1, fmt.Sprintf("dataset %s: %s", m.Name, err), []string{"remove it with: datasets rm " + m.Name})
+	}
+	v := values.Decode(data)
+	restoreUnits(v, m.Types)
+	return v, nil
+}
+
+// datasetsCommand implements "datasets [name]", "datasets rm" and
+// "datasets prune"
+func (s *Shell) datasetsCommand(cmdName string, args []string) (values.Value, *builtins.ExecutionError) {
+	switch {
+	case len(args) == 0:
+		metas, e := s.listDatasets(cmdName)
+		if e != nil {
+			return nil, e
+		}
+		rows := make([]*values.Record, 0, len(metas))
+		for _, m := range metas {
+			rows = append(rows, s.datasetRow(m))
+		}
+		return values.NewTable(rows), nil
+	case args[0] == "rm":
+		if len(args) == 1 {
+			return nil, builtins.NewError(cmdName, 2, "usage: datasets rm <name>...", nil)
+		}
+		var metas []*datasetMeta
+		for _, name := range args[1:] {
+			m, e := s.readMeta(cmdName, name)
+			if e != nil {
+				return nil, e
+			}
+			metas = append(metas, m)
+		}
+		return s.removeDatasets(cmdName, metas, false)
+	case args[0] == "prune":
+		return s.pruneDatasets(cmdName, args[1:])
+	case len(args) == 1:
+		m, e := s.readMeta(cmdName, args[0])
+		if e != nil {
+			return nil, e
+		}
+		return s.datasetRow(m), nil
+	}
+	return nil, builtins.NewError(cmdName, 2, "usage: datasets [name], datasets rm <name>... or datasets prune", nil)
+}
+
+// listDatasets returns the metadata of every dataset, by name
+func (s *Shell) listDatasets(cmdName string) ([]*datasetMeta, *builtins.ExecutionError) {
+	files, err := filepath.Glob(filepath.Join(s.datasetsDir(), "*.meta.json"))
+	if err != nil {
+		return nil, builtins.NewError(cmdName, 1, err.Error(), nil)
+	}
+	sort.Strings(files)
+	var metas []*datasetMeta
+	for _, f := range files {
+		m, e := s.readMeta(cmdName, strings.TrimSuffix(filepath.Base(f), ".meta.json"))
+		if e != nil {
+			return nil, e
+		}
+		metas = append(metas, m)
+	}
+	return metas, nil
+}
+
+// pruneDatasets removes the datasets beyond the newest --keep and older
+// than --older-than; with both, a dataset must be both to go
+func (s *Shell) pruneDatasets(cmdName string, args []string) (values.Value, *builtins.ExecutionError) {
+	keep, dryRun := -1, false
+	var maxAge time.Duration
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--older-than":
+			var d values.Duration
+			err := fmt.Errorf("missing duration")
+			if i+1 < len(args) {
+				d, err = values.ParseDuration(args[i+1])
+				i++
+			}
+			if err != nil || d <= 0 {
+				return nil, builtins.NewError(cmdName, 2, "--older-than needs a duration such as 30d", []string{pruneUsage})
+			}
+			maxAge = time.Duration(d)
+		case "--keep":
+			keep = -1
+			if i+1 < len(args) {
+				keep, _ = strconv.Atoi(args[i+1])
+				i++
+			}
+			if keep < 0 {
+				return nil, builtins.NewError(cmdName, 2, "--keep needs a number of datasets", []string{pruneUsage})
+			}
+		case "-n", "--dry-run":
+			dryRun = true
+		default:
+			return nil, builtins.NewError(cmdName, 2, fmt.Sprintf("unknown option '%s'", args[i]), []string{pruneUsage})
+		}
+	}
+	if keep < 0 && maxAge == 0 {
+		return nil, builtins.NewError(cmdName, 2, "prune needs --older-than or --keep", []string{pruneUsage})
+	}
+	metas, e := s.listDatasets(cmdName)
+	if e != nil {
+		return nil, e
+	}
+	sort.SliceStable(metas, func(i, j int) bool { return metas[i].Created.After(metas[j].Created) })
+	var doomed []*datasetMeta
+	for i, m := range metas {
+		if keep >= 0 && i < keep {
+			continue
+		}
+		if maxAge > 0 && time.Since(m.Created) <= maxAge {
+			continue
+		}
+		doomed = append(doomed, m)
+	}
+	return s.removeDatasets(cmdName, doomed, dryRun)
+}
+
+// removeDatasets deletes datasets and returns their listing
+func (s *Shell) removeDatasets(cmdName string, metas []*datasetMeta, dryRun bool) (values.Value, *builtins.ExecutionError) {
+	rows := make([]*values.Record, 0, len(metas))
+	for _, m := range metas {
+		row := s.datasetRow(m)
+		if !dryRun {
+			if err := os.Remove(s.dataFile(m)); err != nil && !os.IsNotExist(err) {
+				return nil, builtins.NewError(cmdName, 1, err.Error(), nil)
+			}
+			if err := os.Remove(s.metaFile(m.Name)); err != nil {
+				return nil, builtins.NewError(cmdName, 1, err.Error(), nil)
+			}
+		}
+		row.Set("removed", values.Bool(!dryRun))
+		rows = append(rows, row)
+	}
+	return values.NewTable(rows), nil
+}
+
+// datasetRow is the listing of a dataset
+func (s *Shell) datasetRow(m *datasetMeta) *values.Record {
+	var stored values.Value = values.Nothing{}
+	if fi, err := os.Stat(s.dataFile(m)); err == nil {
+		stored = values.Filesize(fi.Size())
+	}
+	return values.NewRecord(
+		"name", m.Name,
+		"kind", m.Kind,
+		"rows", m.Rows,
+		"size", values.Filesize(m.Size),
+		"stored", stored,
+		"compressed", m.Compressed,
+		"created", values.Datetime(m.Created),
+		"source", m.Source,
+	)
+}
+
+// unitColumns finds the fields of the records of a value that hold only
+// filesizes, durations or datetimes
+func unitColumns(v values.Value) map[string]string {
+	types := make(map[string]string)
+	mixed := make(map[string]bool)
+	for _, item := range values.Items(v) {
+		r, ok := item.(*values.Record)
+		if !ok {
+			continue
+		}
+		for _, k := range r.Keys() {
+			f, _ := r.Get(k)
+			kind := ""
+			switch f.(type) {
+			case values.Nothing:
+				continue
+			case values.Filesize, values.Duration, values.Datetime:
+				kind = f.Kind().String()
+			}
+			if t, seen := types[k]; kind == "" || seen && t != kind {
+				mixed[k] = true
+			}
+			types[k] = kind
+		}
+	}
+	for k := range types {
+		if mixed[k] {
+			delete(types, k)
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	return types
+}
+
+// restoreUnits turns the fields unitColumns found back into their types
+func restoreUnits(v values.Value, types map[string]string) {
+	if len(types) == 0 {
+		return
+	}
+	for _, item := range values.Items(v) {
+		r, ok := item.(*values.Record)
+		if !ok {
+			continue
+		}
+		for k, kind := range types {
+			f, ok := r.Get(k)
+			if !ok {
+				continue
+			}
+			switch kind {
+			case "filesize":
+				if n, ok := f.(values.Number); ok {
+					r.Set(k, values.Filesize(n))
+				}
+			case "duration":
+				if d, err := values.ParseDuration(values.Text(f)); err == nil && f.Kind() == values.KindString {
+					r.Set(k, d)
+				}
+			case "datetime":
+				if t, err := values.ParseDatetime(values.Text(f)); err == nil && f.Kind() == values.KindString {
+					r.Set(k, t)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDatasets checks that datasets keep their values and units, and
+// how they are listed and removed
+func TestDatasets(t *testing.T) {
+	files := map[string]string{"a.txt": "aaaa", "b.txt": "b"}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`ls | save files | get rows source`, `{"rows":2,"source":"ls"}`},
+		{`load files | where size > 2b | get name`, `["a.txt"]`},
+		{`load files | insert t = type(size) | get t`, `["filesize","filesize"]`},
+		{`echo "[1,2,3]" | from-json | save -z nums | get compressed`, `true`},
+		{`load nums | math sum`, `6`},
+		{`datasets | get name`, `["files","nums"]`},
+		{`datasets nums | get kind rows`, `{"kind":"list","rows":3}`},
+		{`datasets prune --older-than 1d | get name`, `[]`},
+		{`datasets prune --keep 0 -n | get removed`, `[false,false]`},
+		{`datasets | get name`, `["files","nums"]`},
+		{`datasets rm nums | get removed`, `[true]`},
+		{`datasets | get name`, `["files"]`},
+	}
+	sh := newTestShell(t, files)
+	for _, tt := range tests {
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.line, got, tt.want)
+		}
+	}
+	if _, err := run(t, sh, `load nums`); err == nil {
+		t.Errorf("load nums: succeeded after datasets rm")
+	}
+	// datasets live in the workspace under the config directory
+	matches, _ := filepath.Glob(filepath.Join(os.Getenv("HOME"), ".netxp", "workspaces", "*", "*", "files*"))
+	if len(matches) == 0 {
+		t.Errorf("no files dataset under $HOME/.netxp")
+	}
+}
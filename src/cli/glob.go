@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"netxp/utils"
+)
+
+// expandBraces expands the first {a,b} or {1..3} group found in an unquoted
+// literal part and recurses on the results, so nested and repeated groups
+// all expand. Words without a complete group are returned unchanged.
+func expandBraces(parts []utils.WordPart) [][]utils.WordPart {
+	for i, p := range parts {
+		if p.Var || p.Subst || p.Quoted {
+			continue
+		}
+		open, close, alts := braceGroup(p.Text)
+		if open < 0 {
+			continue
+		}
+		var out [][]utils.WordPart
+		for _, alt := range alts {
+			expanded := make([]utils.WordPart, 0, len(parts))
+			expanded = append(expanded, parts[:i]...)
+			q := p
+			q.Text = p.Text[:open] + alt + p.Text[close+1:]
+			expanded = append(expanded, q)
+			expanded = append(expanded, parts[i+1:]...)
+			out = append(out, expandBraces(expanded)...)
+		}
+		return out
+	}
+	return [][]utils.WordPart{parts}
+}
+
+// braceGroup finds the first brace group in s and returns its bounds and
+// alternatives, or open == -1 when there is none
+func braceGroup(s string) (int, int, []string) {
+	for open := 0; open < len(s); open++ {
+		if s[open] != '{' {
+			continue
+		}
+		depth := 0
+		var commas []int
+		for i := open; i < len(s); i++ {
+			switch s[i] {
+			case '{':
+				depth++
+			case ',':
+				if depth == 1 {
+					commas = append(commas, i)
+				}
+			case '}':
+				depth--
+			}
+			if depth > 0 {
+				continue
+			}
+			body := s[open+1 : i]
+			if len(commas) > 0 {
+				var alts []string
+				start := open + 1
+				for _, c := range commas {
+					alts = append(alts, s[start:c])
+					start = c + 1
+				}
+				return open, i, append(alts, s[start:i])
+			}
+			if alts := braceRange(body); alts != nil {
+				return open, i, alts
+			}
+			break
+		}
+	}
+	return -1, -1, nil
+}
+
+// braceRange expands the body of {1..5} or {a..e}
+func braceRange(body string) []string {
+	bounds := strings.Split(body, "..")
+	if len(bounds) != 2 {
+		return nil
+	}
+	from, errFrom := strconv.Atoi(bounds[0])
+	to, errTo := strconv.Atoi(bounds[1])
+	if errFrom != nil || errTo != nil {
+		if len(bounds[0]) != 1 || len(bounds[1]) != 1 {
+			return nil
+		}
+		from, to = int(bounds[0][0]), int(bounds[1][0])
+	}
+	step := 1
+	if to < from {
+		step = -1
+	}
+	var out []string
+	for i := from; ; i += step {
+		if errFrom != nil || errTo != nil {
+			out = append(out, string(rune(i)))
+		} else {
+			out = append(out, strconv.Itoa(i))
+		}
+		if i == to {
+			return out
+		}
+	}
+}
+
+// expandTilde replaces a leading unquoted ~ or ~user with a home directory
+func expandTilde(parts []utils.WordPart) []utils.WordPart {
+	if len(parts) == 0 || parts[0].Var || parts[0].Subst || parts[0].Quoted || !strings.HasPrefix(parts[0].Text, "~") {
+		return parts
+	}
+	text := parts[0].Text
+	name := text[1:]
+	rest := ""
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name, rest = name[:i], name[i:]
+	} else if len(parts) > 1 {
+		// ~ followed by something other than a path, e.g. ~"x"
+		return parts
+	}
+	var home string
+	if name == "" {
+		home, _ = os.UserHomeDir()
+	} else if u, err := user.Lookup(name); err == nil {
+		home = u.HomeDir
+	}
+	if home == "" {
+		return parts
+	}
+	out := append([]utils.WordPart{}, parts...)
+	// the home directory is taken literally, never as a pattern
+	out[0] = utils.WordPart{Text: home, Quoted: true}
+	if rest != "" {
+		out = append(out[:1], append([]utils.WordPart{{Text: rest}}, out[1:]...)...)
+	}
+	return out
+}
+
+// hasMeta reports whether a pattern has an unescaped glob metacharacter
+func hasMeta(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// escapeMeta quotes glob metacharacters so they only match themselves
+func escapeMeta(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// unescapeMeta removes the escapes added by escapeMeta
+func unescapeMeta(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// glob expands a pattern in which "**" matches any number of directories
+// and a trailing "/" matches only directories. Names starting with '.'
+// are only matched by a pattern that starts with '.'. Matches are sorted.
+func glob(pattern string) []string {
+	base := ""
+	if strings.HasPrefix(pattern, "/") {
+		base = "/"
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+	dirOnly := strings.HasSuffix(pattern, "/")
+	segs := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	seen := make(map[string]bool)
+	var out []string
+	globWalk(base, segs, func(path string) {
+		if path == "" {
+			return
+		}
+		if dirOnly {
+			if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+				return
+			}
+			path += "/"
+		}
+		if !seen[path] {
+			seen[path] = true
+			out = append(out, path)
+		}
+	})
+	sort.Strings(out)
+	return out
+}
+
+// globWalk matches segs below base and calls found for every match
+func globWalk(base string, segs []string, found func(string)) {
+	if len(segs) == 0 {
+		found(base)
+		return
+	}
+	seg, rest := segs[0], segs[1:]
+	dir := base
+	if dir == "" {
+		dir = "."
+	}
+	if seg == "**" {
+		globWalk(base, rest, found)
+		for _, sub := range readDir(dir) {
+			if sub.IsDir() && !strings.HasPrefix(sub.Name(), ".") {
+				globWalk(joinPath(base, sub.Name()), segs, found)
+			}
+		}
+		return
+	}
+	if seg == "" {
+		globWalk(base, rest, found)
+		return
+	}
+	if !hasMeta(seg) {
+		path := joinPath(base, unescapeMeta(seg))
+		if _, err := os.Lstat(path); err == nil {
+			globWalk(path, rest, found)
+		}
+		return
+	}
+	for _, entry := range readDir(dir) {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(seg, ".") {
+			continue
+		}
+		if ok, _ := filepath.Match(seg, name); !ok {
+			continue
+		}
+		path := joinPath(base, name)
+		if len(rest) > 0 {
+			if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+				continue
+			}
+		}
+		globWalk(path, rest, found)
+	}
+}
+
+func readDir(dir string) []os.DirEntry {
+	entries, _ := os.ReadDir(dir)
+	return entries
+}
+
+func joinPath(base, name string) string {
+	if base == "" {
+		return name
+	}
+	if strings.HasSuffix(base, "/") {
+		return base + name
+	}
+	return base + "/" + name
+}
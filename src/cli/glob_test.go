@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpansion(t *testing.T) {
+	files := map[string]string{
+		"a.log": "", "b.log": "", "notes.txt": "",
+		"src/main.go": "", "src/lib/util.go": "", "src/lib/README": "",
+	}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`echo {a,b}.txt`, `a.txt b.txt`},
+		{`echo x{1..3}`, `x1 x2 x3`},
+		{`echo {3..1}`, `3 2 1`},
+		{`echo a{b,c{d,e}}f`, `abf acdf acef`},
+		{`echo {a}`, `{a}`},
+		{`echo *.log`, `a.log b.log`},
+		{`echo src/**/*.go`, `src/lib/util.go src/main.go`},
+		{`echo src/*/`, `src/lib/`},
+		{`echo *.{log,txt}`, `a.log b.log notes.txt`},
+		{`echo *.none`, `*.none`},
+		{`echo '*.log' "*.log" \*.log`, `*.log *.log *.log`},
+		{`echo '{a,b}'`, `{a,b}`},
+		{`echo $(echo "{x,y}")`, `{x,y}`},
+		{`echo "~"`, `~`},
+	}
+	sh := newTestShell(t, files)
+	tests = append(tests, struct{ line, want string }{`echo ~/x`, os.Getenv("HOME") + `/x`})
+	for _, tt := range tests {
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestGlobArguments checks that every path a pattern matches reaches the
+// command, not only the first
+func TestGlobArguments(t *testing.T) {
+	sh := newTestShell(t, map[string]string{"a.log": "1\n", "b.log": "2\n", "c.log": "3\n", "keep.txt": ""})
+	if got, want := runOK(t, sh, `cat *.log`), `1
+2
+3`; got != want {
+		t.Errorf("cat *.log: got %q, want %q", got, want)
+	}
+	if got, want := runOK(t, sh, `rm *.log | get removed`), `["a.log","b.log","c.log"]`; got != want {
+		t.Errorf("rm *.log removed %s, want %s", got, want)
+	}
+	if got, want := runOK(t, sh, `ls | get name`), `["keep.txt"]`; got != want {
+		t.Errorf("after rm *.log: %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,378 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"netxp/builtins"
+	"netxp/values"
+)
+
+// maxDepth bounds nested function and script calls
+const maxDepth = 200
+
+// function is a user function defined with def
+type function struct {
+	name   string
+	params []string
+	body   []stmt
+}
+
+// funcTable holds the functions of a shell, shared by its calls
+type funcTable struct {
+	mu    sync.RWMutex
+	funcs map[string]*function
+}
+
+func newFuncTable() *funcTable {
+	return &funcTable{funcs: make(map[string]*function)}
+}
+
+func (t *funcTable) get(name string) (*function, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	fn, ok := t.funcs[name]
+	return fn, ok
+}
+
+func (t *funcTable) set(fn *function) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.funcs[fn.name] = fn
+}
+
+// returnSignal unwinds a function or script body on return; err is the
+// result of the returned pipeline
+type returnSignal struct {
+	err error
+}
+
+func (r *returnSignal) Error() string { return "return outside of a function" }
+
+var (
+	errBreak    = errors.New("break outside of a loop")
+	errContinue = errors.New("continue outside of a loop")
+)
+
+// scriptError places an error at the script line that raised it
+type scriptError struct {
+	at  position
+	err error
+}
+
+func (e *scriptError) Error() string { return fmt.Sprintf("%s: %s", e.at, e.err) }
+
+func (e *scriptError) Unwrap() error { return e.err }
+
+// raise attaches the position of st to err, once
+func raise(st stmt, err error) error {
+	var se *scriptError
+	if err == nil || st.pos().file == "" || errors.As(err, &se) {
+		return err
+	}
+	return &scriptError{at: st.pos(), err: err}
+}
+
+// runSource parses and runs script source with output sent to out. A
+// top-level return ends the script with the status of its pipeline.
+func (s *Shell) runSource(src, file string, out io.Writer) error {
+	body, err := parseScript(src, file)
+	if err != nil {
+		s.status = 2
+		return err
+	}
+	err = s.execBlock(body, out)
+	var ret *returnSignal
+	if errors.As(err, &ret) {
+		err = ret.err
+		s.status = statusOf(err)
+	}
+	return err
+}
+
+// runScript runs a .nxp file in a fresh local scope with $args bound
+func (s *Shell) runScript(path string, args []values.Value, stdin io.Reader, out io.Writer) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if s.depth >= maxDepth {
+		return fmt.Errorf("%s: maximum call depth exceeded", path)
+	}
+	locals := bindArgs(args)
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		locals["in"] = values.Decode(data)
+	}
+	child := *s
+	child.scope = s.scope.call(locals)
+	child.depth++
+	return child.runSource(string(src), path, out)
+}
+
+// source runs a .nxp file in the current scope, so its variables and
+// functions stay defined. Like a call it runs on a copy of the shell,
+// sharing scope and functions, since it can run beside other stages.
+func (s *Shell) source(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: source <file.nxp>")
+	}
+	if s.depth >= maxDepth {
+		return fmt.Errorf("%s: maximum call depth exceeded", args[0])
+	}
+	src, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	child := *s
+	child.depth++
+	return child.runSource(string(src), args[0], out)
+}
+
+// callFunction runs a user function with its parameters bound in a new
+// local scope. Piped input, if any, is available as $in and all arguments
+// as $args.
+func (s *Shell) callFunction(fn *function, args []values.Value, in values.Value, out io.Writer) error {
+	if s.depth >= maxDepth {
+		return fmt.Errorf("%s: maximum call depth exceeded", fn.name)
+	}
+	locals := bindArgs(args)
+	for i, name := range fn.params {
+		if i < len(args) {
+			locals[name] = args[i]
+		} else {
+			locals[name] = values.Nothing{}
+		}
+	}
+	if in != nil {
+		locals["in"] = in
+	}
+	child := *s
+	child.scope = s.scope.call(locals)
+	child.depth++
+	err := child.execBlock(fn.body, out)
+	var ret *returnSignal
+	if errors.As(err, &ret) {
+		return ret.err
+	}
+	if errors.Is(err, errBreak) || errors.Is(err, errContinue) {
+		return fmt.Errorf("%s: %w", fn.name, err)
+	}
+	return err
+}
+
+// bindArgs makes the locals of a call: $args with every argument and the
+// positional parameters $1 .. $9
+func bindArgs(args []values.Value) map[string]values.Value {
+	list := make(values.List, len(args))
+	locals := make(map[string]values.Value, len(args)+1)
+	for i, a := range args {
+		list[i] = a
+		if i < 9 {
+			locals[strconv.Itoa(i+1)] = list[i]
+		}
+	}
+	locals["args"] = list
+	return locals
+}
+
+// execBlock runs statements in order and returns the result of the last
+// one. Like sh it carries on after a failed command, which has reported
+// its error and left its status in $?; control signals, and any error
+// inside a try block, stop it.
+func (s *Shell) execBlock(body []stmt, out io.Writer) error {
+	var last error
+	for _, st := range body {
+		if last != nil {
+			s.reportError(last)
+		}
+		last = s.execStmt(st, out)
+		if last != nil && s.stops(last) {
+			return last
+		}
+	}
+	return last
+}
+
+// stops reports whether an error ends the block it happens in
+func (s *Shell) stops(err error) bool {
+	return isControl(err) || s.catching > 0
+}
+
+func (s *Shell) execStmt(st stmt, out io.Writer) error {
+	switch st := st.(type) {
+	case *cmdStmt:
+		if err := s.runList(st.text, out); err != nil {
+			return raise(st, err)
+		}
+	case *ifStmt:
+		for i, cond := range st.conds {
+			ok, err := s.test(st, cond, out)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return s.execBlock(st.bodies[i], out)
+			}
+		}
+		return s.execBlock(st.elseBody, out)
+	case *whileStmt:
+		var last error
+		for {
+			ok, err := s.test(st, st.cond, out)
+			if err != nil || !ok {
+				if err == nil {
+					return last
+				}
+				if last != nil {
+					s.reportError(last)
+				}
+				return err
+			}
+			if last != nil {
+				s.reportError(last)
+			}
+			last = s.execBlock(st.body, out)
+			switch {
+			case errors.Is(last, errBreak):
+				return nil
+			case errors.Is(last, errContinue):
+				last = nil
+			case last != nil && s.stops(last):
+				return last
+			}
+		}
+	case *forStmt:
+		return s.execFor(st, out)
+	case *defStmt:
+		s.funcs.set(st.fn)
+	case *tryStmt:
+		s.catching++
+		err := s.execBlock(st.body, out)
+		s.catching--
+		if err == nil || isControl(err) {
+			return err
+		}
+		if st.errName != "" {
+			s.scope.set(st.errName, errorRecord(err))
+		}
+		s.status = 0
+		return s.execBlock(st.catch, out)
+	case *returnStmt:
+		var err error
+		if st.text != "" {
+			err = s.runList(st.text, out)
+		}
+		return &returnSignal{err: raise(st, err)}
+	case *breakStmt:
+		return errBreak
+	case *continueStmt:
+		return errContinue
+	}
+	return nil
+}
+
+// test runs a condition; it holds when the pipeline exits with status 0
+func (s *Shell) test(st stmt, cond string, out io.Writer) (bool, error) {
+	err := s.runList(cond, out)
+	var se *StatusError
+	if err != nil && !errors.As(err, &se) {
+		return false, raise(st, err)
+	}
+	return err == nil, nil
+}
+
+// execFor runs the body once per record of the source pipeline, which
+// keeps running while the body does
+func (s *Shell) execFor(st *forStmt, out io.Writer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, w := io.Pipe()
+	recs := make(chan values.Value, 64)
+	sink := &recordSink{ctx: ctx, w: w, recs: recs}
+	decoded := make(chan struct{})
+	go func() {
+		if err := builtins.DecodeRecords(ctx, r, recs); err != nil {
+			r.CloseWithError(err)
+		}
+		close(decoded)
+	}()
+	producer := *s
+	srcErr := make(chan error, 1)
+	go func() {
+		err := producer.runList(st.source, sink)
+		w.Close()
+		<-decoded
+		close(recs)
+		srcErr <- err
+	}()
+
+	var last, loopErr error
+	done := true
+	for rec := range recs {
+		s.scope.set(st.name, rec)
+		if last != nil {
+			s.reportError(last)
+		}
+		last = s.execBlock(st.body, out)
+		if errors.Is(last, errContinue) {
+			last = nil
+		}
+		if last != nil && (errors.Is(last, errBreak) || s.stops(last)) {
+			if !errors.Is(last, errBreak) {
+				loopErr = last
+			}
+			last = nil
+			done = false
+			break
+		}
+	}
+	cancel()
+	r.CloseWithError(io.ErrClosedPipe)
+	for range recs {
+	}
+	err := <-srcErr
+	if !done {
+		// the source was cut short on purpose, its error does not count
+		return loopErr
+	}
+	if err == nil {
+		return last
+	}
+	if last != nil {
+		s.reportError(last)
+	}
+	return raise(st, err)
+}
+
+func isControl(err error) bool {
+	var ret *returnSignal
+	return errors.As(err, &ret) || errors.Is(err, errBreak) || errors.Is(err, errContinue)
+}
+
+// errorRecord describes a caught error for the catch variable
+func errorRecord(err error) *values.Record {
+	rec := values.NewRecord("message", err.Error(), "code", statusOf(err), "command", nil)
+	var se *StatusError
+	if errors.As(err, &se) {
+		rec.Set("command", values.String(se.Command))
+		if se.Message != "" {
+			rec.Set("message", values.String(se.Message))
+		}
+	}
+	return rec
+}
+
+// isScript reports whether a command names a .nxp script file
+func isScript(name string) bool {
+	return filepath.Ext(name) == ".nxp"
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSource checks that a sourced script defines variables and functions
+// in the current scope, also when it runs beside other stages
+func TestSource(t *testing.T) {
+	sh := newTestShell(t, map[string]string{
+		"lib.nxp": "def twice(x) {\n  echo $x$x\n}\nlet n = echo 5\n",
+	})
+	runOK(t, sh, `source lib.nxp`)
+	if got := runOK(t, sh, `twice $n`); got != "55" {
+		t.Errorf("twice $n after source: got %s, want 55", got)
+	}
+	runOK(t, sh, `alias tw = twice $1`)
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`source lib.nxp | twice 1`, "11"},
+		{`source lib.nxp | tw 1`, "11"},
+		{`tw 1 | source lib.nxp`, ""},
+	}
+	for _, tt := range tests {
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestScriptCarriesOn checks that a script goes on after a failed command
+// with its status in $?, while try stops at the first error
+func TestScriptCarriesOn(t *testing.T) {
+	sh := newTestShell(t, map[string]string{"a.txt": "a\n", "list.json": "[1, 2]"})
+	src := `cat nosuch
+echo status $?
+for x in cat list.json {
+  cat nosuch
+  echo loop $x
+}
+def f() {
+  cat nosuch
+  echo f $?
+}
+f
+try {
+  cat nosuch
+  echo not reached
+} catch e {
+  echo caught $e.code
+}
+cat a.txt`
+	out, err := run(t, sh, src)
+	if err != nil {
+		t.Fatalf("script ending in a command that succeeds: %v", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, "{") {
+			lines = append(lines, line)
+		}
+	}
+	want := "status 1|loop 1|loop 2|f 1|caught 1|a"
+	if got := strings.Join(lines, "|"); got != want {
+		t.Errorf("script printed %s, want %s", got, want)
+	}
+	if _, err := run(t, sh, "echo a\ncat nosuch"); statusOf(err) != 1 {
+		t.Errorf("script ending in a failed command: status %d, want 1", statusOf(err))
+	}
+}
+
+// TestScripts checks control flow, functions and arguments of a script
+// run as a command
+func TestScripts(t *testing.T) {
+	files := map[string]string{
+		"hosts.json": `[{"file":"a.txt"},{"file":"nosuch"}]`,
+		"a.txt":      "a",
+		"flag":       "",
+		"s.nxp": `def greet(who) {
+  return echo hello $who
+}
+for h in cat hosts.json {
+  if cat $h.file > /dev/null {
+    echo found $h.file
+  } else {
+    echo missing $h.file
+  }
+}
+while cat flag > /dev/null {
+  rm flag > /dev/null
+  echo once
+}
+greet $1
+echo $args
+`,
+	}
+	sh := newTestShell(t, files)
+	want := "found a.txt\nmissing nosuch\nonce\nhello x\nx y"
+	if got := runOK(t, sh, `s.nxp x y`); got != want {
+		t.Errorf("s.nxp x y: got %q, want %q", got, want)
+	}
+	// a function returns a value, not text
+	if got := runOK(t, sh, `def nums() { return echo "[1,2]" | from-json }; nums | math sum`); got != "3" {
+		t.Errorf("nums | math sum: got %q, want 3", got)
+	}
+}
@@ -0,0 +1,540 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"netxp/builtins"
+	"netxp/moduling"
+	"netxp/utils"
+	"netxp/values"
+)
+
+// link connects two adjacent stages: a pipe of bytes, or a channel of
+// values when the producer gives values and the consumer takes them
+type link struct {
+	r    *io.PipeReader
+	w    *io.PipeWriter
+	recs chan values.Value
+	rows bool // recs are the records of a streaming stage
+}
+
+// closeWriter signals the end of the producer's output. A producer that
+// could not run passes its error on, so the consumer does not take the
+// output it never wrote for empty output.
+func (l *link) closeWriter(err error) {
+	if l.recs != nil {
+		close(l.recs)
+		return
+	}
+	var se *StatusError
+	if err != nil && !errors.As(err, &se) {
+		l.w.CloseWithError(err)
+		return
+	}
+	l.w.Close()
+}
+
+// closeReader tells the producer that nobody reads its output any more
+func (l *link) closeReader() {
+	if l.r != nil {
+		l.r.CloseWithError(io.ErrClosedPipe)
+	}
+}
+
+// stageKind says what runs a stage
+type stageKind int
+
+const (
+	stageExternal stageKind = iota
+	stageValue              // a lone $ref
+	stagePass               // only redirections
+	stageAlias
+	stageShell   // alias and unalias
+	stageDataset // save, load and datasets
+	stageFunc
+	stageSource
+	stageScript
+	stageStream
+	stageBuiltin
+	stageModule
+)
+
+// stagePlan is a stage with its arguments expanded, its command resolved
+// and its redirections opened
+type stagePlan struct {
+	kind   stageKind
+	argv   []string
+	args   []values.Value // argv[1:] as values, for functions, aliases and scripts
+	value  values.Value   // stageValue
+	alias  string         // stageAlias
+	fn     *function      // stageFunc
+	source string         // stageDataset: the text of the stages before it
+	sio    *stageIO
+}
+
+// givesValues reports whether the stage can hand on its output as values
+func (p *stagePlan) givesValues() bool {
+	switch p.kind {
+	case stageValue, stageShell, stageDataset, stageStream, stageBuiltin:
+		return true
+	}
+	return false
+}
+
+// takesValues reports whether the stage can take its input as values
+func (p *stagePlan) takesValues() bool {
+	switch p.kind {
+	case stageFunc, stageDataset, stageStream, stageBuiltin:
+		return true
+	}
+	return false
+}
+
+// argInput are the builtins whose arguments stand in for their input, as
+// in echo hi or cat notes.txt
+var argInput = map[string]bool{"echo": true, "cat": true}
+
+// readsStdin reports whether the stage, first in a pipeline of netxp -c,
+// is given the data piped to netxp. Builtins that take no input, or whose
+// arguments stand in for it, are not, so they don't wait for a producer
+// that may never finish. Externals and modules read stdin themselves.
+func (p *stagePlan) readsStdin() bool {
+	switch p.kind {
+	case stageStream:
+		return true
+	case stageBuiltin:
+		name := p.argv[0]
+		return builtins.TakesInput(name) && !(argInput[name] && len(p.argv) > 1)
+	case stageDataset:
+		return p.argv[0] == "save"
+	}
+	return false
+}
+
+// resolve decides what runs a command: aliases come first, then shell
+// commands, functions, scripts, builtins, modules and externals
+func (s *Shell) resolve(p *stagePlan) {
+	if len(p.argv) == 0 {
+		p.kind = stagePass
+		return
+	}
+	name := p.argv[0]
+	if body, ok := s.lookupAlias(name); ok {
+		p.kind, p.alias = stageAlias, body
+		return
+	}
+	if fn, ok := s.funcs.get(name); ok {
+		p.kind, p.fn = stageFunc, fn
+		return
+	}
+	switch {
+	case name == "alias" || name == "unalias":
+		p.kind = stageShell
+	case name == "save" || name == "load" || name == "datasets":
+		p.kind = stageDataset
+	case name == "source":
+		p.kind = stageSource
+	case isScript(name):
+		p.kind = stageScript
+	case builtins.IsStream(name):
+		p.kind = stageStream
+	case builtins.IsBuiltin(name):
+		p.kind = stageBuiltin
+	case strings.HasPrefix(name, "run:"):
+		p.kind = stageModule
+	default:
+		p.kind = stageExternal
+	}
+}
+
+// valueSink collects the output of pipelines as values. Values from
+// builtins arrive as they are; bytes from other commands are decoded.
+type valueSink struct {
+	mu    sync.Mutex
+	items []values.Value
+	buf   bytes.Buffer
+	rows  bool // the items are the records of a streaming stage
+}
+
+func (c *valueSink) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *valueSink) add(v values.Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush()
+	c.items = append(c.items, v)
+}
+
+func (c *valueSink) flush() {
+	if c.buf.Len() > 0 {
+		c.items = append(c.items, values.Decode(c.buf.Bytes()))
+		c.buf.Reset()
+	}
+}
+
+// value returns everything collected so far as one value
+func (c *valueSink) value() values.Value {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush()
+	if c.rows {
+		return values.CollectRows(c.items)
+	}
+	return values.Collect(c.items)
+}
+
+// recordSink streams the output of pipelines, such as the source of a
+// for loop, as records. Values keep their type and are split into their
+// items; text is written to w and decoded as it arrives.
+type recordSink struct {
+	ctx  context.Context
+	w    *io.PipeWriter
+	recs chan<- values.Value
+}
+
+func (r *recordSink) Write(p []byte) (int, error) {
+	return r.w.Write(p)
+}
+
+// runStages runs the stages of a pipeline concurrently, writing the output
+// of the last one to out. When a stage finishes, every stage feeding it is
+// cancelled and external processes among them are killed.
+func (s *Shell) runStages(stages []utils.Command, out io.Writer) error {
+	plans, err := s.planStages(stages)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, p := range plans {
+			p.sio.close()
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// each stage's context is a child of the next one, so cancelling a
+	// stage also cancels everything upstream of it
+	n := len(plans)
+	ctxs := make([]context.Context, n)
+	cancels := make([]context.CancelFunc, n)
+	for i := n - 1; i >= 0; i-- {
+		parent := ctx
+		if i < n-1 {
+			parent = ctxs[i+1]
+		}
+		ctxs[i], cancels[i] = context.WithCancel(parent)
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	links := make([]*link, n-1)
+	for i := range links {
+		if plans[i].givesValues() && plans[i+1].takesValues() && !plans[i].sio.outFile && !plans[i+1].sio.inFile {
+			links[i] = &link{recs: make(chan values.Value, 64), rows: plans[i].kind == stageStream}
+		} else {
+			r, w := io.Pipe()
+			links[i] = &link{r: r, w: w}
+		}
+	}
+	// a value or record sink takes the values of the last stage as they are
+	var tail *link
+	drained := make(chan struct{})
+	toValues := plans[n-1].givesValues() && !plans[n-1].sio.outFile
+	switch sink := out.(type) {
+	case *valueSink:
+		if toValues {
+			tail = &link{recs: make(chan values.Value, 64)}
+			sink.rows = plans[n-1].kind == stageStream
+			go func() {
+				for v := range tail.recs {
+					sink.add(v)
+				}
+				close(drained)
+			}()
+		}
+	case *recordSink:
+		if toValues {
+			tail = &link{recs: make(chan values.Value, 64)}
+			go func() {
+				if e := builtins.SplitRecords(sink.ctx, tail.recs, sink.recs); e != nil {
+					// the error value of a failed stage has nowhere else to go
+					os.Stderr.Write(values.Encode(e))
+				}
+				close(drained)
+			}()
+		}
+	}
+	if tail == nil {
+		close(drained)
+	}
+	for i, p := range plans {
+		var in, next *link
+		if i > 0 {
+			in = links[i-1]
+		}
+		if i < n-1 {
+			next = links[i]
+		} else {
+			next = tail
+		}
+		p.sio.connect(in, next, out)
+		if i == 0 && !p.sio.inFile {
+			switch {
+			case s.stdin != nil:
+				// the first stage of an alias reads the input of its caller
+				p.sio.stdin = s.stdin
+			case s.pipedIn != nil && p.readsStdin():
+				p.sio.stdin = s.pipedIn
+			}
+		}
+	}
+
+	errs := make([]error, n)
+	cut := make([]bool, n)
+	var wg sync.WaitGroup
+	for i := range plans {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.runStage(ctxs[i], plans[i])
+			cut[i] = ctxs[i].Err() != nil
+			errs[i] = err
+			if i < n-1 {
+				links[i].closeWriter(err)
+			} else if tail != nil {
+				tail.closeWriter(err)
+			}
+			if i > 0 {
+				// stop upstream first so its write failures count as cancellation
+				cancels[i-1]()
+				links[i-1].closeReader()
+			}
+		}(i)
+	}
+	wg.Wait()
+	<-drained
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr)
+		return &StatusError{Command: stages[n-1].Raw(), Code: 130}
+	}
+	// like sh, only the last stage decides the status of a pipeline, but
+	// upstream stages that could not run at all are still reported
+	for i := 0; i < n-1; i++ {
+		var se *StatusError
+		if errs[i] != nil && !cut[i] && !errors.As(errs[i], &se) {
+			return errs[i]
+		}
+	}
+	return errs[n-1]
+}
+
+// planStages expands the arguments and opens the redirections of every
+// stage before anything runs
+func (s *Shell) planStages(stages []utils.Command) ([]*stagePlan, error) {
+	plans := make([]*stagePlan, 0, len(stages))
+	fail := func(err error) ([]*stagePlan, error) {
+		for _, p := range plans {
+			p.sio.close()
+		}
+		return nil, err
+	}
+	for i, stage := range stages {
+		p := &stagePlan{}
+		if ref, ok := valueStage(stage); ok {
+			v, err := s.lookupVar(ref)
+			if err != nil {
+				return fail(err)
+			}
+			p.kind, p.value = stageValue, v
+		} else {
+			argv, vals, err := s.expandArgv(stage.Words)
+			if err != nil {
+				return fail(err)
+			}
+			p.argv = argv
+			if len(vals) > 0 {
+				p.args = vals[1:]
+			}
+			s.resolve(p)
+			if p.kind == stageDataset {
+				p.source = pipelineText(stages[:i])
+			}
+		}
+		sio, err := s.openRedirects(stage)
+		if err != nil {
+			return fail(err)
+		}
+		p.sio = sio
+		plans = append(plans, p)
+	}
+	return plans, nil
+}
+
+// pipelineText rebuilds the text of a pipeline from its stages
+func pipelineText(stages []utils.Command) string {
+	texts := make([]string, len(stages))
+	for i, c := range stages {
+		texts[i] = c.Raw()
+	}
+	return strings.Join(texts, " | ")
+}
+
+// runStage runs a single builtin, module or external command
+func (s *Shell) runStage(ctx context.Context, p *stagePlan) error {
+	sio := p.sio
+	switch p.kind {
+	case stageValue:
+		// $name on its own feeds a stored value into the pipeline
+		return sio.emit(ctx, p.value)
+	case stagePass:
+		// a stage made only of redirections passes its input through
+		if sio.stdin == nil {
+			return nil
+		}
+		_, err := io.Copy(sio.stdout, sio.stdin)
+		return err
+	}
+	cmdName, args := p.argv[0], p.argv[1:]
+
+	switch p.kind {
+	case stageAlias:
+		return s.callAlias(cmdName, p.alias, p.args, sio)
+	case stageShell:
+		return s.aliasCommand(ctx, cmdName, args, sio)
+	case stageDataset:
+		return s.datasetCommand(ctx, cmdName, args, p.source, sio)
+	case stageFunc:
+		var in values.Value
+		if sio.hasInput() {
+			v, err := sio.input()
+			if err != nil {
+				return err
+			}
+			in = v
+		}
+		return s.callFunction(p.fn, p.args, in, sio.stdout)
+	case stageSource:
+		return s.source(args, sio.stdout)
+	case stageScript:
+		return s.runScript(cmdName, p.args, sio.stdin, sio.stdout)
+
+	case stageStream:
+		err := builtins.RunStream(ctx, cmdName, args, builtins.Ports{
+			Reader: sio.stdin,
+			In:     sio.recsIn,
+			Writer: sio.stdout,
+			Out:    sio.recsOut,
+		})
+		var e *builtins.ExecutionError
+		if errors.As(err, &e) {
+			return s.failBuiltin(ctx, sio, cmdName, e)
+		}
+		return err
+
+	case stageBuiltin:
+		var input values.Value = values.Nothing{}
+		if builtins.TakesInput(cmdName) {
+			v, err := sio.input()
+			if err != nil {
+				return err
+			}
+			input = v
+		}
+		var display io.Writer
+		if sio.recsOut == nil {
+			display = sio.stdout
+			if f, ok := display.(*formatWriter); ok {
+				display = f.w
+			}
+		}
+		out, err := builtins.Execute(cmdName, args, input, display)
+		if err != nil {
+			if !sio.errToFile {
+				return err
+			}
+			fmt.Fprintf(sio.stderr, "%s: %s\n", cmdName, err)
+			return &StatusError{Command: cmdName, Code: 1}
+		}
+		if e, isErr := out.(*builtins.ExecutionError); isErr {
+			return s.failBuiltin(ctx, sio, cmdName, e)
+		}
+		return sio.emit(ctx, out)
+	}
+
+	stdin := sio.stdin
+	if stdin == nil {
+		// a stage without piped or redirected input reads the terminal
+		stdin = os.Stdin
+	}
+
+	// Module command
+	if p.kind == stageModule {
+		modName := strings.TrimPrefix(cmdName, "run:")
+		err := moduling.ExecContext(ctx, s.cfg, modName, args, stdin, sio.stdout, sio.stderr)
+		return exitStatus(cmdName, err)
+	}
+
+	// External command
+	cmd := exec.CommandContext(ctx, cmdName, args...)
+	cmd.Stdout = sio.stdout
+	cmd.Stderr = sio.stderr
+	cmd.Stdin = stdin
+	cmd.WaitDelay = 100 * time.Millisecond
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+	if err := exitStatus(cmdName, err); err != nil {
+		var se *StatusError
+		if errors.As(err, &se) {
+			return err
+		}
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
+
+// fail reports an error of a command implemented by the shell itself
+func (s *Shell) fail(ctx context.Context, sio *stageIO, e *builtins.ExecutionError) error {
+	return s.failBuiltin(ctx, sio, e.Command, e)
+}
+
+// failBuiltin hands a builtin error down the pipeline, or to stderr when
+// that was redirected, and turns it into a status. Inside a try block the
+// report is left to the catch block.
+func (s *Shell) failBuiltin(ctx context.Context, sio *stageIO, cmdName string, e *builtins.ExecutionError) error {
+	var err error
+	switch {
+	case sio.errToFile:
+		_, err = sio.stderr.Write(values.Encode(e))
+	case s.catching == 0:
+		err = sio.emit(ctx, e)
+	}
+	if err != nil {
+		return err
+	}
+	code := e.Code
+	if code == 0 {
+		code = 1
+	}
+	return &StatusError{Command: cmdName, Code: code, Message: e.Message}
+}
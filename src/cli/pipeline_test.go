@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestExpressionCommands checks that && and || after where, insert and
+// update belong to the expression, while the shell still sees pipes,
+// redirections and ';'
+func TestExpressionCommands(t *testing.T) {
+	files := map[string]string{
+		"big.log":   strings.Repeat("x", 2000),
+		"small.log": "x",
+		"big.txt":   strings.Repeat("x", 2000),
+	}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`ls | where size > 1kb && name =~ "\.log$" | get name`, `["big.log"]`},
+		{`ls | where name == "small.log" || name == "big.txt" | get name`, `["big.txt","small.log"]`},
+		{`ls | where size > 1kb && name =~ "txt" > out.json; cat out.json | get name`, `["big.txt"]`},
+		{`ls | update name = size > 1kb && isdir == false | get name`, `[true,true,false,false]`},
+	}
+	sh := newTestShell(t, files)
+	for _, tt := range tests {
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestEmptyResults checks that a stage that keeps no rows passes on an
+// empty table, which column commands still take
+func TestEmptyResults(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`ls | where size > 1tb`, `[]`},
+		{`ls | where size > 1tb | get name`, `[]`},
+		{`ls | where size > 1tb | select name`, `[]`},
+		{`ls | where size > 1tb | sort-by name | first 2 | get name`, `[]`},
+		{`echo "[]" | from-json | get name`, `[]`},
+	}
+	sh := newTestShell(t, map[string]string{"a.txt": "a"})
+	for _, tt := range tests {
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestFailedProducer checks that a stage whose producer could not run
+// fails with it, without writing output of its own
+func TestFailedProducer(t *testing.T) {
+	sh := newTestShell(t, nil)
+	for _, line := range []string{
+		`run:nosuch | first 2`,
+		`run:nosuch | where a > 1`,
+		`nosuch-command | first 2`,
+	} {
+		out, err := run(t, sh, line)
+		if err == nil {
+			t.Errorf("%s: succeeded", line)
+		}
+		if out != "" {
+			t.Errorf("%s: wrote %q", line, out)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package cli
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestEarlyStop checks that a stage that has all it needs stops the
+// producers before it, even ones that never end
+func TestEarlyStop(t *testing.T) {
+	sh := newTestShell(t, nil)
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`yes | first 1`, `y`},
+		{`yes | lines | first 2 | to-json`, `["y","y"]`},
+		{`yes | lines | skip 3 | first 1`, `y`},
+	}
+	for _, tt := range tests {
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestInterrupt checks that an interrupt stops every stage of a pipeline
+// and gives status 130
+func TestInterrupt(t *testing.T) {
+	// keep the interrupt from ending the test binary
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	sh := newTestShell(t, nil)
+	done := make(chan error, 1)
+	go func() { done <- sh.runSource(`sleep 30 | lines | first 1`, "", io.Discard) }()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			var se *StatusError
+			if !errors.As(err, &se) || se.Code != 130 {
+				t.Errorf("interrupted pipeline: got %v, want status 130", err)
+			}
+			return
+		case <-deadline:
+			t.Fatal("pipeline still running 5s after the interrupt")
+		case <-time.After(100 * time.Millisecond):
+			// until the pipeline listens for it
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+		}
+	}
+}
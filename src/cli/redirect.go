@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"netxp/builtins"
+	"netxp/utils"
+	"netxp/values"
+)
+
+// stageIO holds the streams of one pipeline stage. Between stages that
+// both speak values, such as builtins, values travel over channels
+// instead of byte streams.
+type stageIO struct {
+	stdin     io.Reader // nil when the stage has no input
+	recsIn    <-chan values.Value
+	rowsIn    bool // recsIn carries the records of a streaming stage
+	stdout    io.Writer
+	recsOut   chan<- values.Value
+	stderr    io.Writer
+	inFile    bool // stdin was redirected with <
+	outFile   bool // stdout was redirected with > or >>
+	errToFile bool // stderr was redirected with 2>, 2>> or 2>&1
+	mergeErr  bool // 2>&1 before any > or >>: stderr joins the piped output
+	files     []*os.File
+}
+
+// openRedirects opens the files named by the redirections of a stage.
+// Streams that are not redirected are left for the pipeline to connect.
+// Like in sh, redirections apply from left to right: 2>&1 sends stderr
+// where stdout goes at that point, so "> f 2>&1" writes both to f and
+// "2>&1 > f" only stdout.
+func (s *Shell) openRedirects(c utils.Command) (*stageIO, error) {
+	sio := &stageIO{stderr: os.Stderr}
+	for _, r := range c.Redirects {
+		if r.Op == "2>&1" {
+			if sio.outFile {
+				sio.stderr, sio.errToFile = sio.stdout, true
+			} else {
+				sio.mergeErr = true
+			}
+			continue
+		}
+		path, err := s.redirectTarget(r)
+		if err != nil {
+			sio.close()
+			return nil, err
+		}
+		var f *os.File
+		switch r.Op {
+		case "<":
+			f, err = os.Open(path)
+		case ">", "2>":
+			f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		case ">>", "2>>":
+			f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		}
+		if err != nil {
+			sio.close()
+			return nil, err
+		}
+		sio.files = append(sio.files, f)
+		switch r.Op {
+		case "<":
+			sio.stdin, sio.inFile = f, true
+		case ">", ">>":
+			sio.stdout, sio.outFile = f, true
+		default:
+			sio.stderr, sio.errToFile = f, true
+			sio.mergeErr = false
+		}
+	}
+	return sio, nil
+}
+
+// connect fills in the streams that were not redirected and applies a
+// 2>&1 that came before any redirection of stdout
+func (sio *stageIO) connect(in *link, out *link, final io.Writer) {
+	if !sio.inFile && in != nil {
+		if in.recs != nil {
+			sio.recsIn, sio.rowsIn = in.recs, in.rows
+		} else {
+			sio.stdin = in.r
+		}
+	}
+	var piped io.Writer
+	switch {
+	case out == nil:
+		piped = final
+	case out.recs != nil:
+		if !sio.outFile {
+			sio.recsOut = out.recs
+		}
+	default:
+		piped = out.w
+	}
+	if !sio.outFile && piped != nil {
+		sio.stdout = piped
+	}
+	if sio.mergeErr && piped != nil {
+		sio.stderr, sio.errToFile = piped, true
+	}
+}
+
+// redirectTarget expands the file operand of a redirection to a single path
+func (s *Shell) redirectTarget(r utils.Redirect) (string, error) {
+	expanded, err := s.expandWord(r.Target)
+	if err != nil {
+		return "", err
+	}
+	words := texts(expanded)
+	if len(words) != 1 || words[0] == "" {
+		return "", &utils.SyntaxError{Pos: r.Pos, Msg: fmt.Sprintf("ambiguous redirect '%s %s'", r.Op, r.Target.Raw)}
+	}
+	return words[0], nil
+}
+
+// hasInput reports whether anything is connected to the stage's input
+func (sio *stageIO) hasInput() bool {
+	return sio.stdin != nil || sio.recsIn != nil
+}
+
+// input collects the whole input of a stage as one value
+func (sio *stageIO) input() (values.Value, error) {
+	switch {
+	case sio.recsIn != nil:
+		var items []values.Value
+		for v := range sio.recsIn {
+			items = append(items, v)
+		}
+		if sio.rowsIn {
+			return values.CollectRows(items), nil
+		}
+		return values.Collect(items), nil
+	case sio.stdin != nil:
+		data, err := io.ReadAll(sio.stdin)
+		if err != nil {
+			return nil, err
+		}
+		return values.Decode(data), nil
+	}
+	return values.Nothing{}, nil
+}
+
+// emit hands a value to the next stage, serializing it only when the
+// next stage reads bytes
+func (sio *stageIO) emit(ctx context.Context, v values.Value) error {
+	if sio.recsOut != nil {
+		if !builtins.Send(ctx, sio.recsOut, v) {
+			return ctx.Err()
+		}
+		return nil
+	}
+	_, err := sio.stdout.Write(values.Encode(v))
+	return err
+}
+
+func (sio *stageIO) close() {
+	for _, f := range sio.files {
+		f.Close()
+	}
+}
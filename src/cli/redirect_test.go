@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestRedirection checks what each redirection leaves in its file and
+// that they apply from left to right
+func TestRedirection(t *testing.T) {
+	tests := []struct {
+		line string
+		out  string // part of the output, or "" for none
+		file string
+		want string // part of the file, or "" for an empty file
+	}{
+		{`echo hi > a.txt`, "", "a.txt", "hi"},
+		{`echo more >> a.txt`, "", "a.txt", "hi\nmore"},
+		{`lines < a.txt | to-json > b.json`, "", "b.json", `["hi","more"]`},
+		{`cat nosuch 2> err.txt`, "", "err.txt", "open nosuch"},
+		{`cat nosuch > both.txt 2>&1`, "", "both.txt", "open nosuch"},
+		{`cat nosuch 2>&1 > out.txt`, "open nosuch", "out.txt", ""},
+	}
+	sh := newTestShell(t, nil)
+	for _, tt := range tests {
+		out, _ := run(t, sh, tt.line)
+		if tt.out == "" && out != "" || !strings.Contains(out, tt.out) {
+			t.Errorf("%s: output %q, want %q", tt.line, out, tt.out)
+		}
+		data, err := os.ReadFile(tt.file)
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		got := strings.TrimSpace(string(data))
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: %s holds %q, want %q", tt.line, tt.file, got, tt.want)
+		}
+	}
+}
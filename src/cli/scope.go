@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"sync"
+
+	"netxp/values"
+)
+
+// scope is one level of variables: the globals or the locals of a call.
+// Calls see their own locals and the globals, never their caller's locals.
+type scope struct {
+	mu     *sync.RWMutex // shared by every scope of a shell
+	vars   map[string]values.Value
+	parent *scope
+}
+
+func newScope() *scope {
+	return &scope{mu: &sync.RWMutex{}, vars: make(map[string]values.Value)}
+}
+
+// call returns a fresh local scope on top of the globals
+func (sc *scope) call(locals map[string]values.Value) *scope {
+	root := sc
+	for root.parent != nil {
+		root = root.parent
+	}
+	return &scope{mu: sc.mu, vars: locals, parent: root}
+}
+
+// get looks a variable up from the innermost scope outwards
+func (sc *scope) get(name string) (values.Value, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	for cur := sc; cur != nil; cur = cur.parent {
+		if v, ok := cur.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// set updates the innermost scope defining name, or defines it locally
+func (sc *scope) set(name string, v values.Value) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for cur := sc; cur != nil; cur = cur.parent {
+		if _, ok := cur.vars[name]; ok {
+			cur.vars[name] = v
+			return
+		}
+	}
+	sc.vars[name] = v
+}
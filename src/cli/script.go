@@ -0,0 +1,395 @@
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"netxp/utils"
+)
+
+// Scripts (.nxp files) are command lines plus a few block statements:
+//
+//	if <pipeline> { ... } else if <pipeline> { ... } else { ... }
+//	for <name> in <pipeline> { ... }
+//	while <pipeline> { ... }
+//	def <name>(<param>, ...) { ... }
+//	try { ... } catch [<name>] { ... }
+//	return [<pipeline>], break, continue
+//
+// A condition holds when its pipeline exits with status 0. Block braces
+// are the words "{" and "}" on their own; a block statement starts a line
+// or follows another brace. Lines starting with '#' are comments.
+
+type stmt interface {
+	pos() position
+}
+
+// position locates a statement for error messages
+type position struct {
+	file string
+	line int
+}
+
+func (p position) pos() position { return p }
+
+func (p position) String() string {
+	if p.file == "" {
+		return fmt.Sprintf("line %d", p.line)
+	}
+	return fmt.Sprintf("%s:%d", p.file, p.line)
+}
+
+type cmdStmt struct {
+	position
+	text string
+}
+
+type ifStmt struct {
+	position
+	conds    []string
+	bodies   [][]stmt
+	elseBody []stmt
+}
+
+type forStmt struct {
+	position
+	name   string
+	source string
+	body   []stmt
+}
+
+type whileStmt struct {
+	position
+	cond string
+	body []stmt
+}
+
+type defStmt struct {
+	position
+	fn *function
+}
+
+type tryStmt struct {
+	position
+	body    []stmt
+	errName string
+	catch   []stmt
+}
+
+type returnStmt struct {
+	position
+	text string
+}
+
+type breakStmt struct{ position }
+
+type continueStmt struct{ position }
+
+// segment is a piece of a source line: a command, a block header ending
+// in "{" or a closing line starting with "}"
+type segment struct {
+	text   string
+	line   int
+	opens  bool // ends with a block-opening "{"
+	closes bool // starts with a block-closing "}"
+}
+
+// splitSegments cuts source into segments at standalone braces
+func splitSegments(src string) ([]segment, error) {
+	var segs []segment
+	for n, line := range strings.Split(src, "\n") {
+		lineNo := n + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		toks, err := utils.Tokenize(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		runes := []rune(line)
+		start := 0
+		closes := false
+		emit := func(end int, opens bool) {
+			if text := strings.TrimSpace(string(runes[start:end])); text != "" {
+				segs = append(segs, segment{text: text, line: lineNo, opens: opens, closes: closes})
+			}
+			start, closes = end, false
+		}
+		// skipSemi drops a ';' right after a brace
+		skipSemi := func(i int) int {
+			if i+1 < len(toks) && toks[i+1].Kind == utils.TokSemi {
+				start = toks[i+1].Pos
+				return i + 1
+			}
+			return i
+		}
+		for i := 0; i < len(toks); i++ {
+			t := toks[i]
+			if t.Kind != utils.TokWord {
+				continue
+			}
+			switch t.Raw {
+			case "{":
+				emit(t.Pos, true)
+				i = skipSemi(i)
+			case "}":
+				emit(t.Pos-1, false)
+				closes = true
+				next := i + 1
+				if next < len(toks) && (toks[next].Raw == "else" || toks[next].Raw == "catch") {
+					continue
+				}
+				emit(t.Pos, false)
+				i = skipSemi(i)
+			}
+		}
+		emit(len(runes), false)
+	}
+	return segs, nil
+}
+
+// openBlocks reports how many blocks are left open at the end of src
+func openBlocks(src string) int {
+	segs, err := splitSegments(src)
+	if err != nil {
+		return 0
+	}
+	depth := 0
+	for _, seg := range segs {
+		if seg.closes {
+			depth--
+		}
+		if seg.opens {
+			depth++
+		}
+	}
+	return depth
+}
+
+var (
+	forHeader = regexp.MustCompile(`^for\s+([A-Za-z_][A-Za-z0-9_]*)\s+in\s+(.+)\{$`)
+	defHeader = regexp.MustCompile(`^def\s+([A-Za-z_][A-Za-z0-9_-]*)\s*\(([^)]*)\)\s*\{$`)
+	catchLine = regexp.MustCompile(`^\}\s*catch(\s+[A-Za-z_][A-Za-z0-9_]*)?\s*\{$`)
+)
+
+type scriptParser struct {
+	file string
+	segs []segment
+	i    int
+}
+
+// parseScript parses script source into statements
+func parseScript(src, file string) ([]stmt, error) {
+	segs, err := splitSegments(src)
+	if err != nil {
+		if file != "" {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return nil, err
+	}
+	p := &scriptParser{file: file, segs: segs}
+	body, err := p.block(nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.segs) {
+		return nil, p.errorf(p.segs[p.i], "unexpected '}'")
+	}
+	return body, nil
+}
+
+func (p *scriptParser) errorf(seg segment, format string, args ...interface{}) error {
+	at := position{file: p.file, line: seg.line}
+	return fmt.Errorf("%s: %s", at, fmt.Sprintf(format, args...))
+}
+
+// block parses statements up to a closing "}" segment, which is left for
+// the caller. opener is the header of the enclosing block, nil at top level.
+func (p *scriptParser) block(opener *segment) ([]stmt, error) {
+	var body []stmt
+	for p.i < len(p.segs) {
+		seg := p.segs[p.i]
+		if seg.closes {
+			if opener == nil {
+				return nil, p.errorf(seg, "unexpected '}'")
+			}
+			return body, nil
+		}
+		p.i++
+		st, err := p.statement(seg)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, st)
+	}
+	if opener != nil {
+		return nil, p.errorf(*opener, "missing '}' for this block")
+	}
+	return body, nil
+}
+
+// closing consumes the "}" segment that ends a block and returns what
+// follows the brace, such as "else {"
+func (p *scriptParser) closing(opener segment) (string, error) {
+	if p.i >= len(p.segs) {
+		return "", p.errorf(opener, "missing '}' for this block")
+	}
+	seg := p.segs[p.i]
+	if !seg.closes {
+		return "", p.errorf(opener, "missing '}' for this block")
+	}
+	p.i++
+	return strings.TrimSpace(strings.TrimPrefix(seg.text, "}")), nil
+}
+
+func (p *scriptParser) statement(seg segment) (stmt, error) {
+	at := position{file: p.file, line: seg.line}
+	text := seg.text
+	word := strings.Fields(text)[0]
+	opens := seg.opens
+	switch word {
+	case "if":
+		if !opens {
+			return nil, p.errorf(seg, "expected '{' after if condition")
+		}
+		st := &ifStmt{position: at}
+		cond := text
+		for {
+			st.conds = append(st.conds, strings.TrimSpace(cond[len("if"):len(cond)-1]))
+			body, err := p.block(&seg)
+			if err != nil {
+				return nil, err
+			}
+			st.bodies = append(st.bodies, body)
+			rest, err := p.closing(seg)
+			if err != nil {
+				return nil, err
+			}
+			if rest == "" {
+				return st, nil
+			}
+			fields := strings.Fields(rest)
+			if fields[0] != "else" || !p.segs[p.i-1].opens {
+				return nil, p.errorf(seg, "unexpected '%s' after '}'", rest)
+			}
+			cond = strings.TrimSpace(strings.TrimPrefix(rest, "else"))
+			if cond == "{" {
+				st.elseBody, err = p.block(&seg)
+				if err != nil {
+					return nil, err
+				}
+				if rest, err = p.closing(seg); err != nil {
+					return nil, err
+				}
+				if rest != "" {
+					return nil, p.errorf(seg, "unexpected '%s' after '}'", rest)
+				}
+				return st, nil
+			}
+			if len(fields) < 3 || fields[1] != "if" {
+				return nil, p.errorf(seg, "expected 'else if' or 'else {'")
+			}
+		}
+	case "while":
+		if !opens {
+			return nil, p.errorf(seg, "expected '{' after while condition")
+		}
+		body, err := p.closedBlock(seg)
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{position: at, cond: strings.TrimSpace(text[len("while") : len(text)-1]), body: body}, nil
+	case "for":
+		m := forHeader.FindStringSubmatch(text)
+		if m == nil {
+			return nil, p.errorf(seg, "usage: for <name> in <pipeline> {")
+		}
+		body, err := p.closedBlock(seg)
+		if err != nil {
+			return nil, err
+		}
+		return &forStmt{position: at, name: m[1], source: strings.TrimSpace(m[2]), body: body}, nil
+	case "def":
+		m := defHeader.FindStringSubmatch(text)
+		if m == nil {
+			return nil, p.errorf(seg, "usage: def <name>(<params>) {")
+		}
+		var params []string
+		for _, param := range strings.Split(m[2], ",") {
+			if param = strings.TrimSpace(param); param == "" {
+				continue
+			}
+			if !utils.IsName(param) {
+				return nil, p.errorf(seg, "invalid parameter name '%s'", param)
+			}
+			params = append(params, param)
+		}
+		body, err := p.closedBlock(seg)
+		if err != nil {
+			return nil, err
+		}
+		return &defStmt{position: at, fn: &function{name: m[1], params: params, body: body}}, nil
+	case "try":
+		if strings.Join(strings.Fields(text), " ") != "try {" {
+			return nil, p.errorf(seg, "usage: try {")
+		}
+		st := &tryStmt{position: at}
+		body, err := p.block(&seg)
+		if err != nil {
+			return nil, err
+		}
+		st.body = body
+		if p.i >= len(p.segs) {
+			return nil, p.errorf(seg, "missing '}' for this block")
+		}
+		closer := p.segs[p.i]
+		if m := catchLine.FindStringSubmatch(closer.text); m != nil && closer.closes && closer.opens {
+			p.i++
+			st.errName = strings.TrimSpace(m[1])
+			if st.catch, err = p.closedBlock(closer); err != nil {
+				return nil, err
+			}
+			return st, nil
+		}
+		rest, err := p.closing(seg)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" {
+			return nil, p.errorf(seg, "unexpected '%s' after '}'", rest)
+		}
+		return st, nil
+	case "return":
+		return &returnStmt{position: at, text: strings.TrimSpace(text[len("return"):])}, nil
+	case "break", "continue":
+		if text != word {
+			return nil, p.errorf(seg, "unexpected text after %s", word)
+		}
+		if word == "break" {
+			return &breakStmt{at}, nil
+		}
+		return &continueStmt{at}, nil
+	}
+	if opens {
+		return nil, p.errorf(seg, "unexpected '{'")
+	}
+	return &cmdStmt{position: at, text: text}, nil
+}
+
+// closedBlock parses a block body and its plain "}" closer
+func (p *scriptParser) closedBlock(opener segment) ([]stmt, error) {
+	body, err := p.block(&opener)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := p.closing(opener)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, p.errorf(opener, "unexpected '%s' after '}'", rest)
+	}
+	return body, nil
+}
@@ -1,27 +1,41 @@
 package cli
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"netxp/builtins"
 	"netxp/config"
-	"netxp/moduling"
 	"netxp/utils"
+	"netxp/values"
 
 	"github.com/peterh/liner"
 )
 
 // Shell represents the interactive shell
 type Shell struct {
-	cfg   *config.Config
-	repl  *liner.State
-	histf string
+	cfg    *config.Config
+	repl   *liner.State
+	histf  string
+	scope  *scope
+	funcs  *funcTable
+	depth  int // nesting of function and script calls
+	status int
+	// catching is set inside try blocks, where builtin errors are not
+	// printed but handed to the catch block
+	catching int
+	format   string // output format of a non-interactive shell
+	// expanding lists the aliases being expanded, innermost last
+	expanding []string
+	// stdin feeds the first stage of pipelines run for an alias
+	stdin io.Reader
+	// pipedIn is the data piped to netxp -c, for the first stage of a
+	// pipeline that reads its input
+	pipedIn io.Reader
 }
 
 // NewShell creates a new shell instance
@@ -34,7 +48,8 @@ func NewShell() *Shell {
 		repl.ReadHistory(f)
 		f.Close()
 	}
-	return &Shell{cfg: cfg, repl: repl, histf: histf}
+	builtins.InitDefaultBuiltins()
+	return &Shell{cfg: cfg, repl: repl, histf: histf, scope: newScope(), funcs: newFuncTable()}
 }
 
 // Close saves history and closes the shell
@@ -78,64 +93,105 @@ func (s *Shell) Run() error {
 			s.printHelp()
 			continue
 		}
-		if err := s.executePipeline(line); err != nil {
-			s.PrettyError("error", err, "")
+		src, ok := s.readBlock(line)
+		if !ok {
+			continue
+		}
+		if err := s.runSource(src, "", os.Stdout); err != nil {
+			s.reportError(err)
 		}
 	}
 }
 
-// executePipeline handles piped commands
-func (s *Shell) executePipeline(line string) error {
-	stages := utils.SplitPipeline(line)
-	var input []byte
-	var err error
-	for i, stage := range stages {
-		stage = strings.TrimSpace(stage)
-		cmdName, args := utils.ParseCmd(stage)
-		if cmdName == "" {
-			continue
+// readBlock keeps reading lines while first opens a block that is not
+// closed yet. It reports false when the input was aborted.
+func (s *Shell) readBlock(first string) (string, bool) {
+	src := first
+	for openBlocks(src) > 0 {
+		line, err := s.repl.Prompt("... ")
+		if err != nil {
+			fmt.Println()
+			return "", false
 		}
+		if line = strings.TrimSpace(line); line != "" {
+			s.repl.AppendHistory(line)
+		}
+		src += "\n" + line
+	}
+	return src, true
+}
 
-		// Builtin command
-		if builtins.IsBuiltin(cmdName) {
-			input, err = builtins.Execute(cmdName, args, input)
-			if err != nil {
-				return err
-			}
-			if i == len(stages)-1 {
-				fmt.Print(string(input))
-			}
+// executePipeline runs a command line: pipelines joined by ';', '&&' and
+// '||'. Errors of all but the last pipeline run are reported as they
+// happen; the last one is returned. The status of the last pipeline is
+// kept in $?.
+func (s *Shell) executePipeline(line string) error {
+	return s.runList(line, os.Stdout)
+}
+
+// runList is executePipeline with the output of the pipelines sent to out
+func (s *Shell) runList(line string, out io.Writer) error {
+	list, err := utils.ParseList(line)
+	if err != nil {
+		s.status = 2
+		return err
+	}
+	var last error
+	for _, p := range list {
+		if (p.Op == "&&" && s.status != 0) || (p.Op == "||" && s.status == 0) {
 			continue
 		}
+		if last != nil {
+			s.reportError(last)
+		}
+		last = s.runPipeline(p.Commands, out)
+		s.status = statusOf(last)
+	}
+	return last
+}
 
-		// Module command
-		if strings.HasPrefix(cmdName, "run:") {
-			modName := strings.TrimPrefix(cmdName, "run:")
-			input, err = moduling.Run(s.cfg, modName, args, input)
-			if err != nil {
-				return err
-			}
-			if i == len(stages)-1 {
-				fmt.Print(string(input))
+// runPipeline runs a single pipeline or "let" assignment
+func (s *Shell) runPipeline(stages []utils.Command, out io.Writer) error {
+	name, rhs, isLet, err := letAssignment(stages)
+	if err != nil {
+		return err
+	}
+	if alias, body, isAlias, err := aliasAssignment(stages); isAlias {
+		if err != nil {
+			return err
+		}
+		return s.defineAlias(alias, body)
+	}
+	if isLet {
+		if v, ok := literalAssignment(rhs); ok {
+			s.scope.set(name, v)
+			return nil
+		}
+		sink := &valueSink{}
+		if err := s.runStages(rhs, sink); err != nil {
+			var se *StatusError
+			if errors.As(err, &se) {
+				os.Stderr.Write(values.Encode(sink.value()))
 			}
-			continue
+			return err
 		}
+		s.scope.set(name, typedText(sink.value()))
+		return nil
+	}
+	return s.runStages(stages, out)
+}
 
-		// External command
-		cmd := exec.Command(cmdName, args...)
-		var outBuf bytes.Buffer
-		cmd.Stdout = &outBuf
-		cmd.Stderr = os.Stderr
-		cmd.Stdin = bytes.NewReader(input)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("command failed: %s", err)
-		}
-		input = outBuf.Bytes()
-		if i == len(stages)-1 {
-			fmt.Print(string(input))
-		}
+// reportError prints an error unless the failing command already reported it
+func (s *Shell) reportError(err error) {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return
 	}
-	return nil
+	if s.repl == nil {
+		s.batchError(err)
+		return
+	}
+	s.PrettyError("error", err, "")
 }
 
 // PrettyError prints a colorized error message
@@ -160,13 +216,76 @@ func (s *Shell) printHelp() {
 	fmt.Println("  gotodir <alias>       - Go to stored directory")
 	fmt.Println("  pwd                   - Print working directory")
 	fmt.Println("\nBuiltin Commands:")
-	builtins.InitDefaultBuiltins()
 	for _, b := range builtins.List() {
 		fmt.Printf("  %s\n", b)
 	}
 	fmt.Println("\nPiping:")
-	fmt.Println("  cmd1 | cmd2 | cmd3    - Pipe JSON output between commands")
-	fmt.Println("  cmd | tab             - Tabulate JSON output")
+	fmt.Println("  cmd1 | cmd2 | cmd3    - Pass typed values (tables, records, lists, text) between commands")
+	fmt.Println("  cmd | tab [-c a,b] [-w] [-t] - Render output as a table (columns, wrap, transpose)")
+	fmt.Println("\nData:")
+	fmt.Println("  where <condition>     - Keep rows matching e.g. size > 1mb && name =~ \"\\.log$\"")
+	fmt.Println("                          (== != < <= > >= =~ !~ in, is [not] null, and/&& or/|| not/!, ??)")
+	fmt.Println("  sort-by a b:desc [--desc] - Sort rows by columns")
+	fmt.Println("  group-by col [col]    - Group rows; each group has count and items")
+	fmt.Println("  uniq [-c], uniq-by [-c] col - Drop repeated rows, optionally counting them")
+	fmt.Println("  first/last/skip [N], reverse - Take, drop or reverse rows")
+	fmt.Println("  math sum|avg|min|max|median|stddev [col] - Aggregate a column or list")
+	fmt.Println("  insert age = now() - modtime - Add a computed column (update changes one)")
+	fmt.Println("  rename old new, reject col - Rename or drop columns")
+	fmt.Println("  from-csv/from-tsv, to-csv/to-tsv [-d ;] [--no-header] - Convert between text and tables")
+	fmt.Println("  from-json/yaml/toml/xml, to-json [-p]/yaml/toml/xml - Convert documents to and from values")
+	fmt.Println("  from-ndjson [--skip-invalid], to-ndjson - One JSON document per line, streamed row by row")
+	fmt.Println("  lines [-s], split column|row <sep> [cols] [-c] [-r] - Turn text into rows and columns")
+	fmt.Println("  parse \"{user} {pid} {cmd}\" | parse --regex '(?P<ip>\\S+) ...' - Extract typed columns from text")
+	fmt.Println("  10mb, 1.5gib, 90s, 2h, 3d, 2026-01-01 - Filesize, duration and datetime literals;")
+	fmt.Println("                          filesize(\"1 GB\"), duration(\"1h 30m\"), date(s) convert text")
+	fmt.Println("  query \"SELECT a, count(*) FROM input JOIN 'b.csv' ON ... GROUP BY a\" - SQL over the piped table and files")
+	fmt.Println("  diff-data old.json new.json [-k col] [--ignore a,b] - Added, removed and changed rows and fields")
+	fmt.Println("  describe              - Columns with types, null ratio, distinct count, min/max and samples,")
+	fmt.Println("                          or the type tree of a record")
+	fmt.Println("  get data.0.name, get 'items[*].addr?.ip', get 'rows[1:3]' - Follow a path into nested data")
+	fmt.Println("\nRedirection:")
+	fmt.Println("  cmd > file, cmd >> file - Write (append) output to a file")
+	fmt.Println("  cmd < file            - Read input from a file")
+	fmt.Println("  cmd 2> file, 2>&1     - Send errors to a file or into the pipeline")
+	fmt.Println("  cmd > file 2>&1       - Redirections apply left to right: both go to file,")
+	fmt.Println("                          while 2>&1 > file sends only output to file")
+	fmt.Println("\nSequencing:")
+	fmt.Println("  a ; b                 - Run b after a")
+	fmt.Println("  a && b, a || b        - Run b only if a succeeded (failed)")
+	fmt.Println("  $?                    - Status of the last command")
+	fmt.Println("\nExpansion:")
+	fmt.Println("  *.log, src/**/*.go    - Glob patterns (** matches any depth)")
+	fmt.Println("  ~, ~user              - Home directories")
+	fmt.Println("  file.{js,go}, {1..3}  - Brace expansion")
+	fmt.Println("  '*.log', \\*.log        - Quote or escape to pass a pattern through")
+	fmt.Println("\nVariables:")
+	fmt.Println("  let name = <pipeline> - Store the structured result of a pipeline")
+	fmt.Println("  let n = 0, let s = \"x\" - Store a literal; a pipeline printing one is typed too")
+	fmt.Println("  $HOME, $1             - Environment variables; unset positionals are empty")
+	fmt.Println("  $name | cmd           - Feed a stored value into a pipeline")
+	fmt.Println("  cmd $name.0.field     - Splice a value (or one of its fields) into args")
+	fmt.Println("  cmd $(pipeline)       - Splice the output of a pipeline into args")
+	fmt.Println("\nAliases:")
+	fmt.Println("  alias name = <pipeline>     - Define a command; $1 .. $9 and $args are its arguments")
+	fmt.Println("  alias [name]                - List aliases, or show one")
+	fmt.Println("  unalias name                - Remove an alias")
+	fmt.Println("\nDatasets:")
+	fmt.Println("  <pipeline> | save [-z] name - Keep a result in the workspace (big ones are gzipped)")
+	fmt.Println("  load name | <pipeline>      - Feed a saved result into a pipeline")
+	fmt.Println("  datasets [name]             - List datasets with their source, rows and size")
+	fmt.Println("  datasets rm name, datasets prune [--older-than 30d] [--keep N] [-n] - Remove datasets")
+	fmt.Println("\nScripting:")
+	fmt.Println("  if <pipeline> { } else { }  - Run a block when the pipeline succeeds")
+	fmt.Println("  for x in <pipeline> { }     - Run a block once per record")
+	fmt.Println("  while <pipeline> { }        - Repeat a block while the pipeline succeeds")
+	fmt.Println("  def name(a, b) { }          - Define a function, called like a command")
+	fmt.Println("  return [<pipeline>]         - Leave a function with a result")
+	fmt.Println("  try { } catch err { }       - Handle errors; $err.message, $err.code")
+	fmt.Println("                                A script carries on after a failed command, as sh does;")
+	fmt.Println("                                $? holds its status and try stops at the first error")
+	fmt.Println("  script.nxp [args]           - Run a script ($args holds the arguments)")
+	fmt.Println("  source script.nxp           - Run a script in the current scope")
 	fmt.Println("\nOther:")
 	fmt.Println("  help                  - Show this help")
 	fmt.Println("  exit, quit            - Exit shell")
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestShell returns a batch shell with its config in a fresh home
+// directory and a fresh working directory holding files, which maps
+// relative paths to their contents
+func newTestShell(t *testing.T, files map[string]string) *Shell {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	sh, err := NewBatchShell(FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sh
+}
+
+// run runs script source and returns its output, failing the test if it
+// takes longer than a few seconds
+func run(t *testing.T, sh *Shell, src string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- sh.runSource(src, "", &out) }()
+	select {
+	case err := <-done:
+		return strings.TrimSpace(out.String()), err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: still running after 5s", src)
+		return "", nil
+	}
+}
+
+// runOK is run for source that must succeed
+func runOK(t *testing.T, sh *Shell, src string) string {
+	t.Helper()
+	out, err := run(t, sh, src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return out
+}
+
+// TestSequencing checks that && and || run on the status of the
+// pipeline before them, which $? holds
+func TestSequencing(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`echo a; echo b`, "a\nb"},
+		{`echo a && echo b`, "a\nb"},
+		{`echo a || echo b`, "a"},
+		{`cat nosuch > err.json && echo b`, ""},
+		{`cat nosuch > err.json || echo b`, "b"},
+		{`cat nosuch > err.json && echo b || echo c`, "c"},
+		{`cat nosuch > err.json; echo $?`, "1"},
+		{`echo a; echo $?`, "a\n0"},
+	}
+	sh := newTestShell(t, nil)
+	for _, tt := range tests {
+		if got, _ := run(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// StatusError reports a command that ran but finished with a non-zero
+// status. The command has already reported the failure itself; Message
+// keeps the message of a failed builtin.
+type StatusError struct {
+	Command string
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s exited with status %d", e.Command, e.Code)
+}
+
+// statusOf maps the result of a pipeline to a shell exit status
+func statusOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return 127
+	}
+	return 1
+}
+
+// exitStatus converts the error of a finished process into a StatusError
+// when the process ran and exited non-zero
+func exitStatus(name string, err error) error {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() > 0 {
+		return &StatusError{Command: name, Code: ee.ExitCode()}
+	}
+	return err
+}
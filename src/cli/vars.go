@@ -0,0 +1,369 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"netxp/builtins"
+	"netxp/expr"
+	"netxp/utils"
+	"netxp/values"
+)
+
+// lookupVar resolves a reference like "hosts.0.ip" against the shell
+// variables. A name on a table selects that column from every row.
+func (s *Shell) lookupVar(ref string) (values.Value, error) {
+	if ref == "?" {
+		return values.Number(s.status), nil
+	}
+	path := strings.Split(ref, ".")
+	v, ok := s.scope.get(path[0])
+	if !ok {
+		v, ok = unsetVar(path[0])
+	}
+	if !ok {
+		return nil, fmt.Errorf("undefined variable: $%s", path[0])
+	}
+	for i, key := range path[1:] {
+		at := strings.Join(path[:i+2], ".")
+		switch x := v.(type) {
+		case *values.Record:
+			next, ok := x.Get(key)
+			if !ok {
+				return nil, fmt.Errorf("$%s: no field '%s'", at, key)
+			}
+			v = next
+		case values.List, *values.Table:
+			items := values.Items(x)
+			n, err := strconv.Atoi(key)
+			if err != nil {
+				if t, isTable := x.(*values.Table); isTable {
+					column, err := tableColumn(t, key)
+					if err != nil {
+						return nil, fmt.Errorf("$%s: %s", at, err)
+					}
+					v = column
+					continue
+				}
+				return nil, fmt.Errorf("$%s: list index must be a number", at)
+			}
+			if n < 0 {
+				n += len(items)
+			}
+			if n < 0 || n >= len(items) {
+				return nil, fmt.Errorf("$%s: index out of range (length %d)", at, len(items))
+			}
+			v = items[n]
+		default:
+			return nil, fmt.Errorf("$%s: cannot index into %s", at, kindOf(v))
+		}
+	}
+	return v, nil
+}
+
+// unsetVar gives the value of a name the shell does not define: an
+// environment variable as text, or, as in sh, nothing for a positional
+// parameter and no arguments for $args outside a call that sets them
+func unsetVar(name string) (values.Value, bool) {
+	if v, ok := os.LookupEnv(name); ok {
+		return values.String(v), true
+	}
+	if name == "args" {
+		return values.List{}, true
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return values.Nothing{}, true
+	}
+	return nil, false
+}
+
+// tableColumn returns one column of a table as a list
+func tableColumn(t *values.Table, name string) (values.List, error) {
+	found := false
+	for _, c := range t.Columns {
+		found = found || c == name
+	}
+	if !found {
+		return nil, fmt.Errorf("no column '%s'", name)
+	}
+	column := make(values.List, len(t.Rows))
+	for i, row := range t.Rows {
+		if v, ok := row.Get(name); ok {
+			column[i] = v
+		} else {
+			column[i] = values.Nothing{}
+		}
+	}
+	return column, nil
+}
+
+// expandWord expands a word into arguments: braces, then a leading ~,
+// then variables and $( ... ), then glob patterns. An unquoted word that
+// is a single variable or substitution holding a list expands to one
+// argument per element; multi-line substitution output counts as a list. Quoted or
+// escaped text and variable values are never treated as patterns, and a
+// pattern without matches is passed through as written. Variables keep
+// the type of their value; everything else expands to strings.
+func (s *Shell) expandWord(w utils.Token) ([]values.Value, error) {
+	var out []values.Value
+	for _, parts := range expandBraces(w.Parts) {
+		words, err := s.expandParts(expandTilde(parts))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, words...)
+	}
+	return out, nil
+}
+
+// expandParts substitutes variables in one word and applies globbing
+func (s *Shell) expandParts(parts []utils.WordPart) ([]values.Value, error) {
+	if len(parts) == 1 && (parts[0].Var || parts[0].Subst) && !parts[0].Quoted {
+		v, err := s.partValue(parts[0], true)
+		if err != nil {
+			return nil, err
+		}
+		if k := v.Kind(); k == values.KindList || k == values.KindTable {
+			return values.Items(v), nil
+		}
+		return []values.Value{v}, nil
+	}
+	var text, pattern strings.Builder
+	isPattern := false
+	for _, p := range parts {
+		if !p.Var && !p.Subst {
+			text.WriteString(p.Text)
+			if p.Quoted {
+				pattern.WriteString(escapeMeta(p.Text))
+			} else {
+				pattern.WriteString(p.Text)
+				isPattern = isPattern || hasMeta(p.Text)
+			}
+			continue
+		}
+		v, err := s.partValue(p, false)
+		if err != nil {
+			return nil, err
+		}
+		text.WriteString(values.Text(v))
+		pattern.WriteString(escapeMeta(values.Text(v)))
+	}
+	if isPattern {
+		if matches := glob(pattern.String()); len(matches) > 0 {
+			out := make([]values.Value, len(matches))
+			for i, m := range matches {
+				out[i] = values.String(m)
+			}
+			return out, nil
+		}
+	}
+	return []values.Value{values.String(text.String())}, nil
+}
+
+// partValue returns the value of a variable or substitution part.
+// whole is set when the part makes up an entire unquoted word; the
+// output of a substitution is then split into one value per line.
+func (s *Shell) partValue(p utils.WordPart, whole bool) (values.Value, error) {
+	if !p.Subst {
+		return s.lookupVar(p.Text)
+	}
+	v, err := s.substitute(p.Text)
+	if err != nil {
+		return nil, err
+	}
+	if p.Quoted {
+		return values.String(strings.TrimSpace(values.Text(v))), nil
+	}
+	text, ok := v.(values.String)
+	if !ok {
+		return v, nil
+	}
+	text = values.String(strings.TrimSpace(string(text)))
+	if !whole || !strings.Contains(string(text), "\n") {
+		return text, nil
+	}
+	// line-oriented text: one argument per line
+	var lines values.List
+	for _, line := range strings.Split(string(text), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, values.Decode([]byte(line)))
+		}
+	}
+	return lines, nil
+}
+
+// substitute runs the command line of a $( ... ) and returns its output
+func (s *Shell) substitute(line string) (values.Value, error) {
+	sink := &valueSink{}
+	err := s.runList(line, sink)
+	v := sink.value()
+	if err == nil {
+		return v, nil
+	}
+	if e, ok := v.(*builtins.ExecutionError); ok {
+		return nil, fmt.Errorf("$(%s): %s", line, e.Message)
+	}
+	return nil, fmt.Errorf("$(%s): %w", line, err)
+}
+
+// expandExpr expands the expression of an expression command. Variables
+// and substitutions become literals of the expression language, so a
+// string value stays a string rather than turning into a field name,
+// and numbers, filesizes and durations keep their unit. The text output
+// of an unquoted substitution is typed like a literal argument.
+func (s *Shell) expandExpr(parts []utils.WordPart) (string, error) {
+	var b strings.Builder
+	for _, p := range parts {
+		if !p.Var && !p.Subst {
+			b.WriteString(p.Text)
+			continue
+		}
+		v, err := s.partValue(p, true)
+		if err != nil {
+			return "", err
+		}
+		if text, ok := v.(values.String); ok && p.Subst && !p.Quoted {
+			v = wordValue(string(text))
+		}
+		b.WriteString(expr.Literal(v))
+	}
+	return b.String(), nil
+}
+
+// expandArgv expands every word of a command. Besides the text of the
+// arguments it returns them as values, for functions and aliases: an
+// unquoted literal such as 42 or 10mb is typed, variables keep the type
+// of their value and everything else is a string.
+func (s *Shell) expandArgv(words []utils.Token) ([]string, []values.Value, error) {
+	var argv []string
+	var vals []values.Value
+	for _, w := range words {
+		if w.Expr {
+			text, err := s.expandExpr(w.Parts)
+			if err != nil {
+				return nil, nil, err
+			}
+			argv = append(argv, text)
+			vals = append(vals, values.String(text))
+			continue
+		}
+		expanded, err := s.expandWord(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		literal := literalWord(w)
+		for _, v := range expanded {
+			text := values.Text(v)
+			argv = append(argv, text)
+			if literal {
+				v = wordValue(text)
+			}
+			vals = append(vals, v)
+		}
+	}
+	return argv, vals, nil
+}
+
+// literalWord reports whether a word is written out in full, without
+// quotes, escapes, variables or substitutions
+func literalWord(w utils.Token) bool {
+	for _, p := range w.Parts {
+		if p.Var || p.Subst || p.Quoted {
+			return false
+		}
+	}
+	return true
+}
+
+// wordValue types an argument written as a number, filesize, duration or
+// datetime literal and leaves any other word a string
+func wordValue(word string) values.Value {
+	if v, ok := expr.ParseLiteral(word); ok {
+		return v
+	}
+	return values.String(word)
+}
+
+// texts returns the text of expanded values
+func texts(vals []values.Value) []string {
+	out := make([]string, len(vals))
+	for i, v := range vals {
+		out[i] = values.Text(v)
+	}
+	return out
+}
+
+// valueStage reports whether a stage is a lone $ref, which feeds the
+// referenced value into the pipeline instead of running a command
+func valueStage(c utils.Command) (string, bool) {
+	if len(c.Words) != 1 || len(c.Words[0].Parts) != 1 {
+		return "", false
+	}
+	p := c.Words[0].Parts[0]
+	return p.Text, p.Var && !p.Quoted
+}
+
+// letAssignment recognises "let name = <pipeline>" and returns the name
+// and the pipeline on the right-hand side
+func letAssignment(stages []utils.Command) (string, []utils.Command, bool, error) {
+	first := stages[0]
+	if len(first.Words) == 0 || first.Words[0].Raw != "let" {
+		return "", nil, false, nil
+	}
+	if len(first.Words) < 4 || first.Words[2].Raw != "=" {
+		return "", nil, true, fmt.Errorf("usage: let <name> = <pipeline>")
+	}
+	name := first.Words[1].Raw
+	if !utils.IsName(name) {
+		return "", nil, true, &utils.SyntaxError{Pos: first.Words[1].Pos, Msg: fmt.Sprintf("invalid variable name '%s'", name)}
+	}
+	rhs := make([]utils.Command, len(stages))
+	copy(rhs, stages)
+	rhs[0] = utils.Command{Words: first.Words[3:], Redirects: first.Redirects, Pos: first.Words[3].Pos}
+	return name, rhs, true, nil
+}
+
+// literalAssignment returns the value of the right-hand side of a let
+// that is a single literal word, such as 0, 10mb or "text", which is
+// stored as written rather than run as a command
+func literalAssignment(rhs []utils.Command) (values.Value, bool) {
+	if len(rhs) != 1 || len(rhs[0].Words) != 1 || len(rhs[0].Redirects) > 0 {
+		return nil, false
+	}
+	w := rhs[0].Words[0]
+	if literalWord(w) {
+		return expr.ParseLiteral(w.Raw)
+	}
+	var text strings.Builder
+	for _, p := range w.Parts {
+		if !p.Quoted || p.Var || p.Subst {
+			return nil, false
+		}
+		text.WriteString(p.Text)
+	}
+	return values.String(text.String()), true
+}
+
+// typedText types the text output of a let pipeline that is a single
+// literal, like the output of $( ... ) in an expression, so that
+// let n = echo 100 stores a number
+func typedText(v values.Value) values.Value {
+	text, ok := v.(values.String)
+	if !ok {
+		return v
+	}
+	if lit, ok := expr.ParseLiteral(strings.TrimSpace(string(text))); ok {
+		return lit
+	}
+	return v
+}
+
+// kindOf names the kind of a value for error messages
+func kindOf(v values.Value) string {
+	if v == nil {
+		return values.KindNothing.String()
+	}
+	return v.Kind().String()
+}
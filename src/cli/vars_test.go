@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestVariables checks where variables come from and the type of what
+// let stores
+func TestVariables(t *testing.T) {
+	files := map[string]string{
+		"big.log":   strings.Repeat("x", 200),
+		"small.log": "x",
+	}
+	t.Setenv("NETXP_TEST", "from env")
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`echo $NETXP_TEST`, `from env`},
+		{`echo "[$NETXP_TEST]"`, `[from env]`},
+		{`echo price$5`, `price`},
+		{`echo [$1] $args`, `[]`},
+		{`let n = 0; ls | where size > $n | get name`, `["big.log","small.log"]`},
+		{`let n = echo 100; ls | where size > $n | get name`, `["big.log"]`},
+		{`ls | where size > $(echo 100) | get name`, `["big.log"]`},
+		{`let n = echo 100; $n | to-json`, `100`},
+		{`let n = 1kb; $n | to-json`, `1000`},
+		{`let s = "0"; $s | to-json`, `"0"`},
+		{`let s = "a b"; echo $s`, `a b`},
+		{`let s = echo a b; $s | to-json`, `"a b"`},
+		{`def f(x) { echo [$2] }; f 1`, `[]`},
+	}
+	sh := newTestShell(t, files)
+	for _, tt := range tests {
+		if got := runOK(t, sh, tt.line); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.line, got, tt.want)
+		}
+	}
+	if _, err := run(t, sh, `echo $nosuch`); err == nil || !strings.Contains(err.Error(), "undefined variable: $nosuch") {
+		t.Errorf("echo $nosuch: got %v", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 type Config struct {
 	ModulesDir string            `json:"modules_dir"`
 	Dirs       map[string]string `json:"dirs"`
+	Aliases    map[string]string `json:"aliases"`
 	LastDir    string            `json:"last_dir"`
 	Theme      string            `json:"theme"`
 	Workspace  string            `json:"workspace"`
@@ -25,8 +26,14 @@ func ConfigPath() string {
 			return filepath.Join(v, "netxp")
 		}
 	}
-	usr, _ := user.Current()
-	return filepath.Join(usr.HomeDir, ".netxp")
+	// $HOME first, so that a batch run or test can point it elsewhere
+	home, _ := os.UserHomeDir()
+	if home == "" {
+		if usr, err := user.Current(); err == nil {
+			home = usr.HomeDir
+		}
+	}
+	return filepath.Join(home, ".netxp")
 }
 
 // Load reads config from disk
@@ -40,6 +47,9 @@ func Load() (*Config, error) {
 	if b, err := ioutil.ReadFile(cfgFile); err == nil {
 		_ = json.Unmarshal(b, cfg)
 	}
+	if cfg.Aliases == nil {
+		cfg.Aliases = make(map[string]string)
+	}
 	if cfg.ModulesDir == "" {
 		cfg.ModulesDir = filepath.Join(cfgDir, "modules")
 		_ = os.MkdirAll(cfg.ModulesDir, 0755)
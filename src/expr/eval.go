@@ -0,0 +1,651 @@
+package expr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"netxp/values"
+)
+
+// node is an element of a parsed expression
+type node interface {
+	eval(row values.Value) (values.Value, error)
+}
+
+type literal struct{ v values.Value }
+
+// field is a bare name: a field of the row, or the row itself for "it"
+type field struct{ name string }
+
+type member struct {
+	x    node
+	key  string
+	text string
+}
+
+type index struct {
+	x, key node
+	text   string
+}
+
+type unary struct {
+	op   string
+	x    node
+	text string
+}
+
+type binary struct {
+	op   string
+	l, r node
+	re   *regexp.Regexp // compiled literal pattern of =~ and !~
+	text string
+}
+
+type call struct {
+	name string
+	fn   *Function
+	args []node
+	text string
+}
+
+type listNode struct{ items []node }
+
+type recordNode struct {
+	keys []string
+	vals []node
+}
+
+// Eval evaluates the expression against a row
+func (e *Expr) Eval(row values.Value) (values.Value, error) {
+	return e.root.eval(row)
+}
+
+// Test evaluates a condition. Nothing counts as false, so rows that lack
+// a field do not match; any value other than a bool is an error.
+func (e *Expr) Test(row values.Value) (bool, error) {
+	v, err := e.root.eval(row)
+	if err != nil {
+		return false, err
+	}
+	return truth(v, e.src)
+}
+
+func (n *literal) eval(values.Value) (values.Value, error) {
+	return n.v, nil
+}
+
+func (n *field) eval(row values.Value) (values.Value, error) {
+	if r, ok := row.(*values.Record); ok {
+		if v, ok := r.Get(n.name); ok {
+			return v, nil
+		}
+		if n.name == "it" {
+			return r, nil
+		}
+		return values.Nothing{}, nil
+	}
+	if n.name == "it" {
+		return row, nil
+	}
+	return nil, fmt.Errorf("'%s' is not a field: the row is a %s, refer to it as 'it'", n.name, row.Kind())
+}
+
+func (n *member) eval(row values.Value) (values.Value, error) {
+	x, err := n.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	return lookup(x, values.String(n.key), n.text)
+}
+
+func (n *index) eval(row values.Value) (values.Value, error) {
+	x, err := n.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	return lookup(x, key, n.text)
+}
+
+// lookup reads a field of a record, a column of a table or an element of
+// a list. Missing fields and elements are Nothing.
+func lookup(x, key values.Value, text string) (values.Value, error) {
+	switch k := key.(type) {
+	case values.String:
+		switch c := x.(type) {
+		case values.Nothing:
+			return c, nil
+		case *values.Record:
+			if v, ok := c.Get(string(k)); ok {
+				return v, nil
+			}
+			return values.Nothing{}, nil
+		case *values.Table:
+			col := make(values.List, len(c.Rows))
+			for i, r := range c.Rows {
+				col[i], _ = lookup(r, k, text)
+			}
+			return col, nil
+		}
+	case values.Number:
+		items, ok := x.(values.List)
+		if t, isTable := x.(*values.Table); isTable {
+			items, ok = values.Items(t), true
+		}
+		if _, isNothing := x.(values.Nothing); isNothing {
+			return x, nil
+		}
+		if ok {
+			i := int(k)
+			if i < 0 {
+				i += len(items)
+			}
+			if float64(int(k)) != float64(k) || i < 0 || i >= len(items) {
+				return values.Nothing{}, nil
+			}
+			return items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("cannot index %s with %s in '%s'", x.Kind(), key.Kind(), text)
+}
+
+func (n *unary) eval(row values.Value) (values.Value, error) {
+	x, err := n.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, err := truth(x, n.text)
+		return values.Bool(!b), err
+	case "-":
+		switch num := x.(type) {
+		case values.Number:
+			return -num, nil
+		case values.Filesize:
+			return -num, nil
+		case values.Duration:
+			return -num, nil
+		}
+		return nil, fmt.Errorf("cannot negate %s in '%s'", x.Kind(), n.text)
+	case "is null":
+		return values.Bool(values.IsNothing(x)), nil
+	case "is not null":
+		return values.Bool(!values.IsNothing(x)), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func (n *binary) eval(row values.Value) (values.Value, error) {
+	l, err := n.l.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	// the boolean operators and ?? only evaluate the right side if needed
+	switch n.op {
+	case "&&", "||":
+		b, err := truth(l, n.text)
+		if err != nil || b == (n.op == "||") {
+			return values.Bool(b), err
+		}
+		r, err := n.r.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		b, err = truth(r, n.text)
+		return values.Bool(b), err
+	case "??":
+		if !values.IsNothing(l) {
+			return l, nil
+		}
+		return n.r.eval(row)
+	}
+	r, err := n.r.eval(row)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return values.Bool(Equal(l, r)), nil
+	case "!=":
+		return values.Bool(!Equal(l, r)), nil
+	case "<", "<=", ">", ">=":
+		if values.IsNothing(l) || values.IsNothing(r) {
+			return values.Bool(false), nil
+		}
+		c, err := Compare(l, r)
+		if err != nil {
+			return nil, fmt.Errorf("%s in '%s'", err, n.text)
+		}
+		switch n.op {
+		case "<":
+			return values.Bool(c < 0), nil
+		case "<=":
+			return values.Bool(c <= 0), nil
+		case ">":
+			return values.Bool(c > 0), nil
+		}
+		return values.Bool(c >= 0), nil
+	case "=~", "!~":
+		if values.IsNothing(l) {
+			return values.Bool(n.op == "!~"), nil
+		}
+		s, ok := l.(values.String)
+		if !ok {
+			return nil, fmt.Errorf("cannot match %s against a regex in '%s'", l.Kind(), n.text)
+		}
+		re := n.re
+		if re == nil {
+			pattern, ok := r.(values.String)
+			if !ok {
+				return nil, fmt.Errorf("regex must be a string, not %s, in '%s'", r.Kind(), n.text)
+			}
+			if re, err = regexp.Compile(string(pattern)); err != nil {
+				return nil, fmt.Errorf("invalid regex in '%s': %s", n.text, err)
+			}
+		}
+		return values.Bool(re.MatchString(string(s)) == (n.op == "=~")), nil
+	case "in", "not in":
+		found, err := contains(r, l, n.text)
+		if err != nil {
+			return nil, err
+		}
+		return values.Bool(found == (n.op == "in")), nil
+	}
+	return arith(n.op, l, r, n.text)
+}
+
+// Arith applies an arithmetic operator the way expressions do, so other
+// languages over values share its typing; text is quoted in errors
+func Arith(op string, l, r values.Value, text string) (values.Value, error) {
+	return arith(op, l, r, text)
+}
+
+// arith applies + - * / and %. + also joins strings and lists.
+func arith(op string, l, r values.Value, text string) (values.Value, error) {
+	if values.IsNothing(l) || values.IsNothing(r) {
+		return values.Nothing{}, nil
+	}
+	switch a := l.(type) {
+	case values.Number:
+		switch r.(type) {
+		case values.Filesize, values.Duration:
+			if op == "*" {
+				return quantity(op, r, l, text)
+			}
+		}
+		if b, ok := r.(values.Number); ok {
+			switch op {
+			case "+":
+				return a + b, nil
+			case "-":
+				return a - b, nil
+			case "*":
+				return a * b, nil
+			case "/":
+				if b == 0 {
+					return nil, fmt.Errorf("division by zero in '%s'", text)
+				}
+				return a / b, nil
+			case "%":
+				if b == 0 {
+					return nil, fmt.Errorf("division by zero in '%s'", text)
+				}
+				return values.Number(math.Mod(float64(a), float64(b))), nil
+			}
+		}
+	case values.Filesize, values.Duration:
+		return quantity(op, l, r, text)
+	case values.Datetime:
+		switch b := r.(type) {
+		case values.Datetime:
+			if op == "-" {
+				return values.Duration(a.Time().Sub(b.Time())), nil
+			}
+		case values.Duration:
+			switch op {
+			case "+":
+				return values.Datetime(a.Time().Add(time.Duration(b))), nil
+			case "-":
+				return values.Datetime(a.Time().Add(-time.Duration(b))), nil
+			}
+		}
+	case values.String:
+		if b, ok := r.(values.String); ok && op == "+" {
+			return a + b, nil
+		}
+	case values.List:
+		if b, ok := r.(values.List); ok && op == "+" {
+			return append(append(values.List{}, a...), b...), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot apply '%s' to %s and %s in '%s'", op, l.Kind(), r.Kind(), text)
+}
+
+// quantity applies an operator to a filesize or duration. Two of the
+// same kind add, subtract and divide to a ratio; a plain number scales
+// one with * and /, and with + and - counts as bytes or seconds. A
+// filesize divided by a number is the number of bytes divided, as in
+// size / 1024, so that it is not rounded to whole bytes.
+func quantity(op string, l, r values.Value, text string) (values.Value, error) {
+	a, b := magnitude(l), magnitude(r)
+	same := l.Kind() == r.Kind()
+	if _, isNumber := r.(values.Number); !same && !isNumber {
+		return nil, fmt.Errorf("cannot apply '%s' to %s and %s in '%s'", op, l.Kind(), r.Kind(), text)
+	}
+	if _, isDuration := l.(values.Duration); isDuration && !same && (op == "+" || op == "-") {
+		b *= float64(time.Second)
+	}
+	var n float64
+	switch op {
+	case "+":
+		n = a + b
+	case "-":
+		n = a - b
+	case "*":
+		if same {
+			return nil, fmt.Errorf("cannot multiply %s by %s in '%s'", l.Kind(), r.Kind(), text)
+		}
+		n = a * b
+	case "/", "%":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero in '%s'", text)
+		}
+		if op == "%" {
+			n = math.Mod(a, b)
+			break
+		}
+		_, isSize := l.(values.Filesize)
+		if n = a / b; same || isSize {
+			return values.Number(n), nil
+		}
+	}
+	if _, isSize := l.(values.Filesize); isSize {
+		return values.Filesize(math.Round(n)), nil
+	}
+	return values.Duration(n), nil
+}
+
+// magnitude is a number, a filesize in bytes or a duration in nanoseconds
+func magnitude(v values.Value) float64 {
+	switch x := v.(type) {
+	case values.Filesize:
+		return float64(x)
+	case values.Duration:
+		return float64(x)
+	case values.Number:
+		return float64(x)
+	}
+	return 0
+}
+
+// contains implements "in": membership of a list or table, a key of a
+// record or a substring of a string
+func contains(set, item values.Value, text string) (bool, error) {
+	switch s := set.(type) {
+	case values.Nothing:
+		return false, nil
+	case values.List, *values.Table:
+		for _, v := range values.Items(s) {
+			if Equal(item, v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *values.Record:
+		if k, ok := item.(values.String); ok {
+			_, found := s.Get(string(k))
+			return found, nil
+		}
+	case values.String:
+		if sub, ok := item.(values.String); ok {
+			return strings.Contains(string(s), string(sub)), nil
+		}
+	}
+	return false, fmt.Errorf("cannot look for %s in %s in '%s'", item.Kind(), set.Kind(), text)
+}
+
+func (n *call) eval(row values.Value) (values.Value, error) {
+	args := make([]values.Value, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn.Fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s in '%s'", err, n.text)
+	}
+	return v, nil
+}
+
+func (n *listNode) eval(row values.Value) (values.Value, error) {
+	items := make([]values.Value, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return values.NewList(items), nil
+}
+
+func (n *recordNode) eval(row values.Value) (values.Value, error) {
+	r := values.NewRecord()
+	for i, k := range n.keys {
+		v, err := n.vals[i].eval(row)
+		if err != nil {
+			return nil, err
+		}
+		r.Set(k, v)
+	}
+	return r, nil
+}
+
+// Truth is the value of a condition: a bool, or Nothing when it is
+// unknown. text is the condition for the error message.
+func Truth(v values.Value, text string) (values.Value, error) {
+	switch v.(type) {
+	case values.Bool, values.Nothing:
+		return v, nil
+	}
+	return nil, fmt.Errorf("expected a bool, got %s, in '%s'", v.Kind(), text)
+}
+
+// truth is the value of a condition; Nothing is false
+func truth(v values.Value, text string) (bool, error) {
+	t, err := Truth(v, text)
+	if err != nil {
+		return false, err
+	}
+	b, _ := t.(values.Bool)
+	return bool(b), nil
+}
+
+// Equal reports whether two values are the same. Values of different
+// kinds are never equal, except that filesizes, durations and datetimes
+// equal what Compare finds the same.
+func Equal(a, b values.Value) bool {
+	if a == nil {
+		a = values.Nothing{}
+	}
+	if b == nil {
+		b = values.Nothing{}
+	}
+	if typed(a) || typed(b) {
+		c, err := Compare(a, b)
+		return err == nil && c == 0
+	}
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch x := a.(type) {
+	case values.Nothing:
+		return true
+	case values.Bool, values.Number, values.String:
+		return a == b
+	case values.Binary:
+		return bytes.Equal(x, b.(values.Binary))
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// typed reports whether a value is a filesize, duration or datetime
+func typed(v values.Value) bool {
+	switch v.(type) {
+	case values.Filesize, values.Duration, values.Datetime:
+		return true
+	}
+	return false
+}
+
+// Compare orders two numbers, two strings or two filesizes, durations or
+// datetimes. A number compares with a filesize as bytes and with a
+// duration as seconds, and text with a datetime as the date it holds.
+func Compare(a, b values.Value) (int, error) {
+	if typed(b) && !typed(a) {
+		c, err := Compare(b, a)
+		return -c, err
+	}
+	switch x := a.(type) {
+	case values.Filesize:
+		switch y := b.(type) {
+		case values.Filesize:
+			return sign(float64(x) - float64(y)), nil
+		case values.Number:
+			return sign(float64(x) - float64(y)), nil
+		}
+	case values.Duration:
+		switch y := b.(type) {
+		case values.Duration:
+			return sign(float64(x) - float64(y)), nil
+		case values.Number:
+			return sign(time.Duration(x).Seconds() - float64(y)), nil
+		}
+	case values.Datetime:
+		switch b.(type) {
+		case values.Datetime, values.String:
+			t, err := ParseTime(b)
+			if err != nil {
+				return 0, err
+			}
+			switch {
+			case x.Time().Before(t):
+				return -1, nil
+			case x.Time().After(t):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case values.Number:
+		if y, ok := b.(values.Number); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case values.String:
+		if y, ok := b.(values.String); ok {
+			return strings.Compare(string(x), string(y)), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", a.Kind(), b.Kind())
+}
+
+// Order compares two values for sorting: nulls first, then values in the
+// order of Compare, false before true, and values that do not compare by
+// kind and then by text
+func Order(a, b values.Value) int {
+	na, nb := values.IsNothing(a), values.IsNothing(b)
+	switch {
+	case na && nb:
+		return 0
+	case na:
+		return -1
+	case nb:
+		return 1
+	}
+	if x, ok := a.(values.Bool); ok {
+		if y, ok := b.(values.Bool); ok {
+			switch {
+			case x == y:
+				return 0
+			case !bool(x):
+				return -1
+			}
+			return 1
+		}
+	}
+	if c, err := Compare(a, b); err == nil {
+		return c
+	}
+	if a.Kind() != b.Kind() {
+		return int(a.Kind()) - int(b.Kind())
+	}
+	return strings.Compare(values.Text(a), values.Text(b))
+}
+
+func sign(d float64) int {
+	switch {
+	case d < 0:
+		return -1
+	case d > 0:
+		return 1
+	}
+	return 0
+}
+
+// Literal writes a value as an expression literal, which is how the shell
+// puts variables into an expression
+func Literal(v values.Value) string {
+	switch x := v.(type) {
+	case nil, values.Nothing:
+		return "null"
+	case values.Bool, values.Number:
+		return values.Text(v)
+	case values.Filesize:
+		return values.Text(x) + "b"
+	case values.Duration:
+		return values.Text(values.Number(x)) + "ns"
+	case values.Datetime:
+		return x.Time().UTC().Format(time.RFC3339Nano)
+	case values.String:
+		return quote(string(x))
+	case values.Binary:
+		return quote(string(x))
+	case *values.Error:
+		return quote(x.Message)
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return quote(values.Text(v))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func quote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,310 @@
+package expr
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"netxp/values"
+)
+
+// testRow is the row the expressions of the tests are evaluated against
+func testRow() *values.Record {
+	return values.NewRecord(
+		"name", "web1",
+		"port", 80,
+		"size", values.Filesize(2000),
+		"up", values.Duration(90*time.Minute),
+		"since", values.Datetime(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)),
+		"tags", values.List{values.String("a"), values.String("b")},
+		"owner", nil,
+		"ok", true,
+	)
+}
+
+// jsonOf renders a value as compact JSON for comparisons
+func jsonOf(v values.Value) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// arithmetic and precedence
+		{`1 + 2 * 3`, `7`},
+		{`(1 + 2) * 3`, `9`},
+		{`7 % 4 - -1`, `4`},
+		{`port / 8`, `10`},
+		{`"a" + "b"`, `"ab"`},
+		// fields, members and indexes
+		{`name`, `"web1"`},
+		{`tags[1]`, `"b"`},
+		{`it.port`, `80`},
+		{`missing`, `null`},
+		{`owner ?? "nobody"`, `"nobody"`},
+		{`{a: port, b: [1, 2]}`, `{"a":80,"b":[1,2]}`},
+		// comparisons and logic
+		{`port == 80 and name != "x"`, `true`},
+		{`port > 100 or not ok`, `false`},
+		{`port in [22, 80]`, `true`},
+		{`"a" in tags`, `true`},
+		{`name =~ "^web\\d$"`, `true`},
+		{`name !~ "db"`, `true`},
+		{`owner is null`, `true`},
+		{`port is not null`, `true`},
+		{`owner == null`, `true`},
+		// functions
+		{`upper(name)`, `"WEB1"`},
+		{`len(tags)`, `2`},
+		{`substr(name, 1, 2)`, `"eb"`},
+		{`substr(name, -1)`, `"1"`},
+		{`round(2.345, 2)`, `2.35`},
+		{`min(3, 1, 2)`, `1`},
+		{`max(tags)`, `"b"`},
+		{`replace(name, "web", "db")`, `"db1"`},
+		{`split("a,b", ",")`, `["a","b"]`},
+		{`number("12.5") + 1`, `13.5`},
+		{`lower(owner)`, `null`},
+	}
+	row := testRow()
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		v, err := e.Eval(row)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got := jsonOf(v); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+// TestUnits checks that filesizes, durations and datetimes keep their
+// kind through arithmetic and compare with literals of their unit
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`size + 1kb`, `3000`},
+		{`size > 1kb`, `true`},
+		{`size < 2kb`, `false`},
+		{`up > 1h`, `true`},
+		{`up - 30m == 1h`, `true`},
+		{`since > 2026-01-01`, `true`},
+		{`since - 2026-01-02T00:00:00Z`, `"3h4m5s"`},
+		{`since + 1d > 2026-01-03`, `true`},
+		{`size * 2`, `4000`},
+		{`size * 2 > 3kb`, `true`},
+		{`size / 1000`, `2`},
+		{`size / 3`, `666.6666666666666`},
+		{`size / 1kb`, `2`},
+		{`up / 2 == 45m`, `true`},
+		{`filesize("1 MB") > size`, `true`},
+		{`duration(60) == 1m`, `true`},
+		{`year(since)`, `2026`},
+		{`format_date(since, "%Y/%m/%d %H:%M")`, `"2026/01/02 03:04"`},
+	}
+	row := testRow()
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		v, err := e.Eval(row)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got := jsonOf(v); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // part of the error message
+	}{
+		{`port > "80"`, "cannot compare number with string"},
+		{`size > "big"`, "big"},
+		{`name - 1`, "string"},
+		{`upper(port)`, "must be a string"},
+		{`tags["a"]`, "cannot index list with string"},
+		{`number("x")`, "not a number"},
+		{`since > 5kb`, "cannot compare"},
+	}
+	row := testRow()
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		v, err := e.Eval(row)
+		if err == nil {
+			t.Errorf("%s = %s, want an error", tt.src, jsonOf(v))
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %q, want it to mention %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestTest(t *testing.T) {
+	tests := []struct {
+		src     string
+		want    bool
+		wantErr bool
+	}{
+		{`port == 80`, true, false},
+		{`missing > 1`, false, false},
+		{`owner`, false, false},
+		{`ok and port < 100`, true, false},
+		{`port`, false, true},
+		{`name`, false, true},
+	}
+	row := testRow()
+	for _, tt := range tests {
+		e, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		got, err := e.Test(row)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.src, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+	}{
+		{``, 1},
+		{`a ==`, 5},
+		{`(a > 1`, 7},
+		{`a > 1)`, 6},
+		{`"open`, 1},
+		{`nosuch(1)`, 1},
+		{`len(1, 2)`, 1},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%q: got %v, want a syntax error", tt.src, err)
+			continue
+		}
+		if se.Pos != tt.pos {
+			t.Errorf("%q: error at %d, want %d (%s)", tt.src, se.Pos, tt.pos, se.Msg)
+		}
+	}
+}
+
+func TestParseLiteral(t *testing.T) {
+	tests := []struct {
+		word string
+		want string // JSON of the value; empty when the word is not a literal
+	}{
+		{"42", `42`},
+		{"-1.5", `-1.5`},
+		{"1e3", `1000`},
+		{"10mb", `10000000`},
+		{"-2kb", `-2000`},
+		{"90s", `"1m30s"`},
+		{"2026-01-01T00:00:00Z", `"2026-01-01T00:00:00Z"`},
+		{"0", `0`},
+		{"0.5", `0.5`},
+		{"007", ``},
+		{"10.0.0.1", ``},
+		{"1.", ``},
+		{"web1", ``},
+		{"", ``},
+		{"-", ``},
+		{"-2026-01-01", ``},
+	}
+	for _, tt := range tests {
+		v, ok := ParseLiteral(tt.word)
+		got := ""
+		if ok {
+			got = jsonOf(v)
+		}
+		if got != tt.want {
+			t.Errorf("ParseLiteral(%q) = %s, want %s", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestLiteralRoundTrip(t *testing.T) {
+	for _, v := range []values.Value{
+		values.Nothing{},
+		values.Bool(true),
+		values.Number(-2.5),
+		values.String(`say "hi"\n`),
+		values.Filesize(1500),
+		values.Duration(90 * time.Second),
+		values.Datetime(time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)),
+		values.List{values.Number(1), values.String("a")},
+		values.NewRecord("a", 1, "b c", "x"),
+	} {
+		e, err := Parse(Literal(v))
+		if err != nil {
+			t.Errorf("%s: %v", Literal(v), err)
+			continue
+		}
+		got, err := e.Eval(values.Nothing{})
+		if err != nil {
+			t.Errorf("%s: %v", Literal(v), err)
+			continue
+		}
+		if got.Kind() != v.Kind() || jsonOf(got) != jsonOf(v) {
+			t.Errorf("%s = %s %s, want %s %s", Literal(v), got.Kind(), jsonOf(got), v.Kind(), jsonOf(v))
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	sorted := []values.Value{
+		values.Nothing{},
+		values.Bool(false),
+		values.Bool(true),
+		values.Number(-1),
+		values.Number(2),
+		values.String("a"),
+		values.String("b"),
+	}
+	for i, a := range sorted {
+		for j, b := range sorted {
+			c := Order(a, b)
+			switch {
+			case i < j && c >= 0, i > j && c <= 0, i == j && c != 0:
+				t.Errorf("Order(%s, %s) = %d", jsonOf(a), jsonOf(b), c)
+			}
+		}
+	}
+	if c := Order(values.Filesize(1000), values.Number(999)); c <= 0 {
+		t.Errorf("Order(1kb, 999) = %d, want filesizes to compare with numbers", c)
+	}
+}
@@ -0,0 +1,462 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"netxp/values"
+)
+
+// Function is a builtin function of the expression language. The query
+// language of sql shares them.
+type Function struct {
+	Min, Max int // number of arguments; Max is -1 for any number
+	Fn       func(args []values.Value) (values.Value, error)
+}
+
+// Arity describes the number of arguments a function takes
+func (f *Function) Arity() string {
+	switch {
+	case f.Min == f.Max && f.Min == 1:
+		return "1 argument"
+	case f.Min == f.Max:
+		return fmt.Sprintf("%d arguments", f.Min)
+	case f.Max < 0:
+		return fmt.Sprintf("at least %d arguments", f.Min)
+	}
+	return fmt.Sprintf("%d to %d arguments", f.Min, f.Max)
+}
+
+// Functions are the builtin functions by name
+var Functions = map[string]*Function{
+	"len":         {1, 1, fnLen},
+	"lower":       {1, 1, stringFunc(strings.ToLower)},
+	"upper":       {1, 1, stringFunc(strings.ToUpper)},
+	"trim":        {1, 1, stringFunc(strings.TrimSpace)},
+	"contains":    {2, 2, stringTest(strings.Contains)},
+	"starts_with": {2, 2, stringTest(strings.HasPrefix)},
+	"ends_with":   {2, 2, stringTest(strings.HasSuffix)},
+	"type":        {1, 1, fnType},
+	"string":      {1, 1, fnString},
+	"number":      {1, 1, fnNumber},
+	"filesize":    {1, 1, fnFilesize},
+	"duration":    {1, 1, fnDuration},
+	"replace":     {3, 3, fnReplace},
+	"substr":      {2, 3, fnSubstr},
+	"split":       {2, 2, fnSplit},
+	"join":        {2, 2, fnJoin},
+	"round":       {1, 2, fnRound},
+	"floor":       {1, 1, mathFunc(math.Floor)},
+	"ceil":        {1, 1, mathFunc(math.Ceil)},
+	"abs":         {1, 1, mathFunc(math.Abs)},
+	"sqrt":        {1, 1, mathFunc(math.Sqrt)},
+	"pow":         {2, 2, fnPow},
+	"min":         {1, -1, extreme(-1)},
+	"max":         {1, -1, extreme(1)},
+	"now":         {0, 0, fnNow},
+	"date":        {1, 1, fnDate},
+	"format_date": {2, 2, fnFormatDate},
+	"year":        {1, 1, datePart(func(t time.Time) int { return t.Year() })},
+	"month":       {1, 1, datePart(func(t time.Time) int { return int(t.Month()) })},
+	"day":         {1, 1, datePart(func(t time.Time) int { return t.Day() })},
+	"hour":        {1, 1, datePart(func(t time.Time) int { return t.Hour() })},
+	"minute":      {1, 1, datePart(func(t time.Time) int { return t.Minute() })},
+	"weekday":     {1, 1, datePart(func(t time.Time) int { return int(t.Weekday()) })},
+	"unix":        {1, 1, datePart(func(t time.Time) int { return int(t.Unix()) })},
+	"age":         {1, 1, fnAge},
+}
+
+// stringArg returns argument i as a string
+func stringArg(args []values.Value, i int) (string, error) {
+	s, ok := args[i].(values.String)
+	if !ok {
+		return "", fmt.Errorf("argument %d must be a string, not %s", i+1, args[i].Kind())
+	}
+	return string(s), nil
+}
+
+// stringFunc lifts a string function; Nothing passes through
+func stringFunc(fn func(string) string) func([]values.Value) (values.Value, error) {
+	return func(args []values.Value) (values.Value, error) {
+		if values.IsNothing(args[0]) {
+			return values.Nothing{}, nil
+		}
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return values.String(fn(s)), nil
+	}
+}
+
+// stringTest lifts a string predicate; it is false for Nothing
+func stringTest(fn func(string, string) bool) func([]values.Value) (values.Value, error) {
+	return func(args []values.Value) (values.Value, error) {
+		if values.IsNothing(args[0]) {
+			return values.Bool(false), nil
+		}
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := stringArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return values.Bool(fn(s, sub)), nil
+	}
+}
+
+func fnLen(args []values.Value) (values.Value, error) {
+	switch x := args[0].(type) {
+	case values.Nothing:
+		return values.Number(0), nil
+	case values.String:
+		return values.Number(utf8.RuneCountInString(string(x))), nil
+	case values.Binary:
+		return values.Number(len(x)), nil
+	case values.List:
+		return values.Number(len(x)), nil
+	case *values.Table:
+		return values.Number(len(x.Rows)), nil
+	case *values.Record:
+		return values.Number(x.Len()), nil
+	}
+	return nil, fmt.Errorf("%s has no length", args[0].Kind())
+}
+
+func fnType(args []values.Value) (values.Value, error) {
+	return values.String(args[0].Kind().String()), nil
+}
+
+func fnString(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.Nothing{}, nil
+	}
+	return values.String(values.Text(args[0])), nil
+}
+
+func fnNumber(args []values.Value) (values.Value, error) {
+	switch x := args[0].(type) {
+	case values.Nothing, values.Number:
+		return x, nil
+	case values.Bool:
+		if x {
+			return values.Number(1), nil
+		}
+		return values.Number(0), nil
+	case values.Filesize:
+		return values.Number(x), nil
+	case values.Duration:
+		return values.Number(time.Duration(x).Seconds()), nil
+	case values.Datetime:
+		return values.Number(float64(x.Time().UnixNano()) / 1e9), nil
+	case values.String:
+		n, err := strconv.ParseFloat(strings.TrimSpace(string(x)), 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", string(x))
+		}
+		return values.Number(n), nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a number", args[0].Kind())
+}
+
+// numberArg returns argument i as a number
+func numberArg(args []values.Value, i int) (float64, error) {
+	n, ok := args[i].(values.Number)
+	if !ok {
+		return 0, fmt.Errorf("argument %d must be a number, not %s", i+1, args[i].Kind())
+	}
+	return float64(n), nil
+}
+
+func fnReplace(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.Nothing{}, nil
+	}
+	var strs [3]string
+	for i := range strs {
+		s, err := stringArg(args, i)
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = s
+	}
+	return values.String(strings.ReplaceAll(strs[0], strs[1], strs[2])), nil
+}
+
+// fnSubstr returns the runes from start, which counts from the end when
+// negative, up to an optional length
+func fnSubstr(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.Nothing{}, nil
+	}
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	start, err := numberArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(s)
+	from := int(start)
+	if from < 0 {
+		from += len(runes)
+	}
+	if from < 0 {
+		from = 0
+	}
+	if from > len(runes) {
+		from = len(runes)
+	}
+	to := len(runes)
+	if len(args) > 2 {
+		n, err := numberArg(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if from+int(n) < to {
+			to = from + int(n)
+		}
+	}
+	if to < from {
+		to = from
+	}
+	return values.String(runes[from:to]), nil
+}
+
+func fnSplit(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.List{}, nil
+	}
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	sep, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	var out values.List
+	for _, part := range strings.Split(s, sep) {
+		out = append(out, values.String(part))
+	}
+	return out, nil
+}
+
+func fnJoin(args []values.Value) (values.Value, error) {
+	sep, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for _, item := range values.Items(args[0]) {
+		parts = append(parts, values.Text(item))
+	}
+	return values.String(strings.Join(parts, sep)), nil
+}
+
+// mathFunc lifts a float function; Nothing passes through
+func mathFunc(fn func(float64) float64) func([]values.Value) (values.Value, error) {
+	return func(args []values.Value) (values.Value, error) {
+		if values.IsNothing(args[0]) {
+			return values.Nothing{}, nil
+		}
+		n, err := numberArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return values.Number(fn(n)), nil
+	}
+}
+
+// fnRound rounds to a number of decimal places, none by default
+func fnRound(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.Nothing{}, nil
+	}
+	n, err := numberArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	places := 0.0
+	if len(args) > 1 {
+		if places, err = numberArg(args, 1); err != nil {
+			return nil, err
+		}
+	}
+	scale := math.Pow(10, places)
+	return values.Number(math.Round(n*scale) / scale), nil
+}
+
+func fnPow(args []values.Value) (values.Value, error) {
+	x, err := numberArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	y, err := numberArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return values.Number(math.Pow(x, y)), nil
+}
+
+// extreme returns the smallest (sign -1) or largest (sign 1) of its
+// arguments, or of the items of a single list argument, in the order of
+// Order. Nulls are skipped.
+func extreme(sign int) func([]values.Value) (values.Value, error) {
+	return func(args []values.Value) (values.Value, error) {
+		if len(args) == 1 {
+			args = values.Items(args[0])
+		}
+		var best values.Value = values.Nothing{}
+		for _, v := range args {
+			if values.IsNothing(v) {
+				continue
+			}
+			if values.IsNothing(best) || Order(v, best)*sign > 0 {
+				best = v
+			}
+		}
+		return best, nil
+	}
+}
+
+// ParseTime reads a date from a datetime, from a string in one of the
+// common layouts or from a number of seconds since the Unix epoch
+func ParseTime(v values.Value) (time.Time, error) {
+	switch x := v.(type) {
+	case values.Datetime:
+		return x.Time(), nil
+	case values.Number:
+		sec, frac := math.Modf(float64(x))
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	case values.String:
+		t, err := values.ParseDatetime(string(x))
+		return t.Time(), err
+	}
+	return time.Time{}, fmt.Errorf("%s is not a date", v.Kind())
+}
+
+func fnNow(args []values.Value) (values.Value, error) {
+	return values.Datetime(time.Now()), nil
+}
+
+// fnDate converts a date string or Unix time to a datetime
+func fnDate(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.Nothing{}, nil
+	}
+	t, err := ParseTime(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return values.Datetime(t), nil
+}
+
+// fnFilesize converts a number of bytes or text such as "10 MB" to a
+// filesize
+func fnFilesize(args []values.Value) (values.Value, error) {
+	switch x := args[0].(type) {
+	case values.Nothing, values.Filesize:
+		return x, nil
+	case values.Number:
+		return values.Filesize(math.Round(float64(x))), nil
+	case values.String:
+		f, err := values.ParseFilesize(string(x))
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a filesize", args[0].Kind())
+}
+
+// fnDuration converts a number of seconds or text such as "2h 30m" to a
+// duration
+func fnDuration(args []values.Value) (values.Value, error) {
+	switch x := args[0].(type) {
+	case values.Nothing, values.Duration:
+		return x, nil
+	case values.Number:
+		return values.Duration(float64(x) * float64(time.Second)), nil
+	case values.String:
+		d, err := values.ParseDuration(string(x))
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a duration", args[0].Kind())
+}
+
+// datePart lifts a function of a date; Nothing passes through
+func datePart(fn func(time.Time) int) func([]values.Value) (values.Value, error) {
+	return func(args []values.Value) (values.Value, error) {
+		if values.IsNothing(args[0]) {
+			return values.Nothing{}, nil
+		}
+		t, err := ParseTime(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return values.Number(fn(t)), nil
+	}
+}
+
+// fnAge is the duration since a date
+func fnAge(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.Nothing{}, nil
+	}
+	t, err := ParseTime(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return values.Duration(time.Since(t)), nil
+}
+
+// strftime maps % directives to Go layout elements
+var strftime = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'H': "15", 'I': "03",
+	'M': "04", 'S': "05", 'p': "PM", 'b': "Jan", 'B': "January", 'a': "Mon",
+	'A': "Monday", 'Z': "MST", 'z': "-0700", 'j': "002", '%': "%",
+}
+
+// fnFormatDate formats a date with strftime directives such as %Y-%m-%d
+func fnFormatDate(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.Nothing{}, nil
+	}
+	t, err := ParseTime(args[0])
+	if err != nil {
+		return nil, err
+	}
+	format, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		layout, ok := strftime[format[i]]
+		if !ok {
+			return nil, fmt.Errorf("unknown directive %%%c", format[i])
+		}
+		if layout == "%" {
+			b.WriteByte('%')
+		} else {
+			b.WriteString(t.Format(layout))
+		}
+	}
+	return values.String(b.String()), nil
+}
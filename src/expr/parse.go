@@ -0,0 +1,642 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"netxp/values"
+)
+
+// Expr is a parsed expression that is evaluated against one row at a
+// time. Bare names refer to fields of the row and "it" to the row itself.
+type Expr struct {
+	root node
+	src  string
+}
+
+// SyntaxError reports an expression that cannot be parsed
+type SyntaxError struct {
+	Pos int // 1-based column within the expression
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.Pos, e.Msg)
+}
+
+type tokKind int
+
+const (
+	tEOF tokKind = iota
+	tNumber
+	tString
+	tIdent // a name; quoted with backticks when raw is set
+	tOp
+)
+
+type token struct {
+	kind tokKind
+	text string // operator, name or decoded string
+	num  float64
+	val  values.Value // a filesize, duration or datetime literal
+	raw  bool         // a `quoted` name, never a keyword
+	pos  int          // offset of the first rune
+	end  int          // offset after the last rune
+}
+
+// operators are matched longest first
+var operators = []string{
+	"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "??",
+	"<", ">", "!", "=", "(", ")", "[", "]", "{", "}", ",", ".", ":", "+", "-", "*", "/", "%",
+}
+
+// dateLiteral matches a datetime literal such as 2026-01-01 or
+// 2026-01-01T12:30:00Z
+var dateLiteral = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:\d{2})?)?`)
+
+// keywords cannot be used as bare field names; quote those with backticks
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "is": true,
+	"null": true, "true": true, "false": true,
+}
+
+// LexNumber reads the number literal that starts at src[i]: digits, a
+// fraction and an exponent unless index is set, as in items.0.1, and an
+// optional filesize or duration unit. It returns the end of the literal,
+// the number and, with a unit, the filesize or duration it makes.
+func LexNumber(src []rune, i int, index bool) (int, float64, values.Value, error) {
+	start := i
+	for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+		i++
+	}
+	if !index && i+1 < len(src) && src[i] == '.' && src[i+1] >= '0' && src[i+1] <= '9' {
+		i++
+		for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+			i++
+		}
+	}
+	if !index && i+1 < len(src) && (src[i] == 'e' || src[i] == 'E') && (src[i+1] >= '0' && src[i+1] <= '9' || src[i+1] == '-' || src[i+1] == '+') {
+		i += 2
+		for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+			i++
+		}
+	}
+	n, err := strconv.ParseFloat(string(src[start:i]), 64)
+	if err != nil {
+		return 0, 0, nil, &SyntaxError{Pos: start + 1, Msg: fmt.Sprintf("invalid number '%s'", string(src[start:i]))}
+	}
+	suffix := i
+	for suffix < len(src) && unicode.IsLetter(src[suffix]) {
+		suffix++
+	}
+	if suffix == i {
+		return i, n, nil, nil
+	}
+	unit := strings.ToLower(string(src[i:suffix]))
+	if scale, ok := values.SizeUnits[unit]; ok {
+		return suffix, n, values.Filesize(math.Round(n * scale)), nil
+	}
+	if scale, ok := values.DurationUnits[unit]; ok {
+		return suffix, n, values.Duration(n * float64(scale)), nil
+	}
+	return 0, 0, nil, &SyntaxError{Pos: i + 1, Msg: fmt.Sprintf("unknown unit '%s'", unit)}
+}
+
+func lex(src []rune) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c >= '0' && c <= '9':
+			// after a '.' a number is an index, as in items.0.1
+			afterDot := len(toks) > 0 && toks[len(toks)-1].text == "." && toks[len(toks)-1].kind == tOp
+			if m := dateLiteral.FindString(string(src[i:])); m != "" && !afterDot {
+				t, err := values.ParseDatetime(m)
+				if err != nil {
+					return nil, &SyntaxError{Pos: start + 1, Msg: fmt.Sprintf("invalid date '%s'", m)}
+				}
+				i += len(m)
+				toks = append(toks, token{kind: tNumber, val: t, pos: start, end: i})
+				continue
+			}
+			end, n, val, err := LexNumber(src, i, afterDot)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			toks = append(toks, token{kind: tNumber, num: n, val: val, pos: start, end: i})
+		case c == '"' || c == '\'':
+			text, next, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			toks = append(toks, token{kind: tString, text: text, pos: start, end: i})
+		case c == '`':
+			end := i + 1
+			for end < len(src) && src[end] != '`' {
+				end++
+			}
+			if end >= len(src) {
+				return nil, &SyntaxError{Pos: i + 1, Msg: "unterminated `"}
+			}
+			i = end + 1
+			toks = append(toks, token{kind: tIdent, text: string(src[start+1 : end]), raw: true, pos: start, end: i})
+		case c == '_' || unicode.IsLetter(c):
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(src[i]) || unicode.IsDigit(src[i])) {
+				i++
+			}
+			toks = append(toks, token{kind: tIdent, text: string(src[start:i]), pos: start, end: i})
+		default:
+			op := ""
+			rest := string(src[i:])
+			for _, o := range operators {
+				if strings.HasPrefix(rest, o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &SyntaxError{Pos: i + 1, Msg: fmt.Sprintf("unexpected '%c'", c)}
+			}
+			i += len([]rune(op))
+			toks = append(toks, token{kind: tOp, text: op, pos: start, end: i})
+		}
+	}
+	return append(toks, token{kind: tEOF, pos: len(src), end: len(src)}), nil
+}
+
+// lexString reads a quoted string. \n, \t, \r, \\, \uXXXX and escaped
+// quotes are decoded; any other backslash is kept, so regular expressions
+// such as "\.log$" can be written as they are.
+func lexString(src []rune, i int) (string, int, error) {
+	quote := src[i]
+	var b strings.Builder
+	for j := i + 1; j < len(src); j++ {
+		c := src[j]
+		if c == quote {
+			return b.String(), j + 1, nil
+		}
+		if c != '\\' || j+1 >= len(src) {
+			b.WriteRune(c)
+			continue
+		}
+		j++
+		switch e := src[j]; e {
+		case 'n':
+			b.WriteRune('\n')
+		case 't':
+			b.WriteRune('\t')
+		case 'r':
+			b.WriteRune('\r')
+		case '\\', '"', '\'', '/':
+			b.WriteRune(e)
+		case 'u':
+			if j+4 < len(src) {
+				if n, err := strconv.ParseUint(string(src[j+1:j+5]), 16, 32); err == nil {
+					b.WriteRune(rune(n))
+					j += 4
+					continue
+				}
+			}
+			b.WriteString(`\u`)
+		default:
+			b.WriteRune('\\')
+			b.WriteRune(e)
+		}
+	}
+	return "", 0, &SyntaxError{Pos: i + 1, Msg: fmt.Sprintf("unterminated %c", quote)}
+}
+
+// parser is a recursive descent parser over the tokens of an expression
+type parser struct {
+	src  []rune
+	toks []token
+	i    int
+}
+
+// Parse parses an expression
+func Parse(src string) (*Expr, error) {
+	runes := []rune(src)
+	toks, err := lex(runes)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: runes, toks: toks}
+	if p.peek().kind == tEOF {
+		return nil, &SyntaxError{Pos: 1, Msg: "empty expression"}
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tEOF {
+		return nil, p.unexpected(t)
+	}
+	return &Expr{root: root, src: src}, nil
+}
+
+// ParseLiteral reads a word that is a number, filesize, duration or
+// datetime literal on its own, such as 42, -1.5, 10mb, 90s or 2026-01-01.
+// Numbers with leading zeros, such as postal codes, are not literals.
+func ParseLiteral(s string) (values.Value, bool) {
+	digits := strings.TrimPrefix(s, "-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' && !dateLiteral.MatchString(digits) {
+		return nil, false
+	}
+	toks, err := lex([]rune(digits))
+	if err != nil || len(toks) != 2 || toks[0].kind != tNumber || toks[1].kind != tEOF {
+		return nil, false
+	}
+	t := toks[0]
+	neg := len(digits) < len(s)
+	switch x := t.val.(type) {
+	case nil:
+		if neg {
+			return values.Number(-t.num), true
+		}
+		return values.Number(t.num), true
+	case values.Filesize:
+		if neg {
+			x = -x
+		}
+		return x, true
+	case values.Duration:
+		if neg {
+			x = -x
+		}
+		return x, true
+	}
+	return t.val, !neg
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tEOF {
+		p.i++
+	}
+	return t
+}
+
+// isOp reports whether the next token is one of the operators or keywords
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tOp && (t.kind != tIdent || t.raw || !keywords[t.text]) {
+		return false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		t := p.peek()
+		if t.kind == tEOF {
+			return &SyntaxError{Pos: t.pos + 1, Msg: fmt.Sprintf("expected '%s' at end of expression", op)}
+		}
+		return &SyntaxError{Pos: t.pos + 1, Msg: fmt.Sprintf("expected '%s', found '%s'", op, p.text(t.pos, t.end))}
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tEOF {
+		return &SyntaxError{Pos: t.pos + 1, Msg: "unexpected end of expression"}
+	}
+	if t.text == "=" {
+		return &SyntaxError{Pos: t.pos + 1, Msg: "unexpected '=', use '==' to compare"}
+	}
+	return &SyntaxError{Pos: t.pos + 1, Msg: fmt.Sprintf("unexpected '%s'", p.text(t.pos, t.end))}
+}
+
+// text returns the source between two offsets
+func (p *parser) text(start, end int) string {
+	return string(p.src[start:end])
+}
+
+// span is the source of the tokens consumed since the one at start
+func (p *parser) span(start int) string {
+	return p.text(p.toks[start].pos, p.toks[p.i-1].end)
+}
+
+func (p *parser) parseOr() (node, error) {
+	start := p.i
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||", "or") {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "||", l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	start := p.i
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&", "and") {
+		p.next()
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "&&", l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	start := p.i
+	if p.isOp("!", "not") {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "!", x: x, text: p.span(start)}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	start := p.i
+	l, err := p.parseCoalesce()
+	if err != nil {
+		return nil, err
+	}
+	var op string
+	switch {
+	case p.isOp("==", "!=", "<", "<=", ">", ">=", "=~", "!~", "in"):
+		op = p.next().text
+	case p.isOp("not") && p.toks[p.i+1].kind == tIdent && p.toks[p.i+1].text == "in":
+		p.i += 2
+		op = "not in"
+	case p.isOp("is"):
+		p.next()
+		op = "is null"
+		if p.isOp("not") {
+			p.next()
+			op = "is not null"
+		}
+		if !p.isOp("null") {
+			return nil, p.unexpected(p.peek())
+		}
+		p.next()
+		return &unary{op: op, x: l, text: p.span(start)}, nil
+	default:
+		return l, nil
+	}
+	r, err := p.parseCoalesce()
+	if err != nil {
+		return nil, err
+	}
+	b := &binary{op: op, l: l, r: r, text: p.span(start)}
+	if op == "=~" || op == "!~" {
+		// compile literal patterns up front so mistakes show before any row
+		if lit, ok := r.(*literal); ok {
+			if s, ok := lit.v.(values.String); ok {
+				re, err := regexp.Compile(string(s))
+				if err != nil {
+					return nil, &SyntaxError{Pos: p.toks[start].pos + 1, Msg: fmt.Sprintf("invalid regex: %s", err)}
+				}
+				b.re = re
+			}
+		}
+	}
+	return b, nil
+}
+
+func (p *parser) parseCoalesce() (node, error) {
+	start := p.i
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("??") {
+		p.next()
+		r, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: "??", l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAdd() (node, error) {
+	start := p.i
+	l, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+", "-") {
+		op := p.next().text
+		r, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: op, l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseMul() (node, error) {
+	start := p.i
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*", "/", "%") {
+		op := p.next().text
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: op, l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	start := p.i
+	if p.isOp("-") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{op: "-", x: x, text: p.span(start)}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	start := p.i
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			t := p.next()
+			switch t.kind {
+			case tIdent:
+				x = &member{x: x, key: t.text, text: p.span(start)}
+			case tNumber:
+				x = &index{x: x, key: &literal{v: values.Number(t.num)}, text: p.span(start)}
+			default:
+				return nil, &SyntaxError{Pos: t.pos + 1, Msg: "expected a field name after '.'"}
+			}
+		case p.isOp("["):
+			p.next()
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &index{x: x, key: key, text: p.span(start)}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	start := p.i
+	t := p.next()
+	switch t.kind {
+	case tNumber:
+		if t.val != nil {
+			return &literal{v: t.val}, nil
+		}
+		return &literal{v: values.Number(t.num)}, nil
+	case tString:
+		return &literal{v: values.String(t.text)}, nil
+	case tIdent:
+		if !t.raw {
+			switch t.text {
+			case "true":
+				return &literal{v: values.Bool(true)}, nil
+			case "false":
+				return &literal{v: values.Bool(false)}, nil
+			case "null":
+				return &literal{v: values.Nothing{}}, nil
+			}
+			if keywords[t.text] {
+				return nil, p.unexpected(t)
+			}
+		}
+		if !t.raw && p.isOp("(") {
+			return p.parseCall(t, start)
+		}
+		return &field{name: t.text}, nil
+	case tOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			l := &listNode{}
+			for !p.isOp("]") {
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				l.items = append(l.items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return l, p.expect("]")
+		case "{":
+			r := &recordNode{}
+			for !p.isOp("}") {
+				k := p.next()
+				if k.kind != tIdent && k.kind != tString {
+					return nil, &SyntaxError{Pos: k.pos + 1, Msg: "expected a field name in record"}
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				v, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				r.keys = append(r.keys, k.text)
+				r.vals = append(r.vals, v)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return r, p.expect("}")
+		}
+	}
+	return nil, p.unexpected(t)
+}
+
+func (p *parser) parseCall(name token, start int) (node, error) {
+	fn, ok := Functions[name.text]
+	if !ok {
+		return nil, &SyntaxError{Pos: name.pos + 1, Msg: fmt.Sprintf("unknown function '%s'", name.text)}
+	}
+	p.next() // (
+	c := &call{name: name.text, fn: fn}
+	for !p.isOp(")") {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	c.text = p.span(start)
+	if len(c.args) < fn.Min || (fn.Max >= 0 && len(c.args) > fn.Max) {
+		return nil, &SyntaxError{Pos: name.pos + 1, Msg: fmt.Sprintf("%s() takes %s", name.text, fn.Arity())}
+	}
+	return c, nil
+}
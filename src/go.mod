@@ -0,0 +1,7 @@
+module netxp
+
+go 1.21
+
+require github.com/peterh/liner v1.2.2
+
+require github.com/mattn/go-runewidth v0.0.3 // indirect
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"netxp/cli"
+)
+
+const usage = `usage:
+  netxp                          start the interactive shell
+  netxp -c "<pipeline>"          run a command line and exit
+  netxp <script.nxp> [args...]   run a script with arguments
+  <input> | netxp                run a script read from stdin
+
+options:
+`
+
+func main() {
+	command := flag.String("c", "", "run a command line and exit")
+	format := flag.String("o", cli.FormatJSON, "output format when not interactive: json or pretty")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	commandSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "c" {
+			commandSet = true
+		}
+	})
+
+	if !commandSet && flag.NArg() == 0 && isTerminal(os.Stdin) {
+		sh := cli.NewShell()
+		defer sh.Close()
+		if err := sh.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sh, err := cli.NewBatchShell(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "netxp:", err)
+		os.Exit(2)
+	}
+	var status int
+	switch {
+	case commandSet:
+		var stdin io.Reader
+		if !isTerminal(os.Stdin) {
+			stdin = os.Stdin
+		}
+		status = sh.RunCommand(*command, stdin)
+	case flag.NArg() > 0:
+		status = sh.RunFile(flag.Arg(0), flag.Args()[1:])
+	default:
+		status = sh.RunReader(os.Stdin, "<stdin>")
+	}
+	os.Exit(status)
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -1,47 +1,56 @@
 package moduling
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"netxp/config"
 )
 
-// Run executes a module and returns JSON output
-func Run(cfg *config.Config, name string, args []string, input []byte) ([]byte, error) {
-	files, err := ioutil.ReadDir(cfg.ModulesDir)
+// ExecContext executes a module with the given standard streams and
+// kills it when ctx is cancelled
+func ExecContext(ctx context.Context, cfg *config.Config, name string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	target, err := Find(cfg, name)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	_ = os.Chmod(target, 0755)
+	cmd := exec.CommandContext(ctx, target, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Stdin = stdin
+	// don't wait on a stdin copy that is blocked on a slow producer
+	cmd.WaitDelay = 100 * time.Millisecond
+	err = cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		return nil
+	}
+	return err
+}
 
-	var target string
+// Find returns the path of the module matching name (prefix match allowed)
+func Find(cfg *config.Config, name string) (string, error) {
+	files, err := ioutil.ReadDir(cfg.ModulesDir)
+	if err != nil {
+		return "", err
+	}
 	for _, f := range files {
 		if f.IsDir() {
 			continue
 		}
 		if strings.HasPrefix(f.Name(), name) || f.Name() == name {
-			target = filepath.Join(cfg.ModulesDir, f.Name())
-			break
+			return filepath.Join(cfg.ModulesDir, f.Name()), nil
 		}
 	}
-	if target == "" {
-		return nil, fmt.Errorf("module not found: %s", name)
-	}
-
-	_ = os.Chmod(target, 0755)
-	cmd := exec.Command(target)
-	cmd.Args = append(cmd.Args, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
-	return []byte{}, nil
+	return "", fmt.Errorf("module not found: %s", name)
 }
 
 // Create creates a new module from template
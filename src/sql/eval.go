@@ -0,0 +1,492 @@
+package sql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+// node is an element of a parsed expression
+type node interface {
+	eval(e *env) (values.Value, error)
+}
+
+// env is what an expression is evaluated against: a row of every table
+// of the FROM clause, nil for the missing side of a LEFT JOIN, and the
+// rows of the group when aggregating
+type env struct {
+	row   []*values.Record
+	group [][]*values.Record
+}
+
+type literal struct{ v values.Value }
+
+// column is a column of one of the tables; src is filled in when the
+// query is bound to its tables
+type column struct {
+	table, name string
+	text        string
+	src         int
+}
+
+type logic struct {
+	op   string // AND or OR
+	l, r node
+	text string
+}
+
+type not struct {
+	x    node
+	text string
+}
+
+type compare struct {
+	op   string
+	l, r node
+	text string
+}
+
+type isNull struct {
+	x   node
+	not bool
+}
+
+type inList struct {
+	x    node
+	list []node
+	not  bool
+}
+
+type like struct {
+	x, pattern node
+	not        bool
+	text       string
+	re         *regexp.Regexp
+	src        string // the pattern re was compiled from
+}
+
+type between struct {
+	x, lo, hi node
+	not       bool
+	text      string
+}
+
+type binary struct {
+	op   string
+	l, r node
+	text string
+}
+
+type caseNode struct {
+	operand node
+	whens   []node
+	thens   []node
+	els     node
+	text    string
+}
+
+type call struct {
+	name string
+	fn   *expr.Function
+	args []node
+	text string
+}
+
+type aggregate struct {
+	name     string
+	fn       *aggFunc
+	args     []node
+	star     bool
+	distinct bool
+	text     string
+}
+
+func (n *literal) eval(*env) (values.Value, error) {
+	return n.v, nil
+}
+
+func (n *column) eval(e *env) (values.Value, error) {
+	if n.src < 0 || n.src >= len(e.row) {
+		return nil, fmt.Errorf("column '%s' is not available here", n.text)
+	}
+	r := e.row[n.src]
+	if r == nil {
+		return values.Nothing{}, nil
+	}
+	if v, ok := r.Get(n.name); ok && v != nil {
+		return v, nil
+	}
+	return values.Nothing{}, nil
+}
+
+// truth is the value of a condition. Null is unknown, which is false in
+// the end; numbers are true when they are not zero.
+func truth(v values.Value, text string) (values.Value, error) {
+	if n, ok := v.(values.Number); ok {
+		return values.Bool(n != 0), nil
+	}
+	return expr.Truth(v, text)
+}
+
+// holds reports whether a condition is true
+func holds(n node, e *env, text string) (bool, error) {
+	v, err := n.eval(e)
+	if err != nil {
+		return false, err
+	}
+	v, err = truth(v, text)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(values.Bool)
+	return ok && bool(b), nil
+}
+
+// logic uses three-valued logic: false AND null is false, true OR null is
+// true and otherwise null makes null
+func (n *logic) eval(e *env) (values.Value, error) {
+	l, err := n.l.eval(e)
+	if err == nil {
+		l, err = truth(l, n.text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	decisive := values.Bool(n.op == "OR")
+	if l == decisive {
+		return l, nil
+	}
+	r, err := n.r.eval(e)
+	if err == nil {
+		r, err = truth(r, n.text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r == decisive {
+		return r, nil
+	}
+	if values.IsNothing(l) || values.IsNothing(r) {
+		return values.Nothing{}, nil
+	}
+	return !decisive, nil
+}
+
+func (n *not) eval(e *env) (values.Value, error) {
+	v, err := n.x.eval(e)
+	if err == nil {
+		v, err = truth(v, n.text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := v.(values.Bool); ok {
+		return !b, nil
+	}
+	return v, nil
+}
+
+func (n *compare) eval(e *env) (values.Value, error) {
+	l, err := n.l.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.r.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if values.IsNothing(l) || values.IsNothing(r) {
+		return values.Nothing{}, nil
+	}
+	switch n.op {
+	case "=":
+		return values.Bool(expr.Equal(l, r)), nil
+	case "!=":
+		return values.Bool(!expr.Equal(l, r)), nil
+	}
+	c, err := cmp(l, r, n.text)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return values.Bool(c < 0), nil
+	case "<=":
+		return values.Bool(c <= 0), nil
+	case ">":
+		return values.Bool(c > 0), nil
+	}
+	return values.Bool(c >= 0), nil
+}
+
+// cmp orders two values for < and >. Bools order false first.
+func cmp(a, b values.Value, text string) (int, error) {
+	if x, ok := a.(values.Bool); ok {
+		if y, ok := b.(values.Bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case !bool(x):
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	c, err := expr.Compare(a, b)
+	if err != nil {
+		return 0, fmt.Errorf("%s in '%s'", err, text)
+	}
+	return c, nil
+}
+
+func (n *isNull) eval(e *env) (values.Value, error) {
+	v, err := n.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	return values.Bool(values.IsNothing(v) != n.not), nil
+}
+
+func (n *inList) eval(e *env) (values.Value, error) {
+	x, err := n.x.eval(e)
+	if err != nil || values.IsNothing(x) {
+		return values.Nothing{}, err
+	}
+	sawNull := false
+	for _, item := range n.list {
+		v, err := item.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if values.IsNothing(v) {
+			sawNull = true
+			continue
+		}
+		if expr.Equal(x, v) {
+			return values.Bool(!n.not), nil
+		}
+	}
+	if sawNull {
+		return values.Nothing{}, nil
+	}
+	return values.Bool(n.not), nil
+}
+
+// likePattern turns a LIKE pattern, where % matches any text and _ one
+// character, into a case-insensitive regular expression
+func likePattern(p string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, c := range p {
+		switch c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func (n *like) eval(e *env) (values.Value, error) {
+	x, err := n.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	p, err := n.pattern.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if values.IsNothing(x) || values.IsNothing(p) {
+		return values.Nothing{}, nil
+	}
+	pattern := values.Text(p)
+	if n.re == nil || n.src != pattern {
+		if n.re, err = likePattern(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern in '%s': %s", n.text, err)
+		}
+		n.src = pattern
+	}
+	return values.Bool(n.re.MatchString(values.Text(x)) != n.not), nil
+}
+
+func (n *between) eval(e *env) (values.Value, error) {
+	var v [3]values.Value
+	for i, x := range []node{n.x, n.lo, n.hi} {
+		var err error
+		if v[i], err = x.eval(e); err != nil {
+			return nil, err
+		}
+		if values.IsNothing(v[i]) {
+			return values.Nothing{}, nil
+		}
+	}
+	lo, err := cmp(v[0], v[1], n.text)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := cmp(v[0], v[2], n.text)
+	if err != nil {
+		return nil, err
+	}
+	return values.Bool((lo >= 0 && hi <= 0) != n.not), nil
+}
+
+// binary is arithmetic, typed the way netxp expressions are, and ||
+// which joins text
+func (n *binary) eval(e *env) (values.Value, error) {
+	l, err := n.l.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.r.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if values.IsNothing(l) || values.IsNothing(r) {
+		return values.Nothing{}, nil
+	}
+	if n.op == "||" {
+		return values.String(values.Text(l) + values.Text(r)), nil
+	}
+	return expr.Arith(n.op, l, r, n.text)
+}
+
+func (n *caseNode) eval(e *env) (values.Value, error) {
+	var operand values.Value
+	if n.operand != nil {
+		v, err := n.operand.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		operand = v
+	}
+	for i, when := range n.whens {
+		var match bool
+		if operand != nil {
+			w, err := when.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			match = !values.IsNothing(operand) && expr.Equal(operand, w)
+		} else {
+			var err error
+			if match, err = holds(when, e, n.text); err != nil {
+				return nil, err
+			}
+		}
+		if match {
+			return n.thens[i].eval(e)
+		}
+	}
+	if n.els != nil {
+		return n.els.eval(e)
+	}
+	return values.Nothing{}, nil
+}
+
+func (n *call) eval(e *env) (values.Value, error) {
+	args := make([]values.Value, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn.Fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s in '%s'", err, n.text)
+	}
+	return v, nil
+}
+
+// aggregate reduces the values of its argument over the rows of the
+// group; nulls are skipped
+func (n *aggregate) eval(e *env) (values.Value, error) {
+	if e.group == nil {
+		return nil, fmt.Errorf("%s is only allowed in SELECT, HAVING and ORDER BY", n.text)
+	}
+	if n.star {
+		return values.Number(len(e.group)), nil
+	}
+	var vals []values.Value
+	seen := make(map[string]bool)
+	for _, row := range e.group {
+		v, err := n.args[0].eval(&env{row: row})
+		if err != nil {
+			return nil, err
+		}
+		if values.IsNothing(v) {
+			continue
+		}
+		if n.distinct {
+			k := values.Identity(v)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		vals = append(vals, v)
+	}
+	extra := make([]values.Value, len(n.args)-1)
+	for i, a := range n.args[1:] {
+		v, err := a.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		extra[i] = v
+	}
+	v, err := n.fn.fn(vals, extra)
+	if err != nil {
+		return nil, fmt.Errorf("%s in '%s'", err, n.text)
+	}
+	return v, nil
+}
+
+// walk calls fn for n and every node below it
+func walk(n node, fn func(node) error) error {
+	if n == nil {
+		return nil
+	}
+	if err := fn(n); err != nil {
+		return err
+	}
+	var children []node
+	switch x := n.(type) {
+	case *logic:
+		children = []node{x.l, x.r}
+	case *not:
+		children = []node{x.x}
+	case *compare:
+		children = []node{x.l, x.r}
+	case *isNull:
+		children = []node{x.x}
+	case *inList:
+		children = append([]node{x.x}, x.list...)
+	case *like:
+		children = []node{x.x, x.pattern}
+	case *between:
+		children = []node{x.x, x.lo, x.hi}
+	case *binary:
+		children = []node{x.l, x.r}
+	case *caseNode:
+		children = append(append([]node{x.operand, x.els}, x.whens...), x.thens...)
+	case *call:
+		children = x.args
+	case *aggregate:
+		children = x.args
+	}
+	for _, c := range children {
+		if err := walk(c, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
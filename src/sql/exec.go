@@ -0,0 +1,460 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+// ColumnError reports a column the query names that no table has, or
+// that more than one has
+type ColumnError struct {
+	Msg     string
+	Columns []string // the columns that could be meant
+}
+
+func (e *ColumnError) Error() string { return e.Msg }
+
+// Opener returns the value of a table named in FROM or JOIN
+type Opener func(name string) (values.Value, error)
+
+// Run parses a query and executes it
+func Run(src string, open Opener) (*values.Table, error) {
+	q, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return q.Exec(open)
+}
+
+// source is a table of the FROM clause with its rows
+type source struct {
+	alias string
+	cols  []string
+	rows  []*values.Record
+}
+
+// tableRows turns a value into rows: a table or list of records as it
+// is, a record as one row and anything else as rows of one "value"
+// column
+func tableRows(v values.Value) ([]*values.Record, []string) {
+	if t, ok := v.(*values.Table); ok {
+		return t.Rows, t.Columns
+	}
+	var rows []*values.Record
+	for _, item := range values.Items(values.Unwrap(v)) {
+		r, ok := item.(*values.Record)
+		if !ok {
+			r = values.NewRecord("value", item)
+		}
+		rows = append(rows, r)
+	}
+	return rows, values.NewTable(rows).Columns
+}
+
+// Exec runs the query against the tables open returns
+func (q *Query) Exec(open Opener) (*values.Table, error) {
+	var sources []*source
+	for _, ref := range q.from {
+		for _, s := range sources {
+			if strings.EqualFold(s.alias, ref.alias) {
+				return nil, &SyntaxError{Pos: ref.pos + 1, Msg: fmt.Sprintf("table name '%s' is used twice; give one an alias with AS", ref.alias)}
+			}
+		}
+		v, err := open(ref.name)
+		if err != nil {
+			return nil, err
+		}
+		rows, cols := tableRows(v)
+		sources = append(sources, &source{alias: ref.alias, cols: cols, rows: rows})
+	}
+	b := &binder{sources: sources}
+	out, err := b.bindQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// every combination of rows the joins keep
+	combos := [][]*values.Record{{}}
+	if len(sources) > 0 {
+		combos = combos[:0]
+		for _, r := range sources[0].rows {
+			combos = append(combos, []*values.Record{r})
+		}
+	}
+	for i := 1; i < len(sources); i++ {
+		ref := q.from[i]
+		var next [][]*values.Record
+		for _, left := range combos {
+			matched := false
+			for _, r := range sources[i].rows {
+				row := append(left[:len(left):len(left)], r)
+				if ref.on != nil {
+					ok, err := holds(ref.on, &env{row: row}, "ON")
+					if err != nil {
+						return nil, err
+					}
+					if !ok {
+						continue
+					}
+				}
+				matched = true
+				next = append(next, row)
+			}
+			if !matched && ref.join == "left" {
+				next = append(next, append(left[:len(left):len(left)], nil))
+			}
+		}
+		combos = next
+	}
+
+	if q.where != nil {
+		kept := combos[:0]
+		for _, row := range combos {
+			ok, err := holds(q.where, &env{row: row}, "WHERE")
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				kept = append(kept, row)
+			}
+		}
+		combos = kept
+	}
+
+	var envs []*env
+	if b.grouped || len(q.groupBy) > 0 || q.having != nil {
+		groups, err := group(combos, q.groupBy)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			e := &env{group: g, row: make([]*values.Record, len(sources))}
+			if len(g) > 0 {
+				e.row = g[0]
+			}
+			if q.having != nil {
+				ok, err := holds(q.having, e, "HAVING")
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			envs = append(envs, e)
+		}
+	} else {
+		for _, row := range combos {
+			envs = append(envs, &env{row: row})
+		}
+	}
+
+	type result struct {
+		rec  *values.Record
+		keys []values.Value
+	}
+	results := make([]result, 0, len(envs))
+	for _, e := range envs {
+		rec := values.NewRecord()
+		vals := make([]values.Value, len(out))
+		for i, o := range out {
+			v, err := o.x.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+			rec.Set(o.name, v)
+		}
+		keys := make([]values.Value, len(q.orderBy))
+		for i, o := range q.orderBy {
+			if o.out >= 0 {
+				keys[i] = vals[o.out]
+				continue
+			}
+			v, err := o.x.eval(e)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = v
+		}
+		results = append(results, result{rec, keys})
+	}
+	if len(q.orderBy) > 0 {
+		sort.SliceStable(results, func(i, j int) bool {
+			for k, o := range q.orderBy {
+				c := expr.Order(results[i].keys[k], results[j].keys[k])
+				if o.desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	t := &values.Table{Rows: []*values.Record{}}
+	for _, o := range out {
+		t.Columns = append(t.Columns, o.name)
+	}
+	seen := make(map[string]bool)
+	skipped := 0
+	for _, r := range results {
+		if q.distinct {
+			k := values.Text(r.rec)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		if skipped < q.offset {
+			skipped++
+			continue
+		}
+		if q.limit >= 0 && len(t.Rows) >= q.limit {
+			break
+		}
+		t.Rows = append(t.Rows, r.rec)
+	}
+	return t, nil
+}
+
+// group splits rows by the values of the GROUP BY expressions, in order
+// of first appearance. Without GROUP BY all rows, even none, are one
+// group.
+func group(rows [][]*values.Record, by []node) ([][][]*values.Record, error) {
+	if len(by) == 0 {
+		return [][][]*values.Record{rows}, nil
+	}
+	var groups [][][]*values.Record
+	index := make(map[string]int)
+	for _, row := range rows {
+		var key strings.Builder
+		for _, x := range by {
+			v, err := x.eval(&env{row: row})
+			if err != nil {
+				return nil, err
+			}
+			key.WriteString(values.Identity(v))
+			key.WriteByte(1)
+		}
+		i, ok := index[key.String()]
+		if !ok {
+			i = len(groups)
+			index[key.String()] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], row)
+	}
+	return groups, nil
+}
+
+// output is a column of the result
+type output struct {
+	name string
+	x    node
+}
+
+// binder resolves the columns of a query against its tables
+type binder struct {
+	sources []*source
+	grouped bool // the query uses aggregates
+}
+
+// bindQuery resolves every expression of the query and returns its output
+// columns, with * expanded
+func (b *binder) bindQuery(q *Query) ([]output, error) {
+	for i, ref := range q.from {
+		if err := b.bind(ref.on, i+1, "ON"); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.bind(q.where, len(b.sources), "WHERE"); err != nil {
+		return nil, err
+	}
+
+	var out []output
+	taken := make(map[string]bool)
+	add := func(name, qualified string, x node) {
+		if taken[name] && qualified != "" {
+			name = qualified
+		}
+		base := name
+		for n := 2; taken[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		taken[name] = true
+		out = append(out, output{name: name, x: x})
+	}
+	for _, item := range q.items {
+		if item.star {
+			found := false
+			for i, s := range b.sources {
+				if item.table != "" && !strings.EqualFold(item.table, s.alias) {
+					continue
+				}
+				found = true
+				for _, c := range s.cols {
+					add(c, s.alias+"."+c, &column{table: s.alias, name: c, text: c, src: i})
+				}
+			}
+			switch {
+			case len(b.sources) == 0:
+				return nil, fmt.Errorf("'%s' needs a table: add FROM input", item.text)
+			case !found:
+				return nil, fmt.Errorf("no table '%s' for '%s'", item.table, item.text)
+			}
+			continue
+		}
+		if err := b.bind(item.x, len(b.sources), ""); err != nil {
+			return nil, err
+		}
+		name, qualified := item.alias, ""
+		if name == "" {
+			name = item.text
+			if c, ok := item.x.(*column); ok {
+				name, qualified = c.name, b.sources[c.src].alias+"."+c.name
+			}
+		}
+		add(name, qualified, item.x)
+	}
+
+	// GROUP BY and ORDER BY may name an output column or its position
+	alias := func(x node) (int, bool) {
+		switch c := x.(type) {
+		case *column:
+			if c.table != "" {
+				return 0, false
+			}
+			for i, item := range q.items {
+				if item.alias != "" && strings.EqualFold(item.alias, c.name) {
+					return i, true
+				}
+			}
+		case *literal:
+			if n, ok := c.v.(values.Number); ok && n >= 1 && int(n) <= len(out) && float64(int(n)) == float64(n) {
+				return int(n) - 1, true
+			}
+		}
+		return 0, false
+	}
+	for i, x := range q.groupBy {
+		if c, ok := x.(*column); ok && b.resolve(c, len(b.sources)) == nil {
+			continue
+		}
+		if j, ok := alias(x); ok && j < len(q.items) && !q.items[j].star {
+			q.groupBy[i] = q.items[j].x
+			continue
+		}
+		if err := b.bind(x, len(b.sources), "GROUP BY"); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.bind(q.having, len(b.sources), ""); err != nil {
+		return nil, err
+	}
+	for _, o := range q.orderBy {
+		if j, ok := alias(o.x); ok {
+			if _, isLiteral := o.x.(*literal); isLiteral {
+				o.out = j
+				continue
+			}
+			o.x = q.items[j].x
+		}
+		if err := b.bind(o.x, len(b.sources), ""); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// bind resolves the columns of an expression against the first n
+// tables. clause names a clause where aggregates are not allowed.
+func (b *binder) bind(x node, n int, clause string) error {
+	return walk(x, func(c node) error {
+		switch c := c.(type) {
+		case *column:
+			return b.resolve(c, n)
+		case *aggregate:
+			if clause != "" {
+				return fmt.Errorf("%s is not allowed in %s", c.text, clause)
+			}
+			b.grouped = true
+			for _, a := range c.args {
+				if err := walk(a, func(inner node) error {
+					if in, ok := inner.(*aggregate); ok {
+						return fmt.Errorf("%s cannot be nested in %s", in.text, c.text)
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// resolve finds the table of a column. Names match exactly or, failing
+// that, ignoring case; a name more than one table has must be qualified.
+func (b *binder) resolve(c *column, n int) error {
+	if c.src >= 0 {
+		return nil
+	}
+	var candidates []int
+	var available []string
+	for i, s := range b.sources[:n] {
+		if c.table != "" && !strings.EqualFold(c.table, s.alias) {
+			continue
+		}
+		candidates = append(candidates, i)
+		available = append(available, s.cols...)
+	}
+	if c.table != "" && len(candidates) == 0 {
+		var names []string
+		for _, s := range b.sources[:n] {
+			names = append(names, s.alias)
+		}
+		return &ColumnError{Msg: fmt.Sprintf("no table '%s' for '%s'", c.table, c.text), Columns: names}
+	}
+	for _, fold := range []bool{false, true} {
+		var found []int
+		name := c.name
+		for _, i := range candidates {
+			for _, col := range b.sources[i].cols {
+				if col == c.name || fold && strings.EqualFold(col, c.name) {
+					found = append(found, i)
+					name = col
+					break
+				}
+			}
+		}
+		switch {
+		case len(found) == 1:
+			c.src, c.name = found[0], name
+			return nil
+		case len(found) > 1:
+			var names []string
+			for _, i := range found {
+				names = append(names, b.sources[i].alias+"."+c.name)
+			}
+			return &ColumnError{Msg: fmt.Sprintf("column '%s' is ambiguous", c.text), Columns: names}
+		}
+	}
+	// a table without rows has no known columns; its columns are null
+	for _, i := range candidates {
+		if len(b.sources[i].rows) == 0 {
+			c.src = i
+			return nil
+		}
+	}
+	if len(b.sources) == 0 {
+		return fmt.Errorf("no column '%s': the query has no FROM", c.text)
+	}
+	return &ColumnError{Msg: fmt.Sprintf("no column '%s'", c.text), Columns: available}
+}
@@ -0,0 +1,183 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+// functions are the scalar functions of the query language: those of
+// the expression language, with the SQL names and behavior of length,
+// substr and typeof, and the null functions of SQL
+var functions = queryFunctions()
+
+func queryFunctions() map[string]*expr.Function {
+	fns := make(map[string]*expr.Function, len(expr.Functions)+7)
+	for name, f := range expr.Functions {
+		fns[name] = f
+	}
+	for name, f := range map[string]*expr.Function{
+		"length":    {Min: 1, Max: 1, Fn: fnLength},
+		"coalesce":  {Min: 1, Max: -1, Fn: fnCoalesce},
+		"ifnull":    {Min: 2, Max: 2, Fn: fnCoalesce},
+		"nullif":    {Min: 2, Max: 2, Fn: fnNullif},
+		"substr":    {Min: 2, Max: 3, Fn: fnSubstr},
+		"substring": {Min: 2, Max: 3, Fn: fnSubstr},
+		"typeof":    {Min: 1, Max: 1, Fn: fnTypeof},
+	} {
+		fns[name] = f
+	}
+	return fns
+}
+
+func fnLength(args []values.Value) (values.Value, error) {
+	switch x := args[0].(type) {
+	case values.Nothing:
+		return x, nil
+	case values.List:
+		return values.Number(len(x)), nil
+	case *values.Table:
+		return values.Number(len(x.Rows)), nil
+	}
+	return values.Number(utf8.RuneCountInString(values.Text(args[0]))), nil
+}
+
+// fnCoalesce returns the first argument that is not null
+func fnCoalesce(args []values.Value) (values.Value, error) {
+	for _, a := range args {
+		if !values.IsNothing(a) {
+			return a, nil
+		}
+	}
+	return values.Nothing{}, nil
+}
+
+func fnNullif(args []values.Value) (values.Value, error) {
+	if expr.Equal(args[0], args[1]) {
+		return values.Nothing{}, nil
+	}
+	return args[0], nil
+}
+
+// fnSubstr is the substr of the expression language with the 1-based
+// start of SQL, where a start of 0 is the position before the first
+// character
+func fnSubstr(args []values.Value) (values.Value, error) {
+	substr := expr.Functions["substr"].Fn
+	start, ok := args[1].(values.Number)
+	if !ok {
+		return substr(args)
+	}
+	shifted := append([]values.Value(nil), args...)
+	switch {
+	case start > 0:
+		shifted[1] = start - 1
+	case start == 0 && len(args) > 2:
+		if n, ok := args[2].(values.Number); ok {
+			shifted[2] = n - 1
+		}
+	}
+	return substr(shifted)
+}
+
+func fnTypeof(args []values.Value) (values.Value, error) {
+	if values.IsNothing(args[0]) {
+		return values.String("null"), nil
+	}
+	return values.String(args[0].Kind().String()), nil
+}
+
+// aggFunc reduces the non-null values of a group. extra holds the
+// arguments after the first, such as the separator of group_concat.
+type aggFunc struct {
+	max int
+	fn  func(vals, extra []values.Value) (values.Value, error)
+}
+
+func (a *aggFunc) arity() string {
+	if a.max == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("1 to %d arguments", a.max)
+}
+
+var aggregates = map[string]*aggFunc{
+	"count":        {1, aggCount},
+	"sum":          {1, aggSum},
+	"total":        {1, aggTotal},
+	"avg":          {1, aggAvg},
+	"min":          {1, overValues(expr.Functions["min"])},
+	"max":          {1, overValues(expr.Functions["max"])},
+	"group_concat": {2, aggConcat},
+}
+
+func aggCount(vals, _ []values.Value) (values.Value, error) {
+	return values.Number(len(vals)), nil
+}
+
+// aggSum adds the values the way + does, so filesizes and durations keep
+// their unit. The sum of no values is null.
+func aggSum(vals, _ []values.Value) (values.Value, error) {
+	if len(vals) == 0 {
+		return values.Nothing{}, nil
+	}
+	for _, v := range vals {
+		switch v.(type) {
+		case values.Number, values.Filesize, values.Duration:
+		default:
+			return nil, fmt.Errorf("cannot add up %s values", v.Kind())
+		}
+	}
+	sum := vals[0]
+	for _, v := range vals[1:] {
+		var err error
+		if sum, err = expr.Arith("+", sum, v, "sum"); err != nil {
+			return nil, err
+		}
+	}
+	return sum, nil
+}
+
+// aggTotal is sum, but 0 for no values
+func aggTotal(vals, extra []values.Value) (values.Value, error) {
+	if len(vals) == 0 {
+		return values.Number(0), nil
+	}
+	return aggSum(vals, extra)
+}
+
+func aggAvg(vals, extra []values.Value) (values.Value, error) {
+	sum, err := aggSum(vals, extra)
+	if err != nil || values.IsNothing(sum) {
+		return sum, err
+	}
+	return expr.Arith("/", sum, values.Number(len(vals)), "avg")
+}
+
+// overValues applies a function of the expression language to the
+// values of a group
+func overValues(f *expr.Function) func(vals, extra []values.Value) (values.Value, error) {
+	return func(vals, _ []values.Value) (values.Value, error) {
+		return f.Fn([]values.Value{values.List(vals)})
+	}
+}
+
+// aggConcat joins the values as text, with commas unless a separator is
+// given
+func aggConcat(vals, extra []values.Value) (values.Value, error) {
+	if len(vals) == 0 {
+		return values.Nothing{}, nil
+	}
+	sep := ","
+	if len(extra) > 0 && !values.IsNothing(extra[0]) {
+		sep = values.Text(extra[0])
+	}
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		parts[i] = values.Text(v)
+	}
+	return values.String(strings.Join(parts, sep)), nil
+}
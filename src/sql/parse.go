@@ -0,0 +1,842 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"netxp/expr"
+	"netxp/values"
+)
+
+// SyntaxError reports a query that cannot be parsed
+type SyntaxError struct {
+	Pos int // 1-based column within the query
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.Pos, e.Msg)
+}
+
+type tokKind int
+
+const (
+	tEOF tokKind = iota
+	tNumber
+	tString
+	tIdent // a name; "quoted" or `quoted` when raw is set
+	tOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	num  float64
+	val  values.Value // a filesize or duration
+	raw  bool         // a quoted name, never a keyword
+	pos  int
+	end  int
+}
+
+// operators are matched longest first
+var operators = []string{
+	"<=", ">=", "<>", "!=", "==", "||",
+	"<", ">", "=", "(", ")", ",", ".", "*", "+", "-", "/", "%", ";",
+}
+
+// keywords cannot be used as bare names or aliases; quote those
+var keywords = map[string]bool{
+	"SELECT": true, "DISTINCT": true, "FROM": true, "WHERE": true, "GROUP": true, "BY": true,
+	"HAVING": true, "ORDER": true, "ASC": true, "DESC": true, "LIMIT": true, "OFFSET": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "OUTER": true, "CROSS": true, "ON": true,
+	"AS": true, "AND": true, "OR": true, "NOT": true, "IN": true, "IS": true, "NULL": true,
+	"LIKE": true, "BETWEEN": true, "CASE": true, "WHEN": true, "THEN": true, "ELSE": true,
+	"END": true, "TRUE": true, "FALSE": true, "UNION": true, "RIGHT": true, "FULL": true,
+}
+
+func lex(src []rune) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '-' && i+1 < len(src) && src[i+1] == '-':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			// numbers and their units are those of netxp expressions
+			end, n, val, err := expr.LexNumber(src, i, false)
+			var se *expr.SyntaxError
+			if errors.As(err, &se) {
+				return nil, &SyntaxError{Pos: se.Pos, Msg: se.Msg}
+			}
+			i = end
+			toks = append(toks, token{kind: tNumber, num: n, val: val, pos: start, end: i})
+		case c == '\'' || c == '"' || c == '`':
+			// quotes are doubled inside; single quotes make a string and
+			// the others a name
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(src) {
+					return nil, &SyntaxError{Pos: i + 1, Msg: fmt.Sprintf("unterminated %c", c)}
+				}
+				if src[j] == c {
+					if j+1 < len(src) && src[j+1] == c {
+						b.WriteRune(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteRune(src[j])
+				j++
+			}
+			i = j + 1
+			if c == '\'' {
+				toks = append(toks, token{kind: tString, text: b.String(), pos: start, end: i})
+			} else {
+				toks = append(toks, token{kind: tIdent, text: b.String(), raw: true, pos: start, end: i})
+			}
+		case c == '_' || unicode.IsLetter(c):
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(src[i]) || unicode.IsDigit(src[i])) {
+				i++
+			}
+			toks = append(toks, token{kind: tIdent, text: string(src[start:i]), pos: start, end: i})
+		default:
+			op := ""
+			rest := string(src[i:])
+			for _, o := range operators {
+				if strings.HasPrefix(rest, o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &SyntaxError{Pos: i + 1, Msg: fmt.Sprintf("unexpected '%c'", c)}
+			}
+			i += len(op)
+			toks = append(toks, token{kind: tOp, text: op, pos: start, end: i})
+		}
+	}
+	return append(toks, token{kind: tEOF, pos: len(src), end: len(src)}), nil
+}
+
+// Query is a parsed SELECT statement
+type Query struct {
+	distinct bool
+	items    []*selectItem
+	from     []*tableRef
+	where    node
+	groupBy  []node
+	having   node
+	orderBy  []*orderItem
+	limit    int // -1 for no limit
+	offset   int
+	src      string
+}
+
+// selectItem is one output column, or all columns of a table for *
+type selectItem struct {
+	x     node
+	alias string
+	text  string
+	star  bool
+	table string // qualifier of t.*
+}
+
+// tableRef is a table of the FROM clause and how it joins the ones
+// before it
+type tableRef struct {
+	name  string // input, or the path of a file
+	alias string
+	join  string // "", "inner", "left" or "cross"
+	on    node
+	pos   int
+}
+
+type orderItem struct {
+	x    node
+	desc bool
+	out  int // index of the output column it names, or -1
+}
+
+type parser struct {
+	src  []rune
+	toks []token
+	i    int
+}
+
+// Parse parses a SELECT statement
+func Parse(src string) (*Query, error) {
+	runes := []rune(src)
+	toks, err := lex(runes)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: runes, toks: toks}
+	if p.peek().kind == tEOF {
+		return nil, &SyntaxError{Pos: 1, Msg: "empty query"}
+	}
+	q, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	q.src = src
+	if p.isOp(";") {
+		p.next()
+	}
+	if t := p.peek(); t.kind != tEOF {
+		return nil, p.unexpected(t)
+	}
+	return q, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tOp && t.text == op
+}
+
+// isKeyword reports whether the next token is one of the keywords
+func (p *parser) isKeyword(words ...string) bool {
+	t := p.peek()
+	if t.kind != tIdent || t.raw {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept consumes a sequence of keywords if they are next
+func (p *parser) accept(words ...string) bool {
+	save := p.i
+	for _, w := range words {
+		if !p.isKeyword(w) {
+			p.i = save
+			return false
+		}
+		p.next()
+	}
+	return true
+}
+
+func (p *parser) expectKeyword(words ...string) error {
+	if !p.accept(words...) {
+		return p.expected(strings.Join(words, " "))
+	}
+	return nil
+}
+
+func (p *parser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return p.expected("'" + op + "'")
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expected(what string) error {
+	t := p.peek()
+	if t.kind == tEOF {
+		return &SyntaxError{Pos: t.pos + 1, Msg: fmt.Sprintf("expected %s at end of query", what)}
+	}
+	return &SyntaxError{Pos: t.pos + 1, Msg: fmt.Sprintf("expected %s, found '%s'", what, p.text(t.pos, t.end))}
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tEOF {
+		return &SyntaxError{Pos: t.pos + 1, Msg: "unexpected end of query"}
+	}
+	return &SyntaxError{Pos: t.pos + 1, Msg: fmt.Sprintf("unexpected '%s'", p.text(t.pos, t.end))}
+}
+
+func (p *parser) text(start, end int) string {
+	return string(p.src[start:end])
+}
+
+// span is the source of the tokens consumed since the one at start
+func (p *parser) span(start int) string {
+	return p.text(p.toks[start].pos, p.toks[p.i-1].end)
+}
+
+// name reads a name that is not a keyword
+func (p *parser) name(what string) (string, error) {
+	t := p.peek()
+	if t.kind != tIdent || !t.raw && keywords[strings.ToUpper(t.text)] {
+		return "", p.expected(what)
+	}
+	p.next()
+	return t.text, nil
+}
+
+func (p *parser) parseSelect() (*Query, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	q := &Query{limit: -1}
+	q.distinct = p.accept("DISTINCT")
+	p.accept("ALL")
+	for {
+		item, err := p.parseItem()
+		if err != nil {
+			return nil, err
+		}
+		q.items = append(q.items, item)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if p.accept("FROM") {
+		if err := p.parseFrom(q); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("WHERE") {
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		q.where = x
+	}
+	if p.accept("GROUP", "BY") {
+		xs, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		q.groupBy = xs
+	}
+	if p.accept("HAVING") {
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		q.having = x
+	}
+	if p.accept("ORDER", "BY") {
+		for {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := &orderItem{x: x, out: -1}
+			if p.accept("DESC") {
+				item.desc = true
+			} else {
+				p.accept("ASC")
+			}
+			q.orderBy = append(q.orderBy, item)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.accept("LIMIT") {
+		n, err := p.count("LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		q.limit = n
+		if p.isOp(",") {
+			// LIMIT offset, count
+			p.next()
+			q.offset = n
+			if q.limit, err = p.count("LIMIT"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.accept("OFFSET") {
+		n, err := p.count("OFFSET")
+		if err != nil {
+			return nil, err
+		}
+		q.offset = n
+	}
+	if p.isKeyword("UNION") {
+		return nil, &SyntaxError{Pos: p.peek().pos + 1, Msg: "UNION is not supported"}
+	}
+	return q, nil
+}
+
+// count reads the number of LIMIT and OFFSET
+func (p *parser) count(what string) (int, error) {
+	t := p.peek()
+	if t.kind != tNumber || t.val != nil || t.num < 0 || t.num != float64(int(t.num)) {
+		return 0, p.expected("a row count after " + what)
+	}
+	p.next()
+	return int(t.num), nil
+}
+
+func (p *parser) parseItem() (*selectItem, error) {
+	start := p.i
+	if p.isOp("*") {
+		p.next()
+		return &selectItem{star: true, text: "*"}, nil
+	}
+	// t.*
+	if t := p.peek(); t.kind == tIdent && p.i+2 < len(p.toks) && p.toks[p.i+1].text == "." && p.toks[p.i+2].text == "*" {
+		p.i += 3
+		return &selectItem{star: true, table: t.text, text: p.span(start)}, nil
+	}
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	item := &selectItem{x: x, text: p.span(start)}
+	if p.accept("AS") {
+		if item.alias, err = p.name("a column name after AS"); err != nil {
+			return nil, err
+		}
+	} else if t := p.peek(); t.kind == tString || t.kind == tIdent && (t.raw || !keywords[strings.ToUpper(t.text)]) {
+		p.next()
+		item.alias = t.text
+	}
+	return item, nil
+}
+
+func (p *parser) parseFrom(q *Query) error {
+	ref, err := p.parseTable()
+	if err != nil {
+		return err
+	}
+	q.from = append(q.from, ref)
+	for {
+		join := ""
+		switch {
+		case p.isOp(","):
+			p.next()
+			join = "cross"
+		case p.accept("CROSS", "JOIN"):
+			join = "cross"
+		case p.accept("JOIN"), p.accept("INNER", "JOIN"):
+			join = "inner"
+		case p.accept("LEFT", "JOIN"), p.accept("LEFT", "OUTER", "JOIN"):
+			join = "left"
+		case p.isKeyword("RIGHT", "FULL"):
+			return &SyntaxError{Pos: p.peek().pos + 1, Msg: fmt.Sprintf("%s JOIN is not supported, swap the tables and use LEFT JOIN", strings.ToUpper(p.peek().text))}
+		default:
+			return nil
+		}
+		ref, err := p.parseTable()
+		if err != nil {
+			return err
+		}
+		ref.join = join
+		if join != "cross" {
+			if err := p.expectKeyword("ON"); err != nil {
+				return err
+			}
+			if ref.on, err = p.parseExpr(); err != nil {
+				return err
+			}
+		}
+		q.from = append(q.from, ref)
+	}
+}
+
+// parseTable reads a table: input, a quoted file name, or a path such as
+// data/hosts.csv written without spaces, with an optional alias
+func (p *parser) parseTable() (*tableRef, error) {
+	t := p.peek()
+	ref := &tableRef{pos: t.pos}
+	switch {
+	case t.kind == tString || t.kind == tIdent && t.raw:
+		p.next()
+		ref.name = t.text
+	case t.kind == tIdent && !keywords[strings.ToUpper(t.text)], t.kind == tOp && (t.text == "." || t.text == "/"):
+		start := p.i
+		p.next()
+		for {
+			n := p.peek()
+			adjacent := n.pos == p.toks[p.i-1].end
+			if !adjacent || !(n.kind == tIdent || n.kind == tNumber || n.kind == tOp && strings.Contains("./-", n.text)) {
+				break
+			}
+			p.next()
+		}
+		ref.name = p.span(start)
+	default:
+		return nil, p.expected("a table name")
+	}
+	ref.alias = tableAlias(ref.name)
+	if p.accept("AS") {
+		alias, err := p.name("a table alias after AS")
+		if err != nil {
+			return nil, err
+		}
+		ref.alias = alias
+	} else if n := p.peek(); n.kind == tIdent && (n.raw || !keywords[strings.ToUpper(n.text)]) {
+		p.next()
+		ref.alias = n.text
+	}
+	return ref, nil
+}
+
+// tableAlias is the name a table goes by without AS: the file name
+// without directory and extension
+func tableAlias(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func (p *parser) parseList() ([]node, error) {
+	var xs []node
+	for {
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+		if !p.isOp(",") {
+			return xs, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) parseExpr() (node, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (node, error) {
+	start := p.i
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &logic{op: "OR", l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	start := p.i
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &logic{op: "AND", l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	start := p.i
+	if p.accept("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{x: x, text: p.span(start)}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	start := p.i
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch t := p.peek(); {
+		case t.kind == tOp && strings.Contains(" = == != <> < <= > >= ", " "+t.text+" "):
+			p.next()
+			r, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			op := t.text
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+			l = &compare{op: op, l: l, r: r, text: p.span(start)}
+		case p.accept("IS"):
+			negate := p.accept("NOT")
+			if err := p.expectKeyword("NULL"); err != nil {
+				return nil, err
+			}
+			l = &isNull{x: l, not: negate}
+		default:
+			negate := p.accept("NOT")
+			switch {
+			case p.accept("IN"):
+				if err := p.expectOp("("); err != nil {
+					return nil, err
+				}
+				list, err := p.parseList()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expectOp(")"); err != nil {
+					return nil, err
+				}
+				l = &inList{x: l, list: list, not: negate}
+			case p.accept("LIKE"):
+				pattern, err := p.parseAdditive()
+				if err != nil {
+					return nil, err
+				}
+				l = &like{x: l, pattern: pattern, not: negate, text: p.span(start)}
+			case p.accept("BETWEEN"):
+				lo, err := p.parseAdditive()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expectKeyword("AND"); err != nil {
+					return nil, err
+				}
+				hi, err := p.parseAdditive()
+				if err != nil {
+					return nil, err
+				}
+				l = &between{x: l, lo: lo, hi: hi, not: negate, text: p.span(start)}
+			default:
+				if negate {
+					return nil, p.expected("IN, LIKE or BETWEEN after NOT")
+				}
+				return l, nil
+			}
+		}
+	}
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	start := p.i
+	l, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") || p.isOp("||") {
+		op := p.next().text
+		r, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: op, l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	start := p.i
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		op := p.next().text
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: op, l: l, r: r, text: p.span(start)}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	start := p.i
+	if p.isOp("-") || p.isOp("+") {
+		op := p.next().text
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			return x, nil
+		}
+		return &binary{op: "-", l: &literal{v: values.Number(0)}, r: x, text: p.span(start)}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	start := p.i
+	t := p.next()
+	switch t.kind {
+	case tNumber:
+		if t.val != nil {
+			return &literal{v: t.val}, nil
+		}
+		return &literal{v: values.Number(t.num)}, nil
+	case tString:
+		return &literal{v: values.String(t.text)}, nil
+	case tOp:
+		if t.text == "(" {
+			if p.isKeyword("SELECT") {
+				return nil, &SyntaxError{Pos: p.peek().pos + 1, Msg: "subqueries are not supported"}
+			}
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	case tIdent:
+		if !t.raw {
+			switch strings.ToUpper(t.text) {
+			case "NULL":
+				return &literal{v: values.Nothing{}}, nil
+			case "TRUE":
+				return &literal{v: values.Bool(true)}, nil
+			case "FALSE":
+				return &literal{v: values.Bool(false)}, nil
+			case "CASE":
+				return p.parseCase(start)
+			}
+			if p.isOp("(") {
+				return p.parseCall(t, start)
+			}
+			if keywords[strings.ToUpper(t.text)] {
+				break
+			}
+		}
+		// table.column
+		if p.isOp(".") && p.toks[p.i+1].kind == tIdent {
+			p.next()
+			col := p.next()
+			return &column{table: t.text, name: col.text, text: p.span(start), src: -1}, nil
+		}
+		return &column{name: t.text, text: p.span(start), src: -1}, nil
+	}
+	p.i = start
+	return nil, p.unexpected(t)
+}
+
+func (p *parser) parseCase(start int) (node, error) {
+	c := &caseNode{}
+	if !p.isKeyword("WHEN") {
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.operand = x
+	}
+	for p.accept("WHEN") {
+		when, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		then, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.whens = append(c.whens, when)
+		c.thens = append(c.thens, then)
+	}
+	if len(c.whens) == 0 {
+		return nil, p.expected("WHEN")
+	}
+	if p.accept("ELSE") {
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.els = x
+	}
+	if err := p.expectKeyword("END"); err != nil {
+		return nil, err
+	}
+	c.text = p.span(start)
+	return c, nil
+}
+
+func (p *parser) parseCall(name token, start int) (node, error) {
+	p.next() // (
+	fname := strings.ToLower(name.text)
+	if agg, ok := aggregates[fname]; ok {
+		a := &aggregate{name: fname, fn: agg}
+		if p.isOp("*") {
+			if fname != "count" {
+				return nil, &SyntaxError{Pos: p.peek().pos + 1, Msg: fmt.Sprintf("%s(*) is not allowed, only count(*)", fname)}
+			}
+			p.next()
+			a.star = true
+		} else {
+			a.distinct = p.accept("DISTINCT")
+			args, err := p.parseList()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) > agg.max || len(args) < 1 {
+				return nil, &SyntaxError{Pos: name.pos + 1, Msg: fmt.Sprintf("%s takes %s", fname, agg.arity())}
+			}
+			a.args = args
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		a.text = p.span(start)
+		return a, nil
+	}
+	fn, ok := functions[fname]
+	if !ok {
+		return nil, &SyntaxError{Pos: name.pos + 1, Msg: fmt.Sprintf("unknown function '%s'", name.text)}
+	}
+	var args []node
+	if !p.isOp(")") {
+		var err error
+		if args, err = p.parseList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	if len(args) < fn.Min || fn.Max >= 0 && len(args) > fn.Max {
+		return nil, &SyntaxError{Pos: name.pos + 1, Msg: fmt.Sprintf("%s takes %s", fname, fn.Arity())}
+	}
+	return &call{name: fname, fn: fn, args: args, text: p.span(start)}, nil
+}
@@ -0,0 +1,155 @@
+package sql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"netxp/values"
+)
+
+// open serves the tables the queries of the tests read
+func open(name string) (values.Value, error) {
+	switch name {
+	case "hosts":
+		return values.NewTable([]*values.Record{
+			values.NewRecord("name", "web1", "role", "web", "cpu", 4, "disk", values.Filesize(2000)),
+			values.NewRecord("name", "web2", "role", "web", "cpu", 2, "disk", values.Filesize(500)),
+			values.NewRecord("name", "db1", "role", "db", "cpu", 8, "disk", nil),
+			values.NewRecord("name", "cache", "role", nil, "cpu", 1, "disk", values.Filesize(100)),
+		}), nil
+	case "owners":
+		return values.NewTable([]*values.Record{
+			values.NewRecord("role", "web", "team", "front"),
+			values.NewRecord("role", "db", "team", "data"),
+		}), nil
+	case "nums":
+		return values.List{values.Number(3), values.Number(1), values.Number(2)}, nil
+	}
+	return nil, fmt.Errorf("no table '%s'", name)
+}
+
+// jsonOf renders a table as compact JSON for comparisons
+func jsonOf(t *values.Table) string {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`select 1 + 2 as n, 'a' || 'b' as s`, `[{"n":3,"s":"ab"}]`},
+		{`select name from hosts where cpu > 2 order by name`, `[{"name":"db1"},{"name":"web1"}]`},
+		{`SELECT name FROM hosts WHERE role = 'web' AND disk > 1kb`, `[{"name":"web1"}]`},
+		{`select name from hosts where role is null`, `[{"name":"cache"}]`},
+		{`select name from hosts where name like 'web%' order by cpu`, `[{"name":"web2"},{"name":"web1"}]`},
+		{`select name from hosts where cpu in (1, 8) order by name desc`, `[{"name":"db1"},{"name":"cache"}]`},
+		{`select name from hosts where cpu between 2 and 4 order by 1`, `[{"name":"web1"},{"name":"web2"}]`},
+		{`select name, cpu from hosts order by cpu desc limit 2 offset 1`, `[{"name":"web1","cpu":4},{"name":"web2","cpu":2}]`},
+		{`select distinct role from hosts order by role`, `[{"role":null},{"role":"db"},{"role":"web"}]`},
+		{`select * from hosts where name = 'db1'`, `[{"name":"db1","role":"db","cpu":8,"disk":null}]`},
+		{`select .5 * 2 as a, 1.5e1 as b, 2kb as c, 90s as d`, `[{"a":1,"b":15,"c":2000,"d":"1m30s"}]`},
+		{`select name from hosts where cpu - 1 order by name`, `[{"name":"db1"},{"name":"web1"},{"name":"web2"}]`},
+		{`select value from nums order by value`, `[{"value":1},{"value":2},{"value":3}]`},
+		// aggregates and grouping
+		{`select count(*) as n, sum(cpu) as total, avg(cpu) as mean from hosts`, `[{"n":4,"total":15,"mean":3.75}]`},
+		{`select count(disk) as n, min(disk) as lo, max(disk) as hi from hosts`, `[{"n":3,"lo":100,"hi":2000}]`},
+		{`select role, count(*) as n from hosts group by role having count(*) > 1`, `[{"role":"web","n":2}]`},
+		{`select role, max(name) as last from hosts where role is not null group by role order by role`, `[{"role":"db","last":"db1"},{"role":"web","last":"web2"}]`},
+		// joins
+		{`select h.name, o.team from hosts h join owners o on h.role = o.role order by h.name`, `[{"name":"db1","team":"data"},{"name":"web1","team":"front"},{"name":"web2","team":"front"}]`},
+		{`select h.name, o.team from hosts as h left join owners as o on h.role = o.role where o.team is null`, `[{"name":"cache","team":null}]`},
+		{`select count(*) as n from hosts cross join owners`, `[{"n":8}]`},
+		// functions
+		{`select substr('hello', 2, 3) as s, substring('hello', 4) as t`, `[{"s":"ell","t":"lo"}]`},
+		{`select coalesce(null, disk, 0) as d from hosts where name = 'db1'`, `[{"d":0}]`},
+		{`select nullif(role, 'web') as r from hosts where name = 'web1'`, `[{"r":null}]`},
+		{`select ifnull(role, '-') as r from hosts where name = 'cache'`, `[{"r":"-"}]`},
+		{`select upper(name) as n, length(name) as l from hosts where cpu = 1`, `[{"n":"CACHE","l":5}]`},
+		{`select typeof(disk) as t from hosts where name = 'web1'`, `[{"t":"filesize"}]`},
+		{`select round(avg(cpu), 1) as a from hosts`, `[{"a":3.8}]`},
+		{`select case when cpu >= 4 then 'big' else 'small' end as size from hosts where name = 'web2'`, `[{"size":"small"}]`},
+	}
+	for _, tt := range tests {
+		got, err := Run(tt.src, open)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if s := jsonOf(got); s != tt.want {
+			t.Errorf("%s\n got %s\nwant %s", tt.src, s, tt.want)
+		}
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+	}{
+		{``, 1},
+		{`select`, 7},
+		{`select a from`, 14},
+		{`select a, from hosts`, 11},
+		{`select a from hosts where`, 26},
+		{`select 'open from hosts`, 8},
+		{`select a from hosts limit x`, 27},
+		{`select (a from hosts`, 11},
+		{`select a from hosts garbage here`, 29},
+		{`select 10xb`, 10},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%q: got %v, want a syntax error", tt.src, err)
+			continue
+		}
+		if se.Pos != tt.pos {
+			t.Errorf("%q: error at %d, want %d (%s)", tt.src, se.Pos, tt.pos, se.Msg)
+		}
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		want    string // part of the error message
+		columns []string
+	}{
+		{`select nme from hosts`, "no column 'nme'", []string{"name", "role", "cpu", "disk"}},
+		{`select role from hosts join owners on hosts.role = owners.role`, "column 'role' is ambiguous", []string{"hosts.role", "owners.role"}},
+		{`select x.name from hosts`, "no table 'x'", nil},
+		{`select name from nowhere`, "no table 'nowhere'", nil},
+		{`select name from hosts where count(*) > 1`, "not allowed in WHERE", nil},
+		{`select sum(count(*)) from hosts`, "cannot be nested", nil},
+		{`select name from hosts where name`, "WHERE", nil},
+	}
+	for _, tt := range tests {
+		_, err := Run(tt.src, open)
+		if err == nil {
+			t.Errorf("%s: want an error", tt.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %q, want it to mention %q", tt.src, err, tt.want)
+		}
+		if tt.columns == nil {
+			continue
+		}
+		ce, ok := err.(*ColumnError)
+		if !ok {
+			t.Errorf("%s: got %T, want a column error", tt.src, err)
+			continue
+		}
+		if got := strings.Join(ce.Columns, ","); got != strings.Join(tt.columns, ",") {
+			t.Errorf("%s: columns %s, want %s", tt.src, got, strings.Join(tt.columns, ","))
+		}
+	}
+}
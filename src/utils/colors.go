@@ -1,4 +1,4 @@
-package colors
+package utils
 
 // Color code constants
 const (
@@ -15,6 +15,10 @@ const (
 
 	CBgRed   = "\033[41m"
 	CBgGreen = "\033[42m"
+
+	CInfo = CCyan
+	CWarn = CYellow
+	CErr  = CRed
 )
 
 // Colorize wraps text with ANSI color codes
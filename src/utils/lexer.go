@@ -0,0 +1,647 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind identifies the kind of a lexed token
+type TokenKind int
+
+const (
+	// TokWord is a command name or argument
+	TokWord TokenKind = iota
+	// TokPipe is an unquoted '|'
+	TokPipe
+	// TokRedirect is one of > >> < 2> 2>> 2>&1
+	TokRedirect
+	// TokSemi is an unquoted ';'
+	TokSemi
+	// TokAnd is an unquoted '&&'
+	TokAnd
+	// TokOr is an unquoted '||'
+	TokOr
+)
+
+// Token is a single lexical unit of a command line
+type Token struct {
+	Kind  TokenKind
+	Value string // text with quotes and escapes removed
+	Raw   string // text exactly as written
+	Pos   int    // 1-based column of the first character
+	Parts []WordPart
+	Expr  bool // the rest of an expression command, see ExprCommands
+}
+
+// SyntaxError reports malformed input together with the column it was found at
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.Pos, e.Msg)
+}
+
+// ExprCommands take an expression as their arguments. After one of them
+// the rest of the stage is a single word in which > and < compare, see
+// lexExpr for where it ends.
+var ExprCommands = map[string]bool{"where": true, "insert": true, "update": true}
+
+// Tokenize splits a command line into words and operators.
+// Single quotes keep their contents literally, double quotes allow
+// \" \\ \$ and \` escapes, and a backslash outside quotes escapes
+// the next character. Operators inside quotes are plain text.
+// $name references and $( ... ) substitutions outside single quotes are
+// kept as separate parts of the word so the shell can expand them later.
+func Tokenize(line string) ([]Token, error) {
+	src := []rune(line)
+	var toks []Token
+	i := 0
+	stageStart := true
+	for i < len(src) {
+		c := src[i]
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			wasStart := stageStart
+			stageStart = c == ';' || c == '|' || (c == '&' && i+1 < len(src) && src[i+1] == '&')
+			if wasStart && !stageStart && redirectAt(src, i) == "" {
+				tok, next, err := lexWord(src, i)
+				if err != nil {
+					return nil, err
+				}
+				toks = append(toks, tok)
+				i = next
+				if ExprCommands[tok.Raw] {
+					expr, next, err := lexExpr(src, i)
+					if err != nil {
+						return nil, err
+					}
+					if expr.Raw != "" {
+						toks = append(toks, expr)
+					}
+					i = next
+				}
+				continue
+			}
+		}
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == ';':
+			toks = append(toks, Token{Kind: TokSemi, Value: ";", Raw: ";", Pos: i + 1})
+			i++
+		case c == '&' && i+1 < len(src) && src[i+1] == '&':
+			toks = append(toks, Token{Kind: TokAnd, Value: "&&", Raw: "&&", Pos: i + 1})
+			i += 2
+		case c == '|' && i+1 < len(src) && src[i+1] == '|':
+			toks = append(toks, Token{Kind: TokOr, Value: "||", Raw: "||", Pos: i + 1})
+			i += 2
+		case c == '|':
+			toks = append(toks, Token{Kind: TokPipe, Value: "|", Raw: "|", Pos: i + 1})
+			i++
+		case redirectAt(src, i) != "":
+			op := redirectAt(src, i)
+			toks = append(toks, Token{Kind: TokRedirect, Value: op, Raw: op, Pos: i + 1})
+			i += len(op)
+		default:
+			tok, next, err := lexWord(src, i)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, tok)
+			i = next
+		}
+	}
+	return toks, nil
+}
+
+// redirectAt returns the redirection operator starting at src[i], if any
+func redirectAt(src []rune, i int) string {
+	end := i + 4
+	if end > len(src) {
+		end = len(src)
+	}
+	rest := string(src[i:end])
+	for _, op := range []string{"2>&1", "2>>", "2>", ">>", ">", "<"} {
+		if strings.HasPrefix(rest, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// WordPart is a piece of a word: literal text, a variable reference or a
+// command substitution
+type WordPart struct {
+	Text   string
+	Var    bool // Text is a variable reference such as "hosts.0.ip"
+	Subst  bool // Text is the command line inside $( ... )
+	Quoted bool // the part was written inside quotes
+}
+
+// wordBuilder accumulates the parts of a word while it is being lexed
+type wordBuilder struct {
+	parts  []WordPart
+	lit    strings.Builder
+	quoted bool
+}
+
+func (b *wordBuilder) literal(s string, quoted bool) {
+	if b.lit.Len() > 0 && b.quoted != quoted {
+		b.flush()
+	}
+	b.quoted = quoted
+	b.lit.WriteString(s)
+}
+
+func (b *wordBuilder) variable(ref string, quoted bool) {
+	b.flush()
+	b.parts = append(b.parts, WordPart{Text: ref, Var: true, Quoted: quoted})
+}
+
+func (b *wordBuilder) substitution(line string, quoted bool) {
+	b.flush()
+	b.parts = append(b.parts, WordPart{Text: line, Subst: true, Quoted: quoted})
+}
+
+func (b *wordBuilder) flush() {
+	if b.lit.Len() > 0 {
+		b.parts = append(b.parts, WordPart{Text: b.lit.String(), Quoted: b.quoted})
+		b.lit.Reset()
+	}
+}
+
+// lexWord reads one word starting at src[start] and returns it with the index after it
+func lexWord(src []rune, start int) (Token, int, error) {
+	var b wordBuilder
+	quotedAny := false
+	i := start
+loop:
+	for i < len(src) {
+		c := src[i]
+		switch c {
+		case ' ', '\t', '\n', '\r', '|', '>', '<', ';':
+			break loop
+		case '&':
+			if i+1 < len(src) && src[i+1] == '&' {
+				break loop
+			}
+			b.literal("&", false)
+			i++
+		case '\\':
+			if i+1 >= len(src) {
+				return Token{}, 0, &SyntaxError{Pos: i + 1, Msg: "trailing backslash"}
+			}
+			b.literal(string(src[i+1]), true)
+			i += 2
+		case '$':
+			if i+1 < len(src) && src[i+1] == '(' {
+				line, next, err := scanSubst(src, i)
+				if err != nil {
+					return Token{}, 0, err
+				}
+				b.substitution(line, false)
+				i = next
+				continue
+			}
+			ref, next, err := scanVar(src, i)
+			if err != nil {
+				return Token{}, 0, err
+			}
+			if ref == "" {
+				b.literal("$", false)
+			} else {
+				b.variable(ref, false)
+			}
+			i = next
+		case '\'':
+			end := i + 1
+			for end < len(src) && src[end] != '\'' {
+				end++
+			}
+			if end >= len(src) {
+				return Token{}, 0, &SyntaxError{Pos: i + 1, Msg: "unterminated single quote"}
+			}
+			b.literal(string(src[i+1:end]), true)
+			quotedAny = true
+			i = end + 1
+		case '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				switch {
+				case src[end] == '\\' && end+1 < len(src) && strings.ContainsRune("\"\\$`", src[end+1]):
+					b.literal(string(src[end+1]), true)
+					end += 2
+				case src[end] == '$' && end+1 < len(src) && src[end+1] == '(':
+					line, next, err := scanSubst(src, end)
+					if err != nil {
+						return Token{}, 0, err
+					}
+					b.substitution(line, true)
+					end = next
+				case src[end] == '$':
+					ref, next, err := scanVar(src, end)
+					if err != nil {
+						return Token{}, 0, err
+					}
+					if ref == "" {
+						b.literal("$", true)
+					} else {
+						b.variable(ref, true)
+					}
+					end = next
+				default:
+					b.literal(string(src[end]), true)
+					end++
+				}
+			}
+			if end >= len(src) {
+				return Token{}, 0, &SyntaxError{Pos: i + 1, Msg: "unterminated double quote"}
+			}
+			quotedAny = true
+			i = end + 1
+		default:
+			b.literal(string(c), false)
+			i++
+		}
+	}
+	b.flush()
+	if len(b.parts) == 0 && quotedAny {
+		b.parts = []WordPart{{Quoted: true}}
+	}
+	return Token{Kind: TokWord, Value: partsText(b.parts), Raw: string(src[start:i]), Pos: start + 1, Parts: b.parts}, i, nil
+}
+
+// partsText is the text of a word with its variables and substitutions
+// written back as they were referenced
+func partsText(parts []WordPart) string {
+	var val strings.Builder
+	for _, p := range parts {
+		switch {
+		case p.Var:
+			val.WriteString("$" + p.Text)
+		case p.Subst:
+			val.WriteString("$(" + p.Text + ")")
+		default:
+			val.WriteString(p.Text)
+		}
+	}
+	return val.String()
+}
+
+// lexExpr reads the expression that follows an expression command. Outside
+// quotes and brackets it ends at '|', ';', a '{' standing on its own,
+// '>>' and '2>' redirections, and at a '>' or '<' coming after a
+// comparison, so "where a > 1 > out.json" compares once and redirects.
+// '&&' and '||' are operators of the expression, as in
+// "where size > 1mb && name =~ x", and not sequencing. Quotes are kept
+// for the expression parser and only variables and substitutions are
+// left for the shell to expand. An expression written as one quoted word
+// is unquoted like any other word.
+func lexExpr(src []rune, start int) (Token, int, error) {
+	for start < len(src) && (src[start] == ' ' || src[start] == '\t') {
+		start++
+	}
+	var b wordBuilder
+	i := start
+	depth := 0
+	compared := false // a comparison since the last and/or at the top
+	space := func(j int) bool {
+		return j < 0 || j >= len(src) || strings.ContainsRune(" \t\r\n", src[j])
+	}
+loop:
+	for i < len(src) {
+		c := src[i]
+		op := ""
+		if i+1 < len(src) {
+			op = string(src[i : i+2])
+		}
+		if depth == 0 {
+			switch {
+			case c == ';', c == '|' && op != "||", op == ">>":
+				break loop
+			case c == '{' && space(i-1) && space(i+1):
+				break loop
+			case c == '2' && space(i-1) && strings.HasPrefix(redirectAt(src, i), "2>"):
+				break loop
+			case (c == '>' || c == '<') && compared && op != ">=" && op != "<=":
+				break loop
+			}
+		}
+		switch {
+		case c == '(' || c == '[' || c == '{':
+			depth++
+			b.literal(string(c), true)
+			i++
+		case c == ')' || c == ']' || c == '}':
+			if depth > 0 {
+				depth--
+			}
+			b.literal(string(c), true)
+			i++
+		case op == "&&" || op == "||":
+			if depth == 0 {
+				compared = false
+			}
+			b.literal(op, true)
+			i += 2
+		case op == "==" || op == "!=" || op == "<=" || op == ">=" || op == "=~" || op == "!~":
+			compared = true
+			b.literal(op, true)
+			i += 2
+		case c == '<' || c == '>':
+			compared = true
+			b.literal(string(c), true)
+			i++
+		case isNameStart(c):
+			j := i
+			for j < len(src) && isNameChar(src[j]) {
+				j++
+			}
+			switch word := string(src[i:j]); {
+			case word == "in" || word == "is":
+				compared = true
+			case depth == 0 && (word == "and" || word == "or"):
+				compared = false
+			}
+			b.literal(string(src[i:j]), true)
+			i = j
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(src) && src[end] != c {
+				if src[end] == '\\' && c != '\'' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return Token{}, 0, &SyntaxError{Pos: i + 1, Msg: fmt.Sprintf("unterminated %c", c)}
+			}
+			b.literal(string(src[i:end+1]), true)
+			i = end + 1
+		case c == '$' && i+1 < len(src) && src[i+1] == '(':
+			line, next, err := scanSubst(src, i)
+			if err != nil {
+				return Token{}, 0, err
+			}
+			b.substitution(line, false)
+			i = next
+		case c == '$':
+			ref, next, err := scanVar(src, i)
+			if err != nil {
+				return Token{}, 0, err
+			}
+			if ref == "" {
+				b.literal("$", true)
+			} else {
+				b.variable(ref, false)
+			}
+			i = next
+		default:
+			b.literal(string(c), true)
+			i++
+		}
+	}
+	end := i
+	for end > start && strings.ContainsRune(" \t\r\n", src[end-1]) {
+		end--
+	}
+	raw := string(src[start:end])
+	if raw == "" {
+		return Token{}, i, nil
+	}
+	if raw[0] == '\'' || raw[0] == '"' {
+		if tok, next, err := lexWord(src, start); err == nil && next == end {
+			return tok, i, nil
+		}
+	}
+	b.flush()
+	if n := len(b.parts); n > 0 && !b.parts[n-1].Var && !b.parts[n-1].Subst {
+		b.parts[n-1].Text = strings.TrimRight(b.parts[n-1].Text, " \t\r\n")
+	}
+	return Token{Kind: TokWord, Value: partsText(b.parts), Raw: raw, Pos: start + 1, Parts: b.parts, Expr: true}, i, nil
+}
+
+// scanSubst reads a command substitution at src[i:] == "$(" and returns
+// the command line inside the parentheses and the index after them.
+// Parentheses inside quotes or nested substitutions are skipped.
+func scanSubst(src []rune, i int) (string, int, error) {
+	depth := 0
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '\'':
+			end := j + 1
+			for end < len(src) && src[end] != '\'' {
+				end++
+			}
+			if end >= len(src) {
+				return "", 0, &SyntaxError{Pos: j + 1, Msg: "unterminated single quote"}
+			}
+			j = end
+		case '"':
+			end := j + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return "", 0, &SyntaxError{Pos: j + 1, Msg: "unterminated double quote"}
+			}
+			j = end
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return string(src[i+2 : j]), j + 1, nil
+			}
+		}
+	}
+	return "", 0, &SyntaxError{Pos: i + 1, Msg: "unterminated $("}
+}
+
+// scanVar reads a variable reference at src[i] == '$'. It returns the
+// reference without the '$' (empty if the '$' is literal) and the index after it.
+// $name.path.0, ${name.path.0} and the status variable $? are recognised.
+func scanVar(src []rune, i int) (string, int, error) {
+	j := i + 1
+	if j < len(src) && src[j] == '{' {
+		end := j + 1
+		for end < len(src) && src[end] != '}' {
+			end++
+		}
+		if end >= len(src) {
+			return "", 0, &SyntaxError{Pos: i + 1, Msg: "unterminated ${"}
+		}
+		ref := string(src[j+1 : end])
+		if ref == "" {
+			return "", 0, &SyntaxError{Pos: i + 1, Msg: "empty variable name"}
+		}
+		return ref, end + 1, nil
+	}
+	if j < len(src) && src[j] == '?' {
+		return "?", j + 1, nil
+	}
+	// positional parameters $1 .. $9
+	if j < len(src) && src[j] >= '1' && src[j] <= '9' {
+		return string(src[j]), j + 1, nil
+	}
+	if j >= len(src) || !isNameStart(src[j]) {
+		return "", j, nil
+	}
+	for j < len(src) && isNameChar(src[j]) {
+		j++
+	}
+	// path segments: .field or .0
+	for j+1 < len(src) && src[j] == '.' && isPathChar(src[j+1]) {
+		j++
+		for j < len(src) && isPathChar(src[j]) {
+			j++
+		}
+	}
+	return string(src[i+1 : j]), j, nil
+}
+
+func isNameStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c rune) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func isPathChar(c rune) bool {
+	return isNameChar(c) || c == '-'
+}
+
+// IsName reports whether s is a valid variable name
+func IsName(s string) bool {
+	if s == "" || !isNameStart(rune(s[0])) {
+		return false
+	}
+	for _, c := range s {
+		if !isNameChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Redirect is a file redirection attached to a command
+type Redirect struct {
+	Op     string // > >> < 2> 2>> or 2>&1
+	Target Token  // file operand; empty for 2>&1
+	Pos    int
+}
+
+// Command is one stage of a pipeline
+type Command struct {
+	Words     []Token
+	Redirects []Redirect
+	Pos       int
+}
+
+// Argv returns the unquoted words of the command
+func (c Command) Argv() []string {
+	argv := make([]string, len(c.Words))
+	for i, w := range c.Words {
+		argv[i] = w.Value
+	}
+	return argv
+}
+
+// Raw returns the command as it was written
+func (c Command) Raw() string {
+	parts := make([]string, 0, len(c.Words)+len(c.Redirects))
+	for _, w := range c.Words {
+		parts = append(parts, w.Raw)
+	}
+	for _, r := range c.Redirects {
+		parts = append(parts, strings.TrimSpace(r.Op+" "+r.Target.Raw))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Pipeline is a sequence of commands joined by '|'
+type Pipeline struct {
+	Commands []Command
+	Op       string // operator joining it to the previous pipeline: "", ";", "&&" or "||"
+}
+
+// ParseList tokenizes a line into pipelines separated by ';', '&&' and '||'
+func ParseList(line string) ([]Pipeline, error) {
+	toks, err := Tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+	var list []Pipeline
+	op := ""
+	start := 0
+	for i := 0; i <= len(toks); i++ {
+		if i < len(toks) && toks[i].Kind != TokSemi && toks[i].Kind != TokAnd && toks[i].Kind != TokOr {
+			continue
+		}
+		if i == start {
+			if i < len(toks) {
+				return nil, &SyntaxError{Pos: toks[i].Pos, Msg: fmt.Sprintf("unexpected '%s'", toks[i].Raw)}
+			}
+			if op == "&&" || op == "||" {
+				return nil, &SyntaxError{Pos: toks[i-1].Pos, Msg: fmt.Sprintf("missing command after '%s'", op)}
+			}
+			break
+		}
+		cmds, err := parseCommands(toks[start:i])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Pipeline{Commands: cmds, Op: op})
+		if i < len(toks) {
+			op = toks[i].Value
+		}
+		start = i + 1
+	}
+	return list, nil
+}
+
+// parseCommands groups tokens into pipeline stages
+func parseCommands(toks []Token) ([]Command, error) {
+	var cmds []Command
+	cur := Command{}
+	empty := func(c Command) bool { return len(c.Words) == 0 && len(c.Redirects) == 0 }
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if empty(cur) {
+			cur.Pos = t.Pos
+		}
+		switch t.Kind {
+		case TokPipe:
+			if empty(cur) {
+				return nil, &SyntaxError{Pos: t.Pos, Msg: "empty pipeline stage before '|'"}
+			}
+			cmds = append(cmds, cur)
+			cur = Command{}
+		case TokRedirect:
+			r := Redirect{Op: t.Value, Pos: t.Pos}
+			if t.Value != "2>&1" {
+				if i+1 >= len(toks) || toks[i+1].Kind != TokWord {
+					return nil, &SyntaxError{Pos: t.Pos, Msg: fmt.Sprintf("missing file after '%s'", t.Value)}
+				}
+				i++
+				r.Target = toks[i]
+			}
+			cur.Redirects = append(cur.Redirects, r)
+		case TokSemi, TokAnd, TokOr:
+			return nil, &SyntaxError{Pos: t.Pos, Msg: fmt.Sprintf("unexpected '%s'", t.Raw)}
+		default:
+			cur.Words = append(cur.Words, t)
+		}
+	}
+	if empty(cur) {
+		return nil, &SyntaxError{Pos: toks[len(toks)-1].Pos, Msg: "pipeline ends with '|'"}
+	}
+	return append(cmds, cur), nil
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// shape summarizes a parsed line: pipelines joined by their operator,
+// stages by |, words in brackets, expressions in braces and redirections
+// as operator and target
+func shape(list []Pipeline) string {
+	var b strings.Builder
+	for i, p := range list {
+		if i > 0 {
+			b.WriteString(" " + p.Op + " ")
+		}
+		for j, c := range p.Commands {
+			if j > 0 {
+				b.WriteString(" | ")
+			}
+			var parts []string
+			for _, w := range c.Words {
+				if w.Expr {
+					parts = append(parts, "{"+w.Value+"}")
+				} else {
+					parts = append(parts, "["+w.Value+"]")
+				}
+			}
+			for _, r := range c.Redirects {
+				parts = append(parts, r.Op+r.Target.Value)
+			}
+			b.WriteString(strings.Join(parts, " "))
+		}
+	}
+	return b.String()
+}
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`ls -la`, `[ls] [-la]`},
+		{`echo "a b" 'c d' e\ f`, `[echo] [a b] [c d] [e f]`},
+		{`echo "a|b" 'c;d' x\|y`, `[echo] [a|b] [c;d] [x|y]`},
+		{`ls|head 2|tab`, `[ls] | [head] [2] | [tab]`},
+		{`a ; b && c || d`, `[a] ; [b] && [c] || [d]`},
+		{`ls>out.txt`, `[ls] >out.txt`},
+		{`a 2>&1 | b >> log`, `[a] 2>&1 | [b] >>log`},
+		{`a < in.txt 2> err.txt`, `[a] <in.txt 2>err.txt`},
+		{`echo 2>x`, `[echo] 2>x`},
+		{`echo a2>x`, `[echo] [a2] >x`},
+		{`echo "$(ls | head 1)"`, `[echo] [$(ls | head 1)]`},
+	}
+	for _, tt := range tests {
+		list, err := ParseList(tt.line)
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if got := shape(list); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseListErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		pos  int
+	}{
+		{`| ls`, 1},
+		{`ls | | tab`, 6},
+		{`ls ;; pwd`, 5},
+		{`ls &&`, 4},
+		{`echo "open`, 6},
+		{`ls >`, 4},
+	}
+	for _, tt := range tests {
+		_, err := ParseList(tt.line)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("%s: got %v, want a syntax error", tt.line, err)
+			continue
+		}
+		if se.Pos != tt.pos {
+			t.Errorf("%s: error at column %d, want %d (%s)", tt.line, se.Pos, tt.pos, se.Msg)
+		}
+	}
+}
+
+func TestWordParts(t *testing.T) {
+	tests := []struct {
+		word string
+		want []WordPart
+	}{
+		{`$x.y`, []WordPart{{Text: "x.y", Var: true}}},
+		{`pre$v`, []WordPart{{Text: "pre"}, {Text: "v", Var: true}}},
+		{`"$v"`, []WordPart{{Text: "v", Var: true, Quoted: true}}},
+		{`'$v'`, []WordPart{{Text: "$v", Quoted: true}}},
+		{`$(ls | head 1)`, []WordPart{{Text: "ls | head 1", Subst: true}}},
+		{`e\ f`, []WordPart{{Text: "e"}, {Text: " ", Quoted: true}, {Text: "f"}}},
+	}
+	for _, tt := range tests {
+		toks, err := Tokenize(tt.word)
+		if err != nil {
+			t.Errorf("%s: %v", tt.word, err)
+			continue
+		}
+		if len(toks) != 1 {
+			t.Errorf("%s: %d tokens, want 1", tt.word, len(toks))
+			continue
+		}
+		got := toks[0].Parts
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: parts %+v, want %+v", tt.word, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: parts %+v, want %+v", tt.word, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// TestExprBoundaries checks where the expression of an expression
+// command ends and the shell takes over again
+func TestExprBoundaries(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`where a > 1`, `[where] {a > 1}`},
+		{`where a >= 2 | tab`, `[where] {a >= 2} | [tab]`},
+		{`where a > 1 > out.json`, `[where] {a > 1} >out.json`},
+		{`where a <= 1 >> out.json`, `[where] {a <= 1} >>out.json`},
+		{`where a > 1 and b < 2 < in.json`, `[where] {a > 1 and b < 2} <in.json`},
+		{`where a < 2 2> err.txt`, `[where] {a < 2} 2>err.txt`},
+		{`where x == "a|b;c" | tab`, `[where] {x == "a|b;c"} | [tab]`},
+		{`where x == 'a > b' > out`, `[where] {x == 'a > b'} >out`},
+		{`where size > 1mb && name =~ "\.log$" | tab`, `[where] {size > 1mb && name =~ "\.log$"} | [tab]`},
+		{`where a > 1 || b < 2 > out`, `[where] {a > 1 || b < 2} >out`},
+		{`where a in [1, 2] || b; echo none`, `[where] {a in [1, 2] || b} ; [echo] [none]`},
+		{`update c = a > 1 && b < 2`, `[update] {c = a > 1 && b < 2}`},
+		{`where size > 1kb { echo big }`, `[where] {size > 1kb} [{] [echo] [big] [}]`},
+		{`where name =~ "x{2}"`, `[where] {name =~ "x{2}"}`},
+		{`where {a: 1}.a == 1`, `[where] {{a: 1}.a == 1}`},
+		{`where f(a, b > 1) > out`, `[where] {f(a, b > 1)} >out`},
+		{`where a > 1; ls`, `[where] {a > 1} ; [ls]`},
+		{`insert b = a * 2 | tab`, `[insert] {b = a * 2} | [tab]`},
+		{`where not a > 1 or b`, `[where] {not a > 1 or b}`},
+	}
+	for _, tt := range tests {
+		list, err := ParseList(tt.line)
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if got := shape(list); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestExprVariables checks that variables are marked for expansion in
+// an expression, but not inside its string literals
+func TestExprVariables(t *testing.T) {
+	toks, err := Tokenize(`where a > $n and b == "$s"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toks) != 2 || !toks[1].Expr {
+		t.Fatalf("tokens %+v, want where and an expression", toks)
+	}
+	want := []WordPart{
+		{Text: "a > ", Quoted: true},
+		{Text: "n", Var: true},
+		{Text: ` and b == "$s"`, Quoted: true},
+	}
+	got := toks[1].Parts
+	if len(got) != len(want) {
+		t.Fatalf("parts %+v, want %+v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("parts %+v, want %+v", got, want)
+		}
+	}
+}
@@ -1,23 +1,6 @@
 package utils
 
-import (
-	"encoding/json"
-	"strings"
-)
-
-// ParseCmd splits a command string into command name and arguments
-func ParseCmd(line string) (string, []string) {
-	parts := strings.Fields(line)
-	if len(parts) == 0 {
-		return "", nil
-	}
-	return parts[0], parts[1:]
-}
-
-// SplitPipeline splits a pipeline string by '|'
-func SplitPipeline(line string) []string {
-	return strings.Split(line, "|")
-}
+import "encoding/json"
 
 // ParseJSON tries to parse bytes as JSON
 func ParseJSON(data []byte) (interface{}, error) {
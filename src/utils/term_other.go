@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package utils
+
+import "os"
+
+// TerminalWidth returns the width of the terminal on f, or false when it
+// cannot be determined on this platform
+func TerminalWidth(f *os.File) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,233 @@
+package values
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FromGo converts a Go value, such as the result of a builtin, into a
+// Value. Maps become records with sorted keys, slices of records become
+// tables and times and durations become a Datetime and a Duration.
+func FromGo(v interface{}) Value {
+	switch x := v.(type) {
+	case nil:
+		return Nothing{}
+	case Value:
+		return x
+	case bool:
+		return Bool(x)
+	case string:
+		return String(x)
+	case []byte:
+		return Binary(x)
+	case time.Time:
+		return Datetime(x)
+	case time.Duration:
+		return Duration(x)
+	case float64:
+		return Number(x)
+	case float32:
+		return Number(x)
+	case int:
+		return Number(x)
+	case int64:
+		return Number(x)
+	case int32:
+		return Number(x)
+	case uint:
+		return Number(x)
+	case uint64:
+		return Number(x)
+	case uint32:
+		return Number(x)
+	case error:
+		return String(x.Error())
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		r := NewRecord()
+		for _, k := range keys {
+			r.Set(k, FromGo(rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface()))
+		}
+		return r
+	case reflect.Slice, reflect.Array:
+		items := make([]Value, rv.Len())
+		for i := range items {
+			items[i] = FromGo(rv.Index(i).Interface())
+		}
+		return NewList(items)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return Nothing{}
+		}
+	}
+	// structs and anything else go through their JSON form
+	b, err := json.Marshal(v)
+	if err != nil {
+		return String(fmt.Sprint(v))
+	}
+	out, err := ParseJSON(b)
+	if err != nil {
+		return String(fmt.Sprint(v))
+	}
+	return out
+}
+
+// ParseJSON decodes a single JSON document, keeping the order of keys
+func ParseJSON(data []byte) (Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	v, err := DecodeJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: data after the top-level value")
+	}
+	return v, nil
+}
+
+// DecodeJSON reads the next JSON value from dec, keeping the order of keys
+func DecodeJSON(dec *json.Decoder) (Value, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			r := NewRecord()
+			for dec.More() {
+				kt, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := DecodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				r.Set(kt.(string), v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return r, nil
+		case '[':
+			items := []Value{}
+			for dec.More() {
+				v, err := DecodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return NewList(items), nil
+		}
+		return nil, fmt.Errorf("invalid JSON: unexpected '%s'", t)
+	case string:
+		return String(t), nil
+	case float64:
+		return Number(t), nil
+	case bool:
+		return Bool(t), nil
+	case nil:
+		return Nothing{}, nil
+	}
+	return nil, fmt.Errorf("invalid JSON token %v", tok)
+}
+
+// Unwrap removes the {"success": true, "data": ...} envelope that older
+// builtins and modules wrap their results in
+func Unwrap(v Value) Value {
+	r, ok := v.(*Record)
+	if !ok {
+		return v
+	}
+	success, ok := r.Get("success")
+	if b, isBool := success.(Bool); !ok || !isBool || !bool(b) {
+		return v
+	}
+	if data, ok := r.Get("data"); ok {
+		return data
+	}
+	return v
+}
+
+// Decode turns the output of an external command or module into a value.
+// JSON keeps its structure, with envelopes unwrapped and a stream of
+// several documents collected into a list. Other output is text, or
+// binary when it is not valid UTF-8.
+func Decode(data []byte) Value {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return Nothing{}
+	}
+	if v, err := ParseJSON(trimmed); err == nil {
+		return Unwrap(v)
+	}
+	if trimmed[0] == '{' || trimmed[0] == '[' {
+		if items, err := decodeStream(trimmed); err == nil {
+			return Collect(items)
+		}
+	}
+	if !utf8.Valid(data) {
+		return Binary(data)
+	}
+	return String(strings.TrimRight(string(data), "\r\n"))
+}
+
+// decodeStream decodes consecutive JSON documents such as NDJSON
+func decodeStream(data []byte) ([]Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var items []Value
+	for {
+		v, err := DecodeJSON(dec)
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, Unwrap(v))
+	}
+}
+
+// Encode serializes a value for the world outside netxp: text and binary
+// as they are and everything else as one line of compact JSON
+func Encode(v Value) []byte {
+	switch x := v.(type) {
+	case nil, Nothing:
+		return nil
+	case String:
+		if strings.HasSuffix(string(x), "\n") {
+			return []byte(x)
+		}
+		return []byte(string(x) + "\n")
+	case Binary:
+		return x
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(Text(v))
+	}
+	return append(b, '\n')
+}
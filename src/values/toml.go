@@ -0,0 +1,657 @@
+package values
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TOMLError reports malformed TOML with the line it was found on
+type TOMLError struct {
+	Line int
+	Msg  string
+}
+
+func (e *TOMLError) Error() string {
+	return fmt.Sprintf("toml: line %d: %s", e.Line, e.Msg)
+}
+
+type tomlParser struct {
+	s       string
+	i       int
+	root    *Record
+	defined map[string]bool // tables given by a [header], which may not repeat
+}
+
+// ParseTOML decodes a TOML document into a record. Dates and datetimes
+// become datetimes, local times of day are kept as their text.
+func ParseTOML(data []byte) (Value, error) {
+	p := &tomlParser{s: strings.ReplaceAll(string(data), "\r\n", "\n"), root: NewRecord(), defined: make(map[string]bool)}
+	current := p.root
+	for {
+		p.skipBlank()
+		if p.i >= len(p.s) {
+			return tidyTOML(p.root), nil
+		}
+		if p.s[p.i] == '[' {
+			array := strings.HasPrefix(p.s[p.i:], "[[")
+			if array {
+				p.i += 2
+			} else {
+				p.i++
+			}
+			path, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.space()
+			close := "]"
+			if array {
+				close = "]]"
+			}
+			if !strings.HasPrefix(p.s[p.i:], close) {
+				return nil, p.errorf("expected '%s' after table name", close)
+			}
+			p.i += len(close)
+			if current, err = p.table(path, array); err != nil {
+				return nil, err
+			}
+		} else {
+			path, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.space()
+			if p.i >= len(p.s) || p.s[p.i] != '=' {
+				return nil, p.errorf("expected '=' after key '%s'", strings.Join(path, "."))
+			}
+			p.i++
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.assign(current, path, v); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return &TOMLError{Line: strings.Count(p.s[:p.i], "\n") + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *tomlParser) space() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// skipBlank moves past whitespace, newlines and comments
+func (p *tomlParser) skipBlank() {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case ' ', '\t', '\n':
+			p.i++
+		case '#':
+			for p.i < len(p.s) && p.s[p.i] != '\n' {
+				p.i++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.space()
+	if p.i < len(p.s) && p.s[p.i] == '#' {
+		for p.i < len(p.s) && p.s[p.i] != '\n' {
+			p.i++
+		}
+	}
+	if p.i < len(p.s) && p.s[p.i] != '\n' {
+		return p.errorf("unexpected %q at the end of the line", p.s[p.i:p.i+1])
+	}
+	return nil
+}
+
+func bareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// key reads a dotted key such as a."b c".d
+func (p *tomlParser) key() ([]string, error) {
+	var path []string
+	for {
+		p.space()
+		if p.i >= len(p.s) {
+			return nil, p.errorf("expected a key")
+		}
+		switch c := p.s[p.i]; {
+		case c == '"' || c == '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, s)
+		case bareKeyChar(c):
+			start := p.i
+			for p.i < len(p.s) && bareKeyChar(p.s[p.i]) {
+				p.i++
+			}
+			path = append(path, p.s[start:p.i])
+		default:
+			return nil, p.errorf("invalid key character %q", string(c))
+		}
+		p.space()
+		if p.i < len(p.s) && p.s[p.i] == '.' {
+			p.i++
+			continue
+		}
+		return path, nil
+	}
+}
+
+// table finds or makes the table a [header] or [[header]] names
+func (p *tomlParser) table(path []string, array bool) (*Record, error) {
+	r := p.root
+	for i, k := range path {
+		last := i == len(path)-1
+		v, ok := r.Get(k)
+		switch {
+		case last && array:
+			next := NewRecord()
+			switch x := v.(type) {
+			case nil:
+				r.Set(k, List{next})
+			case List:
+				r.Set(k, append(x, next))
+			case *Table:
+				r.Set(k, List(append(Items(x), next)))
+			default:
+				return nil, p.errorf("'%s' is already defined and is not an array of tables", strings.Join(path[:i+1], "."))
+			}
+			return next, nil
+		case !ok:
+			next := NewRecord()
+			r.Set(k, next)
+			r = next
+		default:
+			switch x := v.(type) {
+			case *Record:
+				r = x
+			case List, *Table:
+				items := Items(x)
+				if len(items) == 0 {
+					return nil, p.errorf("'%s' is not a table", strings.Join(path[:i+1], "."))
+				}
+				last, isRecord := items[len(items)-1].(*Record)
+				if !isRecord {
+					return nil, p.errorf("'%s' is not a table", strings.Join(path[:i+1], "."))
+				}
+				r = last
+			default:
+				return nil, p.errorf("'%s' is already defined as a %s", strings.Join(path[:i+1], "."), v.Kind())
+			}
+		}
+	}
+	name := strings.Join(path, "\x00")
+	if p.defined[name] {
+		return nil, p.errorf("table '%s' is defined twice", strings.Join(path, "."))
+	}
+	p.defined[name] = true
+	return r, nil
+}
+
+// tidyTOML turns the arrays of tables built while parsing into tables
+func tidyTOML(v Value) Value {
+	switch x := v.(type) {
+	case *Record:
+		for _, k := range x.Keys() {
+			fv, _ := x.Get(k)
+			x.Set(k, tidyTOML(fv))
+		}
+	case List:
+		for i, item := range x {
+			x[i] = tidyTOML(item)
+		}
+		return NewList(x)
+	}
+	return v
+}
+
+// assign sets a dotted key within a table
+func (p *tomlParser) assign(r *Record, path []string, v Value) error {
+	for i, k := range path[:len(path)-1] {
+		existing, ok := r.Get(k)
+		if !ok {
+			next := NewRecord()
+			r.Set(k, next)
+			r = next
+			continue
+		}
+		next, isRecord := existing.(*Record)
+		if !isRecord {
+			return p.errorf("'%s' is already defined as a %s", strings.Join(path[:i+1], "."), existing.Kind())
+		}
+		r = next
+	}
+	k := path[len(path)-1]
+	if _, ok := r.Get(k); ok {
+		return p.errorf("key '%s' is defined twice", strings.Join(path, "."))
+	}
+	r.Set(k, v)
+	return nil
+}
+
+var (
+	tomlDate   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?([Zz]|[-+]\d{2}:\d{2})?)?`)
+	tomlTime   = regexp.MustCompile(`^\d{2}:\d{2}:\d{2}(\.\d+)?`)
+	tomlNumber = regexp.MustCompile(`^[-+]?(0x[0-9a-fA-F_]+|0o[0-7_]+|0b[01_]+|inf|nan|[0-9][0-9_]*(\.[0-9_]+)?([eE][-+]?[0-9_]+)?)`)
+)
+
+func (p *tomlParser) value() (Value, error) {
+	p.space()
+	if p.i >= len(p.s) {
+		return nil, p.errorf("expected a value")
+	}
+	rest := p.s[p.i:]
+	switch c := rest[0]; {
+	case c == '"' || c == '\'':
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return String(s), nil
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inline()
+	case strings.HasPrefix(rest, "true"):
+		p.i += 4
+		return Bool(true), nil
+	case strings.HasPrefix(rest, "false"):
+		p.i += 5
+		return Bool(false), nil
+	}
+	if m := tomlDate.FindString(rest); m != "" {
+		p.i += len(m)
+		// TOML also allows a lower case t or a space between date and time
+		s := strings.ToUpper(m)
+		if len(s) > 10 {
+			s = s[:10] + "T" + s[11:]
+		}
+		t, err := ParseDatetime(s)
+		if err != nil {
+			return nil, p.errorf("invalid datetime '%s'", m)
+		}
+		return t, nil
+	}
+	if m := tomlTime.FindString(rest); m != "" {
+		p.i += len(m)
+		return String(m), nil
+	}
+	if m := tomlNumber.FindString(rest); m != "" {
+		p.i += len(m)
+		clean := strings.ReplaceAll(m, "_", "")
+		unsigned := strings.TrimLeft(clean, "+-")
+		switch {
+		case strings.HasSuffix(clean, "inf"):
+			if clean[0] == '-' {
+				return Number(math.Inf(-1)), nil
+			}
+			return Number(math.Inf(1)), nil
+		case strings.HasSuffix(clean, "nan"):
+			return Number(math.NaN()), nil
+		case strings.HasPrefix(unsigned, "0x"), strings.HasPrefix(unsigned, "0o"), strings.HasPrefix(unsigned, "0b"):
+			n, err := strconv.ParseInt(clean, 0, 64)
+			if err != nil {
+				return nil, p.errorf("invalid number '%s'", m)
+			}
+			return Number(n), nil
+		}
+		if len(unsigned) > 1 && unsigned[0] == '0' && unsigned[1] != '.' && unsigned[1] != 'e' && unsigned[1] != 'E' {
+			return nil, p.errorf("leading zeros are not allowed in '%s'", m)
+		}
+		n, err := strconv.ParseFloat(clean, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number '%s'", m)
+		}
+		return Number(n), nil
+	}
+	end := strings.IndexAny(rest, " \t\n#,]}")
+	if end < 0 {
+		end = len(rest)
+	}
+	return nil, p.errorf("invalid value '%s' (quote strings)", rest[:end])
+}
+
+func (p *tomlParser) array() (Value, error) {
+	p.i++ // [
+	var items []Value
+	for {
+		p.skipBlank()
+		if p.i < len(p.s) && p.s[p.i] == ']' {
+			p.i++
+			return NewList(items), nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		p.skipBlank()
+		if p.i < len(p.s) && p.s[p.i] == ',' {
+			p.i++
+			continue
+		}
+		if p.i < len(p.s) && p.s[p.i] == ']' {
+			p.i++
+			return NewList(items), nil
+		}
+		return nil, p.errorf("expected ',' or ']' in array")
+	}
+}
+
+func (p *tomlParser) inline() (Value, error) {
+	p.i++ // {
+	r := NewRecord()
+	p.space()
+	if p.i < len(p.s) && p.s[p.i] == '}' {
+		p.i++
+		return r, nil
+	}
+	for {
+		path, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.space()
+		if p.i >= len(p.s) || p.s[p.i] != '=' {
+			return nil, p.errorf("expected '=' after key '%s'", strings.Join(path, "."))
+		}
+		p.i++
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.assign(r, path, v); err != nil {
+			return nil, err
+		}
+		p.space()
+		if p.i < len(p.s) && p.s[p.i] == ',' {
+			p.i++
+			continue
+		}
+		if p.i < len(p.s) && p.s[p.i] == '}' {
+			p.i++
+			return r, nil
+		}
+		return nil, p.errorf("expected ',' or '}' in inline table")
+	}
+}
+
+// str reads a basic, literal or multi-line string
+func (p *tomlParser) str() (string, error) {
+	q := p.s[p.i]
+	multi := strings.HasPrefix(p.s[p.i:], strings.Repeat(string(q), 3))
+	if multi {
+		p.i += 3
+		// a newline right after the opening quotes is not part of the string
+		if p.i < len(p.s) && p.s[p.i] == '\n' {
+			p.i++
+		}
+	} else {
+		p.i++
+	}
+	var b strings.Builder
+	for {
+		if p.i >= len(p.s) || (!multi && p.s[p.i] == '\n') {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.s[p.i]
+		if c == q {
+			if !multi {
+				p.i++
+				return b.String(), nil
+			}
+			if strings.HasPrefix(p.s[p.i:], strings.Repeat(string(q), 3)) {
+				// up to two quotes may sit right before the closing ones
+				extra := 0
+				for extra < 2 && strings.HasPrefix(p.s[p.i+3+extra:], string(q)) {
+					extra++
+				}
+				b.WriteString(strings.Repeat(string(q), extra))
+				p.i += 3 + extra
+				return b.String(), nil
+			}
+		}
+		if c != '\\' || q == '\'' {
+			b.WriteByte(c)
+			p.i++
+			continue
+		}
+		p.i++
+		if p.i >= len(p.s) {
+			return "", p.errorf("unterminated string")
+		}
+		switch e := p.s[p.i]; e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'e':
+			b.WriteByte(0x1b)
+		case '"', '\\':
+			b.WriteByte(e)
+		case 'u', 'U':
+			size := 4
+			if e == 'U' {
+				size = 8
+			}
+			if p.i+size >= len(p.s) {
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+			n, err := strconv.ParseUint(p.s[p.i+1:p.i+1+size], 16, 32)
+			if err != nil {
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+			b.WriteRune(rune(n))
+			p.i += size
+		case ' ', '\t', '\n':
+			if !multi {
+				return "", p.errorf("invalid escape")
+			}
+			// a backslash at the end of a line trims the following whitespace
+			for p.i < len(p.s) && strings.IndexByte(" \t\n", p.s[p.i]) >= 0 {
+				p.i++
+			}
+			continue
+		default:
+			return "", p.errorf("invalid escape \\%c", e)
+		}
+		p.i++
+	}
+}
+
+// EncodeTOML writes a record as a TOML document. TOML has no null, so
+// null fields are left out.
+func EncodeTOML(v Value) ([]byte, error) {
+	r, ok := v.(*Record)
+	if !ok {
+		return nil, fmt.Errorf("toml needs a record at the top, got %s", v.Kind())
+	}
+	var b bytes.Buffer
+	writeTOMLTable(&b, r, nil)
+	return bytes.TrimLeft(b.Bytes(), "\n"), nil
+}
+
+func writeTOMLTable(b *bytes.Buffer, r *Record, path []string) {
+	var tables, arrays []string
+	for _, k := range r.Keys() {
+		v, _ := r.Get(k)
+		switch {
+		case IsNothing(v):
+		case isTOMLTable(v):
+			tables = append(tables, k)
+		case isTOMLArrayOfTables(v):
+			arrays = append(arrays, k)
+		default:
+			b.WriteString(tomlKey(k) + " = " + tomlInline(v) + "\n")
+		}
+	}
+	for _, k := range tables {
+		v, _ := r.Get(k)
+		sub := append(path[:len(path):len(path)], k)
+		child := v.(*Record)
+		if hasTOMLValues(child) || child.Len() == 0 {
+			b.WriteString("\n[" + tomlPath(sub) + "]\n")
+		}
+		writeTOMLTable(b, child, sub)
+	}
+	for _, k := range arrays {
+		v, _ := r.Get(k)
+		sub := append(path[:len(path):len(path)], k)
+		for _, item := range Items(v) {
+			b.WriteString("\n[[" + tomlPath(sub) + "]]\n")
+			writeTOMLTable(b, item.(*Record), sub)
+		}
+	}
+}
+
+func isTOMLTable(v Value) bool {
+	_, ok := v.(*Record)
+	return ok
+}
+
+func isTOMLArrayOfTables(v Value) bool {
+	switch v.(type) {
+	case List, *Table:
+	default:
+		return false
+	}
+	items := Items(v)
+	for _, item := range items {
+		if _, ok := item.(*Record); !ok {
+			return false
+		}
+	}
+	return len(items) > 0
+}
+
+// hasTOMLValues reports whether a table has fields written as key = value,
+// so that its header is needed
+func hasTOMLValues(r *Record) bool {
+	for _, k := range r.Keys() {
+		v, _ := r.Get(k)
+		if !IsNothing(v) && !isTOMLTable(v) && !isTOMLArrayOfTables(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func tomlKey(k string) string {
+	if k == "" {
+		return `""`
+	}
+	for i := 0; i < len(k); i++ {
+		if !bareKeyChar(k[i]) {
+			return tomlString(k)
+		}
+	}
+	return k
+}
+
+func tomlPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, k := range path {
+		parts[i] = tomlKey(k)
+	}
+	return strings.Join(parts, ".")
+}
+
+// tomlInline writes a value on one line, with records as inline tables
+func tomlInline(v Value) string {
+	switch x := v.(type) {
+	case Bool:
+		return strconv.FormatBool(bool(x))
+	case Number:
+		f := float64(x)
+		switch {
+		case math.IsInf(f, 1):
+			return "inf"
+		case math.IsInf(f, -1):
+			return "-inf"
+		case math.IsNaN(f):
+			return "nan"
+		}
+		return Text(x)
+	case Filesize:
+		return Text(x)
+	case Datetime:
+		// TOML has datetimes of its own
+		return x.String()
+	case *Record:
+		var parts []string
+		for _, k := range x.Keys() {
+			fv, _ := x.Get(k)
+			if !IsNothing(fv) {
+				parts = append(parts, tomlKey(k)+" = "+tomlInline(fv))
+			}
+		}
+		if len(parts) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(parts, ", ") + " }"
+	case List, *Table:
+		var parts []string
+		for _, item := range Items(x) {
+			if !IsNothing(item) {
+				parts = append(parts, tomlInline(item))
+			}
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return tomlString(Text(v))
+}
+
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, c)
+			} else {
+				b.WriteRune(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package values
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"tables", "title = \"x\"\n[server]\nhost = \"a\"\nport = 80\n[server.tls]\nenabled = true\n", `{"title":"x","server":{"host":"a","port":80,"tls":{"enabled":true}}}`},
+		{"array of tables", "[[hosts]]\nname = \"a\"\n[[hosts]]\nname = \"b\"\n", `{"hosts":[{"name":"a"},{"name":"b"}]}`},
+		{"inline tables", "list = [1, 2, 3]\npoint = { x = 1, y = { z = 2 } }\n", `{"list":[1,2,3],"point":{"x":1,"y":{"z":2}}}`},
+		{"quoting", "s = \"tab\\there \\\"q\\\"\"\nlit = 'C:\\path'\n\"key with space\" = 1\n", `{"s":"tab\there \"q\"","lit":"C:\\path","key with space":1}`},
+		{"multi-line strings", "m = \"\"\"\nline1\nline2\"\"\"\nl = '''\nraw \\n\nend'''\n", `{"m":"line1\nline2","l":"raw \\n\nend"}`},
+		{"numbers", "hex = 0xff\nbig = 1_000\nf = 1.5e3\n", `{"hex":255,"big":1000,"f":1500}`},
+		{"datetimes", "utc = 1979-05-27T07:32:00Z\noffset = 1979-05-27T00:32:00.5-07:00\nspace = 1979-05-27 07:32:00z\n", `{"utc":"1979-05-27T07:32:00Z","offset":"1979-05-27T00:32:00.5-07:00","space":"1979-05-27T07:32:00Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := ParseTOML([]byte(tt.src))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := jsonOf(v); got != tt.want {
+				t.Fatalf("parse = %s, want %s", got, tt.want)
+			}
+			enc, err := EncodeTOML(v)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			back, err := ParseTOML(enc)
+			if err != nil {
+				t.Fatalf("parse of encoded %q: %v", enc, err)
+			}
+			if got := jsonOf(back); got != tt.want {
+				t.Errorf("round trip = %s, want %s\nencoded:\n%s", got, tt.want, enc)
+			}
+		})
+	}
+}
+
+func TestTOMLDatetimes(t *testing.T) {
+	v, err := ParseTOML([]byte("odt = 1979-05-27T07:32:00Z\nld = 1979-05-27\nlt = 07:32:00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := v.(*Record)
+	odt, _ := r.Get("odt")
+	if d, ok := odt.(Datetime); !ok || !d.Time().Equal(time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC)) {
+		t.Errorf("odt = %#v, want a datetime", odt)
+	}
+	ld, _ := r.Get("ld")
+	if d, ok := ld.(Datetime); !ok || d.Time().Format("2006-01-02") != "1979-05-27" {
+		t.Errorf("ld = %#v, want a local date", ld)
+	}
+	if lt, _ := r.Get("lt"); lt != String("07:32:00") {
+		t.Errorf("lt = %#v, want the text of the time", lt)
+	}
+	if _, err := ParseTOML([]byte("bad = 1979-13-45\n")); err == nil {
+		t.Error("an invalid date parsed")
+	}
+}
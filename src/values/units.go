@@ -0,0 +1,194 @@
+package values
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filesize is a number of bytes
+type Filesize int64
+
+// Duration is a span of time
+type Duration time.Duration
+
+// Datetime is a point in time
+type Datetime time.Time
+
+func (Filesize) Kind() Kind { return KindFilesize }
+func (Duration) Kind() Kind { return KindDuration }
+func (Datetime) Kind() Kind { return KindDatetime }
+
+// Filesizes encode as a number of bytes, durations as Go duration text
+// such as "1h30m0s" and datetimes as RFC 3339 text, so JSON readers see
+// plain values
+func (f Filesize) MarshalJSON() ([]byte, error) { return []byte(strconv.FormatInt(int64(f), 10)), nil }
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(time.Duration(d).String()) }
+func (t Datetime) MarshalJSON() ([]byte, error) { return json.Marshal(t.String()) }
+
+func (d Duration) String() string { return time.Duration(d).String() }
+func (t Datetime) String() string { return time.Time(t).Format(time.RFC3339Nano) }
+
+// Time returns the datetime as a time.Time
+func (t Datetime) Time() time.Time { return time.Time(t) }
+
+// SizeUnits are the suffixes of a filesize, in bytes
+var SizeUnits = map[string]float64{
+	"b": 1, "kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12, "pb": 1e15,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40, "pib": 1 << 50,
+}
+
+// DurationUnits are the suffixes of a duration
+var DurationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond, "us": time.Microsecond, "µs": time.Microsecond, "ms": time.Millisecond,
+	"s": time.Second, "sec": time.Second, "m": time.Minute, "min": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "d": 24 * time.Hour, "day": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "wk": 7 * 24 * time.Hour,
+}
+
+// splitQuantity cuts text such as "1.5 GB" into its number and lower-case
+// unit
+func splitQuantity(s string) (float64, string, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(c rune) bool { return unicode.IsLetter(c) || c == 'µ' })
+	if i <= 0 {
+		return 0, "", fmt.Errorf("'%s' has no number and unit", s)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s[:i]), 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("'%s' is not a number", strings.TrimSpace(s[:i]))
+	}
+	return n, strings.ToLower(s[i:]), nil
+}
+
+// ParseFilesize reads a size such as "10mb", "1.5 GiB" or a plain number
+// of bytes
+func ParseFilesize(s string) (Filesize, error) {
+	if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		return Filesize(n), nil
+	}
+	n, unit, err := splitQuantity(s)
+	if err != nil {
+		return 0, err
+	}
+	scale, ok := SizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit '%s'", unit)
+	}
+	return Filesize(math.Round(n * scale)), nil
+}
+
+// ParseDuration reads a duration such as "2h", "1d 12h", "90s" or Go
+// duration text such as "1h30m0s"
+func ParseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return Duration(d), nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty duration")
+	}
+	var total time.Duration
+	for _, f := range fields {
+		n, unit, err := splitQuantity(f)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not a duration", s)
+		}
+		scale, ok := DurationUnits[unit]
+		if !ok {
+			return 0, fmt.Errorf("unknown duration unit '%s'", unit)
+		}
+		total += time.Duration(n * float64(scale))
+	}
+	return Duration(total), nil
+}
+
+// dateLayouts are the datetime formats ParseDatetime accepts
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+}
+
+// ParseDatetime reads a datetime in one of the common layouts. Times
+// without a zone are local.
+func ParseDatetime(s string) (Datetime, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return Datetime(t), nil
+		}
+	}
+	return Datetime{}, fmt.Errorf("'%s' is not a date", s)
+}
+
+// Human renders a filesize for reading, such as "1.2 MB"
+func (f Filesize) Human() string {
+	n := float64(f)
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	if n < 1000 {
+		return fmt.Sprintf("%s%d B", sign, int64(n))
+	}
+	units := []string{"KB", "MB", "GB", "TB", "PB", "EB"}
+	i := 0
+	for n /= 1000; n >= 999.95 && i < len(units)-1; i++ {
+		n /= 1000
+	}
+	return sign + strconv.FormatFloat(n, 'f', 1, 64) + " " + units[i]
+}
+
+// Human renders a duration for reading with its two largest units, such
+// as "2h 3m" or "4d 1h"
+func (d Duration) Human() string {
+	x := time.Duration(d)
+	sign := ""
+	if x < 0 {
+		sign, x = "-", -x
+	}
+	if x < time.Second {
+		return sign + x.String()
+	}
+	parts := []struct {
+		unit string
+		size time.Duration
+	}{
+		{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}, {"s", time.Second},
+	}
+	var out []string
+	for _, p := range parts {
+		if n := x / p.size; n > 0 || len(out) > 0 {
+			if n > 0 {
+				out = append(out, fmt.Sprintf("%d%s", n, p.unit))
+			}
+			x -= n * p.size
+			if len(out) == 2 || n == 0 && len(out) > 0 {
+				break
+			}
+		}
+	}
+	return sign + strings.Join(out, " ")
+}
+
+// Human renders a datetime for reading in local time, without the time
+// of day at midnight
+func (t Datetime) Human() string {
+	lt := time.Time(t).Local()
+	if lt.Hour() == 0 && lt.Minute() == 0 && lt.Second() == 0 && lt.Nanosecond() == 0 {
+		return lt.Format("2006-01-02")
+	}
+	return lt.Format("2006-01-02 15:04:05")
+}
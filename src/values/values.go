@@ -0,0 +1,349 @@
+package values
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind names the type of a value
+type Kind int
+
+const (
+	KindNothing Kind = iota
+	KindBool
+	KindNumber
+	KindString
+	KindBinary
+	KindList
+	KindRecord
+	KindTable
+	KindError
+	KindFilesize
+	KindDuration
+	KindDatetime
+)
+
+var kindNames = [...]string{"nothing", "bool", "number", "string", "binary", "list", "record", "table", "error", "filesize", "duration", "datetime"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// Value is the data passed between pipeline stages. It is serialized to
+// text only where it leaves netxp: externals, modules, files and the
+// terminal.
+type Value interface {
+	Kind() Kind
+}
+
+// Nothing is the absence of a value, such as the output of a command that
+// prints nothing
+type Nothing struct{}
+
+// Bool is a boolean
+type Bool bool
+
+// Number is any JSON number
+type Number float64
+
+// String is text
+type String string
+
+// Binary is raw bytes that are not valid text
+type Binary []byte
+
+// List is an ordered list of values. A list of records is a Table.
+type List []Value
+
+func (Nothing) Kind() Kind { return KindNothing }
+func (Bool) Kind() Kind    { return KindBool }
+func (Number) Kind() Kind  { return KindNumber }
+func (String) Kind() Kind  { return KindString }
+func (Binary) Kind() Kind  { return KindBinary }
+func (List) Kind() Kind    { return KindList }
+
+func (Nothing) MarshalJSON() ([]byte, error) { return []byte("null"), nil }
+
+// IsNothing reports whether v is Nothing or missing altogether
+func IsNothing(v Value) bool {
+	if v == nil {
+		return true
+	}
+	_, ok := v.(Nothing)
+	return ok
+}
+
+// Record is a set of named fields that keeps the order of its keys
+type Record struct {
+	keys   []string
+	fields map[string]Value
+}
+
+func (*Record) Kind() Kind { return KindRecord }
+
+// NewRecord creates a record from alternating keys and values. Values
+// that are not a Value are converted with FromGo.
+func NewRecord(kv ...interface{}) *Record {
+	r := &Record{fields: make(map[string]Value)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		r.Set(fmt.Sprint(kv[i]), FromGo(kv[i+1]))
+	}
+	return r
+}
+
+// Get returns a field
+func (r *Record) Get(key string) (Value, bool) {
+	v, ok := r.fields[key]
+	return v, ok
+}
+
+// Set adds a field at the end or replaces it in place
+func (r *Record) Set(key string, v Value) {
+	if _, ok := r.fields[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.fields[key] = v
+}
+
+// Delete removes a field
+func (r *Record) Delete(key string) {
+	if _, ok := r.fields[key]; !ok {
+		return
+	}
+	delete(r.fields, key)
+	for i, k := range r.keys {
+		if k == key {
+			r.keys = append(r.keys[:i:i], r.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the field names in order
+func (r *Record) Keys() []string {
+	return r.keys
+}
+
+// Len returns the number of fields
+func (r *Record) Len() int {
+	return len(r.keys)
+}
+
+// Copy returns a shallow copy of the record
+func (r *Record) Copy() *Record {
+	c := &Record{keys: append([]string{}, r.keys...), fields: make(map[string]Value, len(r.fields))}
+	for k, v := range r.fields {
+		c.fields[k] = v
+	}
+	return c
+}
+
+func (r *Record) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range r.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		val, err := json.Marshal(r.fields[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Table is a list of records. Columns holds every key of every row in
+// order of first appearance; a row may lack some of them.
+type Table struct {
+	Columns []string
+	Rows    []*Record
+}
+
+func (*Table) Kind() Kind { return KindTable }
+
+// NewTable builds a table and its columns from rows
+func NewTable(rows []*Record) *Table {
+	t := &Table{Rows: rows}
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for _, k := range row.keys {
+			if !seen[k] {
+				seen[k] = true
+				t.Columns = append(t.Columns, k)
+			}
+		}
+	}
+	return t
+}
+
+func (t *Table) MarshalJSON() ([]byte, error) {
+	if len(t.Rows) == 0 {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(t.Rows)
+}
+
+// Error is a structured error from a command. As a value it travels down
+// a pipeline in place of a result.
+type Error struct {
+	Command string      `json:"command"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Hints   []string    `json:"hints,omitempty"`
+	Context interface{} `json:"context,omitempty"`
+}
+
+func (*Error) Kind() Kind { return KindError }
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Command, e.Message)
+}
+
+// NewList returns a table when every item is a record and a list
+// otherwise
+func NewList(items []Value) Value {
+	if len(items) == 0 {
+		return List{}
+	}
+	rows := make([]*Record, 0, len(items))
+	for _, item := range items {
+		r, ok := item.(*Record)
+		if !ok {
+			return List(items)
+		}
+		rows = append(rows, r)
+	}
+	return NewTable(rows)
+}
+
+// Collect turns the values produced by a stage into one: nothing, the
+// single value, or a list (or table) of them
+func Collect(items []Value) Value {
+	switch len(items) {
+	case 0:
+		return Nothing{}
+	case 1:
+		return items[0]
+	}
+	return NewList(items)
+}
+
+// CollectRows turns the records produced one by one by a streaming stage
+// into one value. Records always make a table or list, even one; no rows
+// make an empty table, so that column commands still apply to it. Lines of
+// text collapse as Collect does. An error among them, which the stage
+// failed with, is the value.
+func CollectRows(items []Value) Value {
+	text := true
+	for _, item := range items {
+		switch item.(type) {
+		case *Error:
+			return item
+		case String:
+		default:
+			text = false
+		}
+	}
+	if len(items) == 0 {
+		return NewTable(nil)
+	}
+	if text {
+		return Collect(items)
+	}
+	return NewList(items)
+}
+
+// Items returns the elements of a list or the rows of a table. Any other
+// value is a single item; Nothing has none.
+func Items(v Value) []Value {
+	switch x := v.(type) {
+	case nil, Nothing:
+		return nil
+	case List:
+		return x
+	case *Table:
+		items := make([]Value, len(x.Rows))
+		for i, r := range x.Rows {
+			items[i] = r
+		}
+		return items
+	}
+	return []Value{v}
+}
+
+// Identity is a key under which equal values collide, for grouping,
+// counting distinct values and matching rows
+func Identity(v Value) string {
+	return v.Kind().String() + "\x00" + Text(v)
+}
+
+// Text renders a value as a single argument: text as is, numbers and
+// bools in their plain form, filesizes in bytes, durations and datetimes
+// as Go duration and RFC 3339 text and structured values as compact JSON
+func Text(v Value) string {
+	switch x := v.(type) {
+	case nil, Nothing:
+		return ""
+	case String:
+		return string(x)
+	case Binary:
+		return string(x)
+	case Bool:
+		return strconv.FormatBool(bool(x))
+	case Number:
+		return strconv.FormatFloat(float64(x), 'f', -1, 64)
+	case Filesize:
+		return strconv.FormatInt(int64(x), 10)
+	case Duration:
+		return x.String()
+	case Datetime:
+		return x.String()
+	case *Error:
+		return x.Error()
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Lines returns the text lines of a value for line-oriented commands:
+// the lines of a string and one line per item of a list or table
+func Lines(v Value) []string {
+	switch x := v.(type) {
+	case nil, Nothing:
+		return nil
+	case String:
+		return splitLines(string(x))
+	case Binary:
+		return splitLines(string(x))
+	case List, *Table:
+		var lines []string
+		for _, item := range Items(x) {
+			lines = append(lines, Lines(item)...)
+		}
+		return lines
+	}
+	return []string{Text(v)}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
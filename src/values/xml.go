@@ -0,0 +1,204 @@
+package values
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XML maps onto records: an element is a record of its attributes, under
+// "@name", its child elements by tag, repeated tags as a list, and its
+// text under "#text". An element with only text is that text, typed the
+// way CSV cells are, and an empty element is null. The document is a
+// record with the root element as its only field.
+
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     strings.Builder
+}
+
+// ParseXML decodes an XML document
+func ParseXML(data []byte, infer func(string) Value) (Value, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Entity = xml.HTMLEntity
+	var stack []*xmlNode
+	var root *xmlNode
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local, attrs: t.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root != nil {
+				line, _ := d.InputPos()
+				return nil, fmt.Errorf("xml: line %d: a second root element <%s>", line, n.name)
+			} else {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+	if root == nil {
+		return Nothing{}, nil
+	}
+	return NewRecord(root.name, xmlValue(root, infer)), nil
+}
+
+func xmlValue(n *xmlNode, infer func(string) Value) Value {
+	text := strings.TrimSpace(n.text.String())
+	if len(n.attrs) == 0 && len(n.children) == 0 {
+		if text == "" {
+			return Nothing{}
+		}
+		return infer(text)
+	}
+	r := NewRecord()
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+			continue
+		}
+		r.Set("@"+a.Name.Local, infer(a.Value))
+	}
+	var order []string
+	groups := make(map[string][]Value)
+	for _, c := range n.children {
+		if _, ok := groups[c.name]; !ok {
+			order = append(order, c.name)
+		}
+		groups[c.name] = append(groups[c.name], xmlValue(c, infer))
+	}
+	for _, name := range order {
+		if g := groups[name]; len(g) == 1 {
+			r.Set(name, g[0])
+		} else {
+			r.Set(name, NewList(g))
+		}
+	}
+	if text != "" {
+		r.Set("#text", infer(text))
+	}
+	return r
+}
+
+// EncodeXML writes a value as an indented XML document. A record with a
+// single field gives the root element; anything else is wrapped in
+// <root>, with the items of a list or table as <item> elements.
+func EncodeXML(v Value) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	switch v.(type) {
+	case List, *Table:
+		writeXML(&b, "root", NewRecord("item", v), 0)
+		return b.Bytes()
+	}
+	if r, ok := v.(*Record); ok && r.Len() == 1 {
+		k := r.Keys()[0]
+		fv, _ := r.Get(k)
+		if _, isList := fv.(List); !isList {
+			if _, isTable := fv.(*Table); !isTable {
+				writeXML(&b, k, fv, 0)
+				return b.Bytes()
+			}
+		}
+	}
+	writeXML(&b, "root", v, 0)
+	return b.Bytes()
+}
+
+func writeXML(b *bytes.Buffer, name string, v Value, depth int) {
+	pad := strings.Repeat("  ", depth)
+	name = xmlName(name)
+	switch x := v.(type) {
+	case List, *Table:
+		for _, item := range Items(x) {
+			writeXML(b, name, item, depth)
+		}
+		return
+	case *Record:
+		b.WriteString(pad + "<" + name)
+		var children []string
+		text := ""
+		for _, k := range x.Keys() {
+			fv, _ := x.Get(k)
+			switch {
+			case strings.HasPrefix(k, "@"):
+				b.WriteString(" " + xmlName(k[1:]) + `="` + xmlAttrEscaper.Replace(xmlText(fv)) + `"`)
+			case k == "#text":
+				text = xmlText(fv)
+			default:
+				children = append(children, k)
+			}
+		}
+		if len(children) == 0 && text == "" {
+			b.WriteString("/>\n")
+			return
+		}
+		b.WriteString(">" + xmlTextEscaper.Replace(text))
+		if len(children) > 0 {
+			b.WriteString("\n")
+			for _, k := range children {
+				fv, _ := x.Get(k)
+				writeXML(b, k, fv, depth+1)
+			}
+			b.WriteString(pad)
+		}
+		b.WriteString("</" + name + ">\n")
+		return
+	}
+	if IsNothing(v) {
+		b.WriteString(pad + "<" + name + "/>\n")
+		return
+	}
+	b.WriteString(pad + "<" + name + ">" + xmlTextEscaper.Replace(xmlText(v)) + "</" + name + ">\n")
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\n", "&#xA;", "\r", "&#xD;", "\t", "&#x9;")
+)
+
+func xmlText(v Value) string {
+	if IsNothing(v) {
+		return ""
+	}
+	return Text(v)
+}
+
+// xmlName makes a field name usable as a tag by replacing characters
+// that names may not contain
+func xmlName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, c := range s {
+		ok := c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c > 0x7f
+		if i > 0 {
+			ok = ok || c == '-' || c == '.' || c >= '0' && c <= '9'
+		}
+		if ok {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package values
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// inferXML types numbers like the from-xml builtin and keeps other text
+func inferXML(s string) Value {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return Number(n)
+	}
+	return String(s)
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"nesting", `<config version="2"><server><host>a</host><port>80</port></server></config>`, `{"config":{"@version":2,"server":{"host":"a","port":80}}}`},
+		{"repeated tags", `<hosts><host>a</host><host>b</host><empty/></hosts>`, `{"hosts":{"host":["a","b"],"empty":null}}`},
+		{"escaping", `<m note="say &quot;hi&quot; &amp; go">a &lt; b &amp; "c"</m>`, `{"m":{"@note":"say \"hi\" \u0026 go","#text":"a \u003c b \u0026 \"c\""}}`},
+		{"multi-line text", "<m>line1\nline2</m>", `{"m":"line1\nline2"}`},
+		{"mixed text and children", `<p lang="en">hello<b>x</b></p>`, `{"p":{"@lang":"en","b":"x","#text":"hello"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := ParseXML([]byte(tt.src), inferXML)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := jsonOf(v); got != tt.want {
+				t.Fatalf("parse = %s, want %s", got, tt.want)
+			}
+			back, err := ParseXML(EncodeXML(v), inferXML)
+			if err != nil {
+				t.Fatalf("parse of encoded %q: %v", EncodeXML(v), err)
+			}
+			if got := jsonOf(back); got != tt.want {
+				t.Errorf("round trip = %s, want %s\nencoded:\n%s", got, tt.want, EncodeXML(v))
+			}
+		})
+	}
+}
+
+func TestXMLEncodeValues(t *testing.T) {
+	at := Datetime(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	rows := []*Record{NewRecord("name", "a", "n", 1), NewRecord("name", "b", "n", 2)}
+	tests := []struct {
+		name string
+		v    Value
+		want string
+	}{
+		{"table", NewTable(rows), `{"root":{"item":[{"name":"a","n":1},{"name":"b","n":2}]}}`},
+		{"list", List{String("x"), String("y")}, `{"root":{"item":["x","y"]}}`},
+		{"record of several fields", NewRecord("a", 1, "b", "x"), `{"root":{"a":1,"b":"x"}}`},
+		{"field holding a list", NewRecord("l", List{Number(1), Number(2)}), `{"root":{"l":[1,2]}}`},
+		{"datetime as text", NewRecord("at", at), `{"at":"2024-05-01T10:00:00Z"}`},
+		{"names that are not tags", NewRecord("r", NewRecord("a b", 1, "1x", 2)), `{"r":{"a_b":1,"_x":2}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			back, err := ParseXML(EncodeXML(tt.v), inferXML)
+			if err != nil {
+				t.Fatalf("parse of encoded %q: %v", EncodeXML(tt.v), err)
+			}
+			if got := jsonOf(back); got != tt.want {
+				t.Errorf("round trip = %s, want %s\nencoded:\n%s", got, tt.want, EncodeXML(tt.v))
+			}
+		})
+	}
+}
@@ -0,0 +1,839 @@
+package values
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The YAML support covers what configuration files use: block and flow
+// collections, plain and quoted scalars, literal and folded block scalars,
+// comments, several documents, anchors, aliases and << merge keys.
+// Tags are ignored.
+
+// YAMLError reports malformed YAML with the line it was found on
+type YAMLError struct {
+	Line int
+	Msg  string
+}
+
+func (e *YAMLError) Error() string {
+	return fmt.Sprintf("yaml: line %d: %s", e.Line, e.Msg)
+}
+
+type yamlLine struct {
+	num    int // 1-based
+	indent int
+	text   string // without indentation; comments are stripped lazily
+	raw    string
+}
+
+type yamlParser struct {
+	lines   []yamlLine
+	i       int
+	anchors map[string]Value
+}
+
+// ParseYAML decodes a YAML stream. Several documents give a list.
+func ParseYAML(data []byte) (Value, error) {
+	var docs []Value
+	var cur []yamlLine
+	flush := func(force bool) error {
+		if !force && blankYAML(cur) {
+			cur = nil
+			return nil
+		}
+		p := &yamlParser{lines: cur, anchors: make(map[string]Value)}
+		v, err := p.parseDocument()
+		if err != nil {
+			return err
+		}
+		docs = append(docs, v)
+		cur = nil
+		return nil
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\ufeff")
+	started := false
+	for n, raw := range strings.Split(text, "\n") {
+		trimmed := strings.TrimRight(raw, " \t")
+		switch {
+		case trimmed == "---" || strings.HasPrefix(trimmed, "--- "):
+			if started || !blankYAML(cur) {
+				if err := flush(started); err != nil {
+					return nil, err
+				}
+			}
+			started = true
+			if rest := strings.TrimSpace(strings.TrimPrefix(trimmed, "---")); rest != "" {
+				cur = append(cur, yamlLine{num: n + 1, text: rest, raw: rest})
+			}
+			continue
+		case trimmed == "...":
+			if err := flush(false); err != nil {
+				return nil, err
+			}
+			continue
+		case strings.HasPrefix(trimmed, "%"):
+			continue // directives
+		}
+		content := strings.TrimLeft(raw, " ")
+		cur = append(cur, yamlLine{num: n + 1, indent: len(raw) - len(content), text: strings.TrimRight(content, " \t"), raw: raw})
+	}
+	if err := flush(false); err != nil {
+		return nil, err
+	}
+	return Collect(docs), nil
+}
+
+func blankYAML(lines []yamlLine) bool {
+	for _, l := range lines {
+		if t := stripComment(l.text); t != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// stripComment removes a # comment that is not inside quotes
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'' && c == '\'':
+			quote = 0
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '"' && c == '"':
+			quote = 0
+		case quote != 0:
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" \t[{,:-", rune(s[i-1]))):
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimRight(s[:i], " \t")
+		}
+	}
+	return s
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if p.i < len(p.lines) {
+		line = p.lines[p.i].num
+	} else if len(p.lines) > 0 {
+		line = p.lines[len(p.lines)-1].num
+	}
+	return &YAMLError{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skip moves past blank and comment lines and reports whether a line is left
+func (p *yamlParser) skip() bool {
+	for p.i < len(p.lines) {
+		if stripComment(p.lines[p.i].text) != "" {
+			return true
+		}
+		p.i++
+	}
+	return false
+}
+
+func (p *yamlParser) parseDocument() (Value, error) {
+	if !p.skip() {
+		return Nothing{}, nil
+	}
+	v, err := p.parseNode(p.lines[p.i].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skip() {
+		return nil, p.errorf("unexpected content %q", stripComment(p.lines[p.i].text))
+	}
+	return v, nil
+}
+
+// parseNode parses the block node whose first line is the current one,
+// indented by exactly indent
+func (p *yamlParser) parseNode(indent int) (Value, error) {
+	l := p.lines[p.i]
+	text := stripComment(l.text)
+	if text == "-" || strings.HasPrefix(text, "- ") {
+		return p.parseSequence(l.indent)
+	}
+	if _, _, ok := splitKey(text); ok {
+		return p.parseMapping(l.indent)
+	}
+	// a scalar or flow collection, possibly continued on more lines
+	p.i++
+	for p.i < len(p.lines) {
+		next := p.lines[p.i]
+		t := stripComment(next.text)
+		if t == "" {
+			if !flowOpen(text) {
+				break
+			}
+			p.i++
+			continue
+		}
+		if next.indent < indent || (next.indent == indent && !flowOpen(text)) {
+			break
+		}
+		text += " " + t
+		p.i++
+	}
+	return p.scalarOrFlow(text)
+}
+
+func (p *yamlParser) parseSequence(indent int) (Value, error) {
+	var items []Value
+	for p.skip() {
+		l := p.lines[p.i]
+		text := stripComment(l.text)
+		if l.indent != indent || !(text == "-" || strings.HasPrefix(text, "- ")) {
+			if l.indent > indent {
+				return nil, p.errorf("bad indentation of a sequence entry")
+			}
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(text, "-"), " ")
+		if rest == "" {
+			p.i++
+			item, err := p.childNode(indent, true)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		// the entry continues on this line: parse it as if it started
+		// at the column after the dash
+		offset := indent + (len(l.text) - len(strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")))
+		p.lines[p.i] = yamlLine{num: l.num, indent: offset, text: strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " "), raw: l.raw}
+		item, err := p.parseAnchored(offset, indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return NewList(items), nil
+}
+
+// parseAnchored parses a node that may start with an &anchor or a tag.
+// The node starts at column indent of the current line; parent is the
+// indentation of the key or dash that owns it.
+func (p *yamlParser) parseAnchored(indent, parent int) (Value, error) {
+	l := p.lines[p.i]
+	text := stripComment(l.text)
+	anchor := ""
+	for strings.HasPrefix(text, "&") || strings.HasPrefix(text, "!") {
+		end := strings.IndexAny(text, " \t")
+		if end < 0 {
+			end = len(text)
+		}
+		if text[0] == '&' {
+			anchor = text[1:end]
+		}
+		text = strings.TrimLeft(text[end:], " \t")
+	}
+	var v Value
+	var err error
+	if text == "" {
+		p.i++
+		v, err = p.childNode(parent, false)
+	} else {
+		p.lines[p.i] = yamlLine{num: l.num, indent: indent, text: text, raw: l.raw}
+		v, err = p.parseNode(indent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if anchor != "" {
+		p.anchors[anchor] = v
+	}
+	return v, nil
+}
+
+// childNode parses the node nested under a key or dash at indent. A
+// mapping value may also be a sequence at the same indentation.
+func (p *yamlParser) childNode(indent int, inSequence bool) (Value, error) {
+	if !p.skip() {
+		return Nothing{}, nil
+	}
+	l := p.lines[p.i]
+	text := stripComment(l.text)
+	sameLevelSeq := !inSequence && l.indent == indent && (text == "-" || strings.HasPrefix(text, "- "))
+	if l.indent <= indent && !sameLevelSeq {
+		return Nothing{}, nil
+	}
+	return p.parseAnchored(l.indent, indent)
+}
+
+func (p *yamlParser) parseMapping(indent int) (Value, error) {
+	r := NewRecord()
+	for p.skip() {
+		l := p.lines[p.i]
+		if l.indent != indent {
+			if l.indent > indent {
+				return nil, p.errorf("bad indentation of a mapping entry")
+			}
+			break
+		}
+		text := stripComment(l.text)
+		key, rest, ok := splitKey(text)
+		if !ok {
+			if text == "-" || strings.HasPrefix(text, "- ") {
+				break
+			}
+			return nil, p.errorf("expected 'key: value', found %q", text)
+		}
+		var v Value
+		var err error
+		switch {
+		case rest == "":
+			p.i++
+			v, err = p.childNode(indent, false)
+		case rest[0] == '|' || rest[0] == '>':
+			p.i++
+			v, err = p.blockScalar(indent, rest)
+		default:
+			offset := indent + len(text) - len(rest)
+			p.lines[p.i] = yamlLine{num: l.num, indent: offset, text: rest, raw: l.raw}
+			v, err = p.parseAnchored(offset, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key == "<<" {
+			for _, src := range Items(v) {
+				if m, ok := src.(*Record); ok {
+					for _, k := range m.Keys() {
+						if _, exists := r.Get(k); !exists {
+							fv, _ := m.Get(k)
+							r.Set(k, fv)
+						}
+					}
+				}
+			}
+			continue
+		}
+		r.Set(key, v)
+	}
+	return r, nil
+}
+
+// blockScalar reads a | or > scalar whose lines are indented past indent
+func (p *yamlParser) blockScalar(indent int, header string) (Value, error) {
+	folded := header[0] == '>'
+	chomp := byte(0)
+	for _, c := range header[1:] {
+		switch c {
+		case '-', '+':
+			chomp = byte(c)
+		}
+	}
+	var lines []string
+	block := -1
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if strings.TrimSpace(l.raw) == "" {
+			lines = append(lines, "")
+			p.i++
+			continue
+		}
+		if l.indent <= indent {
+			break
+		}
+		if block < 0 {
+			block = l.indent
+		}
+		if l.indent < block {
+			break
+		}
+		lines = append(lines, l.raw[block:])
+		p.i++
+	}
+	// trailing blank lines belong to the chomping, not the content
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
+	}
+	trailing := len(lines) - end
+	lines = lines[:end]
+	var text string
+	if folded {
+		var b strings.Builder
+		for i, line := range lines {
+			switch {
+			case i == 0:
+			case line == "" || lines[i-1] == "" || strings.HasPrefix(line, " "):
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(line)
+		}
+		text = b.String()
+	} else {
+		text = strings.Join(lines, "\n")
+	}
+	switch chomp {
+	case '-':
+	case '+':
+		text += "\n" + strings.Repeat("\n", trailing)
+	default:
+		if len(lines) > 0 {
+			text += "\n"
+		}
+	}
+	return String(text), nil
+}
+
+// splitKey splits "key: value" outside quotes and brackets
+func splitKey(text string) (string, string, bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' || text[0] == '-' && (len(text) == 1 || text[1] == ' ') {
+		return "", "", false
+	}
+	var quote byte
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ':' && depth == 0 && (i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\t'):
+			key := strings.TrimSpace(text[:i])
+			if key == "" {
+				return "", "", false
+			}
+			if (key[0] == '"' || key[0] == '\'') && len(key) >= 2 && key[len(key)-1] == key[0] {
+				s, err := unquoteYAML(key)
+				if err != nil {
+					return "", "", false
+				}
+				key = s
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// flowOpen reports whether a flow collection is still missing its close
+func flowOpen(text string) bool {
+	if text == "" || (text[0] != '[' && text[0] != '{') {
+		return false
+	}
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+func (p *yamlParser) scalarOrFlow(text string) (Value, error) {
+	if strings.HasPrefix(text, "*") {
+		name := text[1:]
+		v, ok := p.anchors[name]
+		if !ok {
+			return nil, p.errorf("unknown alias *%s", name)
+		}
+		return v, nil
+	}
+	if text != "" && (text[0] == '[' || text[0] == '{') {
+		f := &yamlFlow{s: text, p: p}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		f.space()
+		if f.i < len(f.s) {
+			return nil, p.errorf("unexpected %q after flow collection", f.s[f.i:])
+		}
+		return v, nil
+	}
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		s, err := unquoteYAML(text)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		return String(s), nil
+	}
+	return resolveYAML(text), nil
+}
+
+// yamlFlow parses a flow collection such as [a, {b: 1}]
+type yamlFlow struct {
+	s string
+	i int
+	p *yamlParser
+}
+
+func (f *yamlFlow) space() {
+	for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t') {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) value() (Value, error) {
+	f.space()
+	if f.i >= len(f.s) {
+		return nil, f.p.errorf("unexpected end of flow collection")
+	}
+	switch f.s[f.i] {
+	case '[':
+		f.i++
+		var items []Value
+		for {
+			f.space()
+			if f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return NewList(items), nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			if err := f.sep(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.i++
+		r := NewRecord()
+		for {
+			f.space()
+			if f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return r, nil
+			}
+			k, err := f.scalar(true)
+			if err != nil {
+				return nil, err
+			}
+			f.space()
+			var v Value = Nothing{}
+			if f.i < len(f.s) && f.s[f.i] == ':' {
+				f.i++
+				if v, err = f.value(); err != nil {
+					return nil, err
+				}
+			}
+			r.Set(Text(k), v)
+			if err := f.sep('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f.scalar(false)
+}
+
+// sep consumes a ',' or leaves the closing bracket for the caller
+func (f *yamlFlow) sep(close byte) error {
+	f.space()
+	if f.i < len(f.s) && f.s[f.i] == ',' {
+		f.i++
+		return nil
+	}
+	if f.i < len(f.s) && f.s[f.i] == close {
+		return nil
+	}
+	return f.p.errorf("expected ',' or '%c' in flow collection", close)
+}
+
+func (f *yamlFlow) scalar(key bool) (Value, error) {
+	f.space()
+	if f.i < len(f.s) && (f.s[f.i] == '"' || f.s[f.i] == '\'') {
+		q := f.s[f.i]
+		j := f.i + 1
+		for j < len(f.s) && f.s[j] != q {
+			if q == '"' && f.s[j] == '\\' {
+				j++
+			} else if q == '\'' && f.s[j] == '\'' && j+1 < len(f.s) && f.s[j+1] == '\'' {
+				j++
+			}
+			j++
+		}
+		if j >= len(f.s) {
+			return nil, f.p.errorf("unterminated quoted string")
+		}
+		s, err := unquoteYAML(f.s[f.i : j+1])
+		if err != nil {
+			return nil, f.p.errorf("%s", err)
+		}
+		f.i = j + 1
+		return String(s), nil
+	}
+	start := f.i
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		if c == ',' || c == ']' || c == '}' || (c == ':' && (key || f.i+1 == len(f.s) || f.s[f.i+1] == ' ')) {
+			break
+		}
+		f.i++
+	}
+	text := strings.TrimSpace(f.s[start:f.i])
+	if strings.HasPrefix(text, "*") {
+		return f.p.scalarOrFlow(text)
+	}
+	return resolveYAML(text), nil
+}
+
+// unquoteYAML decodes a single or double quoted scalar
+func unquoteYAML(s string) (string, error) {
+	if s[0] == '\'' {
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return "", fmt.Errorf("unterminated quoted string")
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if len(s) < 2 || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("unterminated quoted string")
+	}
+	var b strings.Builder
+	body := s[1 : len(s)-1]
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '\\' || i+1 == len(body) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch e := body[i]; e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		case 'x', 'u', 'U':
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+			if i+size >= len(body) {
+				return "", fmt.Errorf("invalid escape \\%c", e)
+			}
+			n, err := strconv.ParseUint(body[i+1:i+1+size], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape \\%c", e)
+			}
+			b.WriteRune(rune(n))
+			i += size
+		default:
+			b.WriteByte(e)
+		}
+	}
+	return b.String(), nil
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?(0|[1-9][0-9_]*)$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9][0-9_]*(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// resolveYAML gives a plain scalar its type
+func resolveYAML(s string) Value {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return Nothing{}
+	case "true", "True", "TRUE":
+		return Bool(true)
+	case "false", "False", "FALSE":
+		return Bool(false)
+	case ".inf", ".Inf", "+.inf":
+		return Number(math.Inf(1))
+	case "-.inf", "-.Inf":
+		return Number(math.Inf(-1))
+	}
+	clean := strings.ReplaceAll(s, "_", "")
+	switch {
+	case yamlInt.MatchString(s), yamlFloat.MatchString(s):
+		if n, err := strconv.ParseFloat(clean, 64); err == nil {
+			return Number(n)
+		}
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0o"):
+		if n, err := strconv.ParseInt(clean, 0, 64); err == nil {
+			return Number(n)
+		}
+	}
+	return String(s)
+}
+
+// EncodeYAML writes a value as a YAML document
+func EncodeYAML(v Value) []byte {
+	var b bytes.Buffer
+	writeYAML(&b, v, 0)
+	out := b.Bytes()
+	if len(out) == 0 || out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	return out
+}
+
+func writeYAML(b *bytes.Buffer, v Value, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch x := v.(type) {
+	case *Record:
+		if x.Len() == 0 {
+			b.WriteString(pad + "{}\n")
+			return
+		}
+		for _, k := range x.Keys() {
+			fv, _ := x.Get(k)
+			b.WriteString(pad + yamlScalar(String(k)) + ":")
+			writeYAMLChild(b, fv, indent)
+		}
+	case List, *Table:
+		items := Items(x)
+		if len(items) == 0 {
+			b.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range items {
+			b.WriteString(pad + "-")
+			if r, ok := item.(*Record); ok && r.Len() > 0 {
+				// the first field goes on the dash line
+				var nested bytes.Buffer
+				writeYAML(&nested, r, indent+2)
+				b.WriteString(" " + strings.TrimPrefix(nested.String(), pad+"  "))
+				continue
+			}
+			writeYAMLChild(b, item, indent)
+		}
+	default:
+		b.WriteString(pad + yamlScalar(v) + "\n")
+	}
+}
+
+// writeYAMLChild writes the value after a "key:" or "-"
+func writeYAMLChild(b *bytes.Buffer, v Value, indent int) {
+	switch x := v.(type) {
+	case *Record:
+		if x.Len() > 0 {
+			b.WriteString("\n")
+			writeYAML(b, x, indent+2)
+			return
+		}
+	case List, *Table:
+		if len(Items(x)) > 0 {
+			b.WriteString("\n")
+			writeYAML(b, x, indent+2)
+			return
+		}
+	case String:
+		if strings.Contains(string(x), "\n") && !strings.HasPrefix(string(x), " ") && !strings.ContainsAny(string(x), "\r\t") {
+			text := strings.TrimSuffix(string(x), "\n")
+			chomp := "-"
+			if strings.HasSuffix(string(x), "\n") {
+				chomp = ""
+			}
+			if !strings.HasSuffix(text, "\n") {
+				b.WriteString(" |" + chomp + "\n")
+				for _, line := range strings.Split(text, "\n") {
+					if line == "" {
+						b.WriteString("\n")
+					} else {
+						b.WriteString(strings.Repeat(" ", indent+2) + line + "\n")
+					}
+				}
+				return
+			}
+		}
+	}
+	var nested bytes.Buffer
+	writeYAML(&nested, v, 0)
+	b.WriteString(" " + nested.String())
+}
+
+// yamlScalar writes a scalar, quoting strings that would read back as
+// something else
+func yamlScalar(v Value) string {
+	switch x := v.(type) {
+	case nil, Nothing:
+		return "null"
+	case Bool:
+		return strconv.FormatBool(bool(x))
+	case Number:
+		f := float64(x)
+		switch {
+		case math.IsInf(f, 1):
+			return ".inf"
+		case math.IsInf(f, -1):
+			return "-.inf"
+		case math.IsNaN(f):
+			return ".nan"
+		}
+		return Text(x)
+	case Filesize:
+		return Text(x)
+	case *Record:
+		if x.Len() == 0 {
+			return "{}"
+		}
+	case List:
+		if len(x) == 0 {
+			return "[]"
+		}
+	}
+	s := Text(v)
+	if _, isString := resolveYAML(s).(String); !isString || needsYAMLQuotes(s) {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.Encode(s)
+		return strings.TrimSpace(b.String())
+	}
+	return s
+}
+
+func needsYAMLQuotes(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return true
+	}
+	for _, c := range s {
+		if c < ' ' {
+			return true
+		}
+	}
+	switch strings.ToLower(s) {
+	case "yes", "no", "on", "off", "y", "n", ".nan":
+		// YAML 1.1 readers take these as bools
+		return true
+	}
+	return false
+}
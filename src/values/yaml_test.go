@@ -0,0 +1,71 @@
+package values
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// jsonOf renders a value as compact JSON for comparisons
+func jsonOf(v Value) string {
+	return strings.TrimSpace(string(Encode(v)))
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"nesting", "a:\n  b:\n    c: 1\n  list:\n    - x\n    - y\n", `{"a":{"b":{"c":1},"list":["x","y"]}}`},
+		{"list of records", "- name: a\n  n: 1\n- name: b\n  n: 2\n", `[{"name":"a","n":1},{"name":"b","n":2}]`},
+		{"quoting", "s: \"a: b\"\nt: 'it''s'\nn: \"42\"\nempty: \"\"\nhash: \"x # y\"\n", `{"s":"a: b","t":"it's","n":"42","empty":"","hash":"x # y"}`},
+		{"literal block", "text: |\n  line one\n  line two\n", `{"text":"line one\nline two\n"}`},
+		{"folded block", "text: >\n  one\n  two\n", `{"text":"one two\n"}`},
+		{"flow collections", "r: {a: 1, b: [x, y]}\n", `{"r":{"a":1,"b":["x","y"]}}`},
+		{"scalars", "t: true\nf: 1.5\nz: null\n", `{"t":true,"f":1.5,"z":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := ParseYAML([]byte(tt.src))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := jsonOf(v); got != tt.want {
+				t.Fatalf("parse = %s, want %s", got, tt.want)
+			}
+			back, err := ParseYAML(EncodeYAML(v))
+			if err != nil {
+				t.Fatalf("parse of encoded %q: %v", EncodeYAML(v), err)
+			}
+			if got := jsonOf(back); got != tt.want {
+				t.Errorf("round trip = %s, want %s\nencoded:\n%s", got, tt.want, EncodeYAML(v))
+			}
+		})
+	}
+}
+
+func TestYAMLEncodeValues(t *testing.T) {
+	at := Datetime(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name string
+		v    Value
+		want string
+	}{
+		{"strings that look typed", NewRecord("n", "42", "b", "true", "nil", "null", "colon", "a: b"), `{"n":"42","b":"true","nil":"null","colon":"a: b"}`},
+		{"multi-line string", NewRecord("s", "one\ntwo"), `{"s":"one\ntwo"}`},
+		{"nested lists", NewRecord("l", List{List{Number(1), Number(2)}, NewRecord("a", 1)}), `{"l":[[1,2],{"a":1}]}`},
+		{"datetime as text", NewRecord("at", at), `{"at":"2024-05-01T10:00:00Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			back, err := ParseYAML(EncodeYAML(tt.v))
+			if err != nil {
+				t.Fatalf("parse of encoded %q: %v", EncodeYAML(tt.v), err)
+			}
+			if got := jsonOf(back); got != tt.want {
+				t.Errorf("round trip = %s, want %s\nencoded:\n%s", got, tt.want, EncodeYAML(tt.v))
+			}
+		})
+	}
+}